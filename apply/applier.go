@@ -5,45 +5,69 @@ import (
 
 	jsonpatch "github.com/evanphx/json-patch/v5"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
 )
 
-// We will need to eventualy have some set of options. These are not currently defined.
 type Applier struct {
+	// BestEffort applies every operation of a JSON patch that can be applied,
+	// the others are skipped and listed in the Report.
+	BestEffort bool
+	// Validator, when set, checks every object created by the Applier.
+	Validator Validator
+}
+
+// Report lists the operations skipped while applying a JSON patch in
+// BestEffort mode.
+type Report struct {
+	Skipped []*ApplyError
 }
 
 // Apply will assume that if white out file already exists this will not be called.
 // We will also assume that their is data in the patchedFileData and will check to make sure.
-// Returns a byte array of valid kubernetes resource JSON.
+// Returns a byte array of valid kubernetes resource JSON. Errors are *ApplyError.
 func (a Applier) Apply(u unstructured.Unstructured, patchFileData []byte) ([]byte, error) {
+	doc, _, err := a.ApplyWithReport(u, patchFileData)
+	return doc, err
+}
+
+// ApplyWithReport applies the JSON patch as Apply does and also returns the
+// operations skipped in BestEffort mode.
+func (a Applier) ApplyWithReport(u unstructured.Unstructured, patchFileData []byte) ([]byte, Report, error) {
+	report := Report{}
 
 	// Guard against invalid fileData
 	if len(patchFileData) == 0 {
-		return nil, fmt.Errorf("invalid patch file - no data")
+		return nil, report, newApplyError(ReasonInvalidPatch, fmt.Errorf("no data"))
 	}
 
-	// In the future, if there is more than json patches
-	// then we will need to handle the diff here. For now
-	// we don't have to.
-
-	// for now, pull out the patches.
+	// Merge patches are handled by ApplyPatch, this is only
+	// for json patches.
 
 	patch, err := jsonpatch.DecodePatch(patchFileData)
 	if err != nil {
-		// In the future we will need to wrap these errors, so that users can make the right choice based on the jsonpatch errors
-		return nil, err
+		return nil, report, newApplyError(ReasonInvalidPatch, err)
 	}
 
 	// Get json document from unstrucutred
 
 	doc, err := u.MarshalJSON()
 	if err != nil {
-		return nil, fmt.Errorf("invalid resource file - %v", err)
+		return nil, report, newApplyError(ReasonInvalidResource, err)
 	}
 
-	// Apply the rest of the patches
-	doc, err = patch.ApplyWithOptions(doc, &jsonpatch.ApplyOptions{EnsurePathExistsOnAdd: true, AllowMissingPathOnRemove: true})
-	if err != nil {
-		return nil, fmt.Errorf("unable to apply patches - %v", err)
+	// The whole patch is applied at once, operations are only applied one
+	// at a time to know which one failed, or to skip it in BestEffort mode.
+	options := &jsonpatch.ApplyOptions{EnsurePathExistsOnAdd: true, AllowMissingPathOnRemove: true}
+	if patched, err := patch.ApplyWithOptions(doc, options); err == nil {
+		doc = patched
+	} else {
+		doc, err = a.applyOperations(patch, doc, options, &report)
+		if err != nil {
+			return nil, report, err
+		}
 	}
 
 	//Validate the the doc can still be an unstrucutred Object.
@@ -52,8 +76,102 @@ func (a Applier) Apply(u unstructured.Unstructured, patchFileData []byte) ([]byt
 
 	err = u.UnmarshalJSON(doc)
 	if err != nil {
-		return nil, fmt.Errorf("unable to apply transformations to create a valid kubernetes object - %v", err)
+		return nil, report, newApplyError(ReasonInvalidObject, err)
+	}
+	if err := a.validate(u); err != nil {
+		return nil, report, err
+	}
+
+	doc, err = u.MarshalJSON()
+	if err != nil {
+		return nil, report, newApplyError(ReasonInvalidObject, err)
+	}
+	return doc, report, nil
+}
+
+// applyOperations applies the operations one at a time. The first failing
+// operation is returned as an error, in BestEffort mode it is added to the
+// report and the next operations are still applied.
+func (a Applier) applyOperations(patch jsonpatch.Patch, doc []byte, options *jsonpatch.ApplyOptions, report *Report) ([]byte, error) {
+	for i, operation := range patch {
+		patched, err := jsonpatch.Patch{operation}.ApplyWithOptions(doc, options)
+		if err != nil {
+			opErr := newOperationError(i, operation, err)
+			if !a.BestEffort {
+				return nil, opErr
+			}
+			report.Skipped = append(report.Skipped, opErr)
+			continue
+		}
+		doc = patched
+	}
+	return doc, nil
+}
+
+// ApplyPatch applies a patch of the given type, JSON patches are applied as
+// with Apply. Strategic merge patches need the Go type of the object, objects
+// of types not known to the client-go scheme get a JSON merge patch instead.
+// Returns a byte array of valid kubernetes resource JSON. Errors are *ApplyError.
+func (a Applier) ApplyPatch(u unstructured.Unstructured, patchData []byte, patchType types.PatchType) ([]byte, error) {
+	if patchType == types.JSONPatchType {
+		return a.Apply(u, patchData)
+	}
+
+	// Guard against invalid fileData
+	if len(patchData) == 0 {
+		return nil, newApplyError(ReasonInvalidPatch, fmt.Errorf("no data"))
+	}
+
+	doc, err := u.MarshalJSON()
+	if err != nil {
+		return nil, newApplyError(ReasonInvalidResource, err)
+	}
+
+	switch patchType {
+	case types.MergePatchType:
+		doc, err = jsonpatch.MergePatch(doc, patchData)
+	case types.StrategicMergePatchType:
+		doc, err = strategicMergePatch(u, doc, patchData)
+	default:
+		return nil, newApplyError(ReasonInvalidPatch, fmt.Errorf("unsupported patch type %v", patchType))
+	}
+	if err != nil {
+		return nil, newApplyError(ReasonInvalidPatch, err)
+	}
+
+	err = u.UnmarshalJSON(doc)
+	if err != nil {
+		return nil, newApplyError(ReasonInvalidObject, err)
+	}
+	if err := a.validate(u); err != nil {
+		return nil, err
 	}
 
-	return u.MarshalJSON()
+	doc, err = u.MarshalJSON()
+	if err != nil {
+		return nil, newApplyError(ReasonInvalidObject, err)
+	}
+	return doc, nil
+}
+
+func strategicMergePatch(u unstructured.Unstructured, doc, patchData []byte) ([]byte, error) {
+	dataStruct, err := scheme.Scheme.New(u.GroupVersionKind())
+	if runtime.IsNotRegisteredError(err) {
+		return jsonpatch.MergePatch(doc, patchData)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strategicpatch.StrategicMergePatch(doc, patchData, dataStruct)
+}
+
+func (a Applier) validate(u unstructured.Unstructured) error {
+	if a.Validator == nil {
+		return nil
+	}
+	errs := a.Validator.Validate(u)
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ApplyError{Reason: ReasonSchemaValidation, Index: -1, Fields: errs, Err: errs.ToAggregate()}
 }
@@ -4,8 +4,10 @@ import (
 	"reflect"
 	"testing"
 
+	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/konveyor/crane-lib/apply"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 func TestApplierApply(t *testing.T) {
@@ -114,3 +116,229 @@ func TestApplierApply(t *testing.T) {
 		})
 	}
 }
+
+func TestApplierApplyPatch(t *testing.T) {
+	deployment := unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"kind":       "Deployment",
+			"apiVersion": "apps/v1",
+			"metadata": map[string]interface{}{
+				"name": "test-deployment",
+			},
+			"spec": map[string]interface{}{
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{"name": "sidecar", "image": "sidecar:1"},
+							map[string]interface{}{"name": "app", "image": "app:1"},
+						},
+					},
+				},
+			},
+		},
+	}
+	custom := unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"kind":       "DumbThing",
+			"apiVersion": "test.io/v1",
+			"metadata": map[string]interface{}{
+				"name": "test-thing",
+			},
+			"spec": map[string]interface{}{
+				"items": []interface{}{"a", "b"},
+				"size":  "large",
+			},
+		},
+	}
+	cases := []struct {
+		Name      string
+		Object    unstructured.Unstructured
+		Patch     string
+		PatchType types.PatchType
+		ShouldErr bool
+		Expected  string
+	}{
+		{
+			Name:      "StrategicMergePatchByContainerName",
+			Object:    deployment,
+			Patch:     `{"spec": {"template": {"spec": {"containers": [{"name": "app", "image": "app:2"}]}}}}`,
+			PatchType: types.StrategicMergePatchType,
+			Expected:  `{"kind": "Deployment", "apiVersion": "apps/v1", "metadata": {"name": "test-deployment"}, "spec": {"template": {"spec": {"containers": [{"name": "sidecar", "image": "sidecar:1"}, {"name": "app", "image": "app:2"}]}}}}`,
+		},
+		{
+			Name:      "MergePatch",
+			Object:    custom,
+			Patch:     `{"spec": {"items": ["c"], "size": null}}`,
+			PatchType: types.MergePatchType,
+			Expected:  `{"kind": "DumbThing", "apiVersion": "test.io/v1", "metadata": {"name": "test-thing"}, "spec": {"items": ["c"]}}`,
+		},
+		{
+			Name:      "StrategicMergePatchUnknownType",
+			Object:    custom,
+			Patch:     `{"spec": {"size": "small"}}`,
+			PatchType: types.StrategicMergePatchType,
+			Expected:  `{"kind": "DumbThing", "apiVersion": "test.io/v1", "metadata": {"name": "test-thing"}, "spec": {"items": ["a", "b"], "size": "small"}}`,
+		},
+		{
+			Name:      "JSONPatch",
+			Object:    custom,
+			Patch:     `[{"op": "replace", "path": "/spec/size", "value": "small"}]`,
+			PatchType: types.JSONPatchType,
+			Expected:  `{"kind": "DumbThing", "apiVersion": "test.io/v1", "metadata": {"name": "test-thing"}, "spec": {"items": ["a", "b"], "size": "small"}}`,
+		},
+		{
+			Name:      "InvalidMergePatch",
+			Object:    custom,
+			Patch:     `{"spec": `,
+			PatchType: types.MergePatchType,
+			ShouldErr: true,
+		},
+		{
+			Name:      "UnsupportedPatchType",
+			Object:    custom,
+			Patch:     `{}`,
+			PatchType: types.ApplyPatchType,
+			ShouldErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			doc, err := apply.Applier{}.ApplyPatch(c.Object, []byte(c.Patch), c.PatchType)
+			if (err != nil) != c.ShouldErr {
+				t.Fatalf("unexpected error result - %v", err)
+			}
+			if c.ShouldErr {
+				return
+			}
+			if !jsonpatch.Equal(doc, []byte(c.Expected)) {
+				t.Errorf("Object did not match expected output - %s", doc)
+			}
+		})
+	}
+}
+
+func TestApplierApplyErrors(t *testing.T) {
+	object := unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"kind":       "DumbThing",
+			"apiVersion": "test.io/v1",
+			"metadata": map[string]interface{}{
+				"name": "test-thing",
+			},
+			"spec": map[string]interface{}{
+				"items": []interface{}{"a"},
+			},
+		},
+	}
+	cases := []struct {
+		Name           string
+		Patch          string
+		ExpectedReason apply.Reason
+		ExpectedIndex  int
+		ExpectedKind   string
+		ExpectedPath   string
+	}{
+		{
+			Name:           "InvalidPatch",
+			Patch:          `[}]`,
+			ExpectedReason: apply.ReasonInvalidPatch,
+			ExpectedIndex:  -1,
+		},
+		{
+			Name:           "MissingPath",
+			Patch:          `[{"op": "add", "path": "/metadata/name", "value": "new"}, {"op": "replace", "path": "/spec/missing", "value": "new"}]`,
+			ExpectedReason: apply.ReasonMissingPath,
+			ExpectedIndex:  1,
+			ExpectedKind:   "replace",
+			ExpectedPath:   "/spec/missing",
+		},
+		{
+			Name:           "InvalidIndex",
+			Patch:          `[{"op": "add", "path": "/spec/items/5", "value": "b"}]`,
+			ExpectedReason: apply.ReasonInvalidIndex,
+			ExpectedIndex:  0,
+			ExpectedKind:   "add",
+			ExpectedPath:   "/spec/items/5",
+		},
+		{
+			Name:           "TestFailed",
+			Patch:          `[{"op": "test", "path": "/metadata/name", "value": "other"}]`,
+			ExpectedReason: apply.ReasonTestFailed,
+			ExpectedIndex:  0,
+			ExpectedKind:   "test",
+			ExpectedPath:   "/metadata/name",
+		},
+		{
+			Name:           "InvalidObject",
+			Patch:          `[{"op": "remove", "path": "/kind"}]`,
+			ExpectedReason: apply.ReasonInvalidObject,
+			ExpectedIndex:  -1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			_, err := apply.Applier{}.Apply(*object.DeepCopy(), []byte(c.Patch))
+			applyErr, ok := err.(*apply.ApplyError)
+			if !ok {
+				t.Fatalf("expected an ApplyError, got: %v", err)
+			}
+			if applyErr.Reason != c.ExpectedReason || apply.ReasonForError(err) != c.ExpectedReason {
+				t.Errorf("expected reason %v, got: %v", c.ExpectedReason, applyErr.Reason)
+			}
+			if applyErr.Index != c.ExpectedIndex || applyErr.Kind != c.ExpectedKind || applyErr.Path != c.ExpectedPath {
+				t.Errorf("expected operation %v %v %v, got: %v %v %v", c.ExpectedIndex, c.ExpectedKind, c.ExpectedPath, applyErr.Index, applyErr.Kind, applyErr.Path)
+			}
+			if applyErr.Unwrap() == nil {
+				t.Errorf("expected a cause")
+			}
+		})
+	}
+}
+
+func TestApplierApplyBestEffort(t *testing.T) {
+	object := unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"kind":       "DumbThing",
+			"apiVersion": "test.io/v1",
+			"metadata": map[string]interface{}{
+				"name": "test-thing",
+			},
+		},
+	}
+	patch := `[
+		{"op": "test", "path": "/metadata/name", "value": "other"},
+		{"op": "add", "path": "/metadata/labels/app", "value": "test"},
+		{"op": "replace", "path": "/spec/missing", "value": "new"}
+	]`
+	expected := unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"kind":       "DumbThing",
+			"apiVersion": "test.io/v1",
+			"metadata": map[string]interface{}{
+				"name": "test-thing",
+				"labels": map[string]interface{}{
+					"app": "test",
+				},
+			},
+		},
+	}
+
+	doc, report, err := apply.Applier{BestEffort: true}.ApplyWithReport(object, []byte(patch))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	e, _ := expected.MarshalJSON()
+	if !jsonpatch.Equal(doc, e) {
+		t.Errorf("expected %s, got: %s", e, doc)
+	}
+	if len(report.Skipped) != 2 {
+		t.Fatalf("expected 2 skipped operations, got: %v", report.Skipped)
+	}
+	if report.Skipped[0].Index != 0 || !apply.IsTestFailed(report.Skipped[0]) {
+		t.Errorf("expected the failed test to be skipped, got: %v", report.Skipped[0])
+	}
+	if report.Skipped[1].Index != 2 || !apply.IsMissingPath(report.Skipped[1]) {
+		t.Errorf("expected the missing path to be skipped, got: %v", report.Skipped[1])
+	}
+}
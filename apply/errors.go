@@ -0,0 +1,115 @@
+package apply
+
+import (
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Reason is why a patch could not be applied.
+type Reason string
+
+const (
+	// ReasonInvalidPatch is used when the patch can not be decoded.
+	ReasonInvalidPatch Reason = "InvalidPatch"
+	// ReasonInvalidResource is used when the object can not be encoded.
+	ReasonInvalidResource Reason = "InvalidResource"
+	// ReasonMissingPath is used when an operation refers to a path that does
+	// not exist in the object.
+	ReasonMissingPath Reason = "MissingPath"
+	// ReasonInvalidIndex is used when an operation refers to an array index
+	// that does not exist.
+	ReasonInvalidIndex Reason = "InvalidIndex"
+	// ReasonTestFailed is used when a test operation does not match.
+	ReasonTestFailed Reason = "TestFailed"
+	// ReasonInvalidObject is used when the patched object is not a valid
+	// Kubernetes object.
+	ReasonInvalidObject Reason = "InvalidObject"
+	// ReasonSchemaValidation is used when the patched object does not match
+	// the schema checked by the Validator of the Applier.
+	ReasonSchemaValidation Reason = "SchemaValidation"
+	// ReasonUnknown is used for any other failure.
+	ReasonUnknown Reason = "Unknown"
+)
+
+// ApplyError is returned by the Applier. For errors caused by a single
+// operation of a JSON patch, Index, Kind and Path describe the operation,
+// otherwise Index is -1. Fields lists the invalid fields for schema
+// validation errors.
+type ApplyError struct {
+	Reason Reason
+	Index  int
+	Kind   string
+	Path   string
+	Fields field.ErrorList
+	Err    error
+}
+
+func (e *ApplyError) Error() string {
+	switch {
+	case e.Index >= 0:
+		return fmt.Sprintf("unable to apply patches - operation %d (%s %s) failed with %s: %v", e.Index, e.Kind, e.Path, e.Reason, e.Err)
+	case e.Reason == ReasonInvalidPatch:
+		return fmt.Sprintf("invalid patch file - %v", e.Err)
+	case e.Reason == ReasonInvalidResource:
+		return fmt.Sprintf("invalid resource file - %v", e.Err)
+	case e.Reason == ReasonSchemaValidation:
+		return fmt.Sprintf("transformed object does not match its schema - %v", e.Err)
+	case e.Reason == ReasonInvalidObject:
+		return fmt.Sprintf("unable to apply transformations to create a valid kubernetes object - %v", e.Err)
+	default:
+		return fmt.Sprintf("unable to apply patches - %v", e.Err)
+	}
+}
+
+func (e *ApplyError) Unwrap() error {
+	return e.Err
+}
+
+// ReasonForError returns the reason of an ApplyError, or ReasonUnknown for
+// any other error.
+func ReasonForError(err error) Reason {
+	var applyErr *ApplyError
+	if errors.As(err, &applyErr) {
+		return applyErr.Reason
+	}
+	return ReasonUnknown
+}
+
+func IsMissingPath(err error) bool {
+	return ReasonForError(err) == ReasonMissingPath
+}
+
+func IsTestFailed(err error) bool {
+	return ReasonForError(err) == ReasonTestFailed
+}
+
+func IsInvalidObject(err error) bool {
+	return ReasonForError(err) == ReasonInvalidObject
+}
+
+func IsSchemaValidation(err error) bool {
+	return ReasonForError(err) == ReasonSchemaValidation
+}
+
+func newApplyError(reason Reason, err error) *ApplyError {
+	return &ApplyError{Reason: reason, Index: -1, Err: err}
+}
+
+// newOperationError creates the error for the operation at index, the reason
+// is found from the error returned by jsonpatch.
+func newOperationError(index int, operation jsonpatch.Operation, err error) *ApplyError {
+	path, _ := operation.Path()
+	reason := ReasonUnknown
+	switch errors.Cause(err) {
+	case jsonpatch.ErrMissing:
+		reason = ReasonMissingPath
+	case jsonpatch.ErrInvalidIndex:
+		reason = ReasonInvalidIndex
+	case jsonpatch.ErrTestFailed:
+		reason = ReasonTestFailed
+	}
+	return &ApplyError{Reason: reason, Index: index, Kind: operation.Kind(), Path: path, Err: err}
+}
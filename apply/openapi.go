@@ -0,0 +1,272 @@
+package apply
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// quantityRef is the name of the resource.Quantity definition, quantities are
+// declared as strings but the API server also accepts numbers.
+const quantityRef = "io.k8s.apimachinery.pkg.api.resource.Quantity"
+
+// Validator checks an object created by the Applier.
+type Validator interface {
+	Validate(u unstructured.Unstructured) field.ErrorList
+}
+
+// OpenAPIValidator validates objects against the schemas of an OpenAPI v2
+// document, such as /openapi/v2, or of OpenAPI v3 documents, such as the ones
+// served under /openapi/v3. Objects of a kind without a schema are not
+// validated.
+type OpenAPIValidator struct {
+	definitions map[string]*openAPISchema
+	kinds       map[schema.GroupVersionKind]string
+}
+
+var _ Validator = &OpenAPIValidator{}
+
+type openAPIDocument struct {
+	Definitions map[string]*openAPISchema `json:"definitions"`
+	Components  struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+// openAPISchema is the subset of a schema used for validation.
+type openAPISchema struct {
+	Ref                   string                    `json:"$ref,omitempty"`
+	Type                  string                    `json:"type,omitempty"`
+	Format                string                    `json:"format,omitempty"`
+	Properties            map[string]*openAPISchema `json:"properties,omitempty"`
+	AdditionalProperties  *additionalProperties     `json:"additionalProperties,omitempty"`
+	Items                 *openAPISchema            `json:"items,omitempty"`
+	Required              []string                  `json:"required,omitempty"`
+	Enum                  []interface{}             `json:"enum,omitempty"`
+	AllOf                 []*openAPISchema          `json:"allOf,omitempty"`
+	IntOrString           bool                      `json:"x-kubernetes-int-or-string,omitempty"`
+	PreserveUnknownFields bool                      `json:"x-kubernetes-preserve-unknown-fields,omitempty"`
+	GroupVersionKinds     []struct {
+		Group   string `json:"group"`
+		Version string `json:"version"`
+		Kind    string `json:"kind"`
+	} `json:"x-kubernetes-group-version-kind,omitempty"`
+}
+
+// additionalProperties is either a boolean or a schema.
+type additionalProperties struct {
+	Allowed bool
+	Schema  *openAPISchema
+}
+
+func (a *additionalProperties) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &a.Allowed); err == nil {
+		return nil
+	}
+	a.Allowed = true
+	return json.Unmarshal(data, &a.Schema)
+}
+
+// LoadOpenAPIValidator reads OpenAPI v2 or v3 documents and creates the
+// validator for the schemas they define.
+func LoadOpenAPIValidator(files ...string) (*OpenAPIValidator, error) {
+	documents := [][]byte{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read OpenAPI document %v: %v", file, err)
+		}
+		documents = append(documents, data)
+	}
+	v, err := NewOpenAPIValidator(documents...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document in %v: %v", strings.Join(files, ", "), err)
+	}
+	return v, nil
+}
+
+// NewOpenAPIValidator creates the validator for the schemas defined by the
+// OpenAPI v2 or v3 documents, in JSON.
+func NewOpenAPIValidator(documents ...[]byte) (*OpenAPIValidator, error) {
+	v := &OpenAPIValidator{
+		definitions: map[string]*openAPISchema{},
+		kinds:       map[schema.GroupVersionKind]string{},
+	}
+	for _, data := range documents {
+		doc := openAPIDocument{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		if len(doc.Definitions) == 0 && len(doc.Components.Schemas) == 0 {
+			return nil, fmt.Errorf("no schema definitions found")
+		}
+		for name, s := range doc.Definitions {
+			v.add(name, s)
+		}
+		for name, s := range doc.Components.Schemas {
+			v.add(name, s)
+		}
+	}
+	return v, nil
+}
+
+func (v *OpenAPIValidator) add(name string, s *openAPISchema) {
+	v.definitions[name] = s
+	for _, gvk := range s.GroupVersionKinds {
+		v.kinds[schema.GroupVersionKind{Group: gvk.Group, Version: gvk.Version, Kind: gvk.Kind}] = name
+	}
+}
+
+// Validate returns an error for every field of the object that does not match
+// the schema of its kind.
+func (v *OpenAPIValidator) Validate(u unstructured.Unstructured) field.ErrorList {
+	name, ok := v.kinds[u.GroupVersionKind()]
+	if !ok {
+		return nil
+	}
+	return v.validateObject(nil, u.Object, v.definitions[name])
+}
+
+// resolve follows the reference of the schema, if any. The name of the
+// definition is returned with the schema.
+func (v *OpenAPIValidator) resolve(s *openAPISchema) (*openAPISchema, string) {
+	name := ""
+	for s != nil && s.Ref != "" {
+		name = s.Ref[strings.LastIndex(s.Ref, "/")+1:]
+		s = v.definitions[name]
+	}
+	return s, name
+}
+
+func (v *OpenAPIValidator) validate(path *field.Path, value interface{}, s *openAPISchema) field.ErrorList {
+	s, name := v.resolve(s)
+	// Unknown references are not validated
+	if s == nil || value == nil {
+		return nil
+	}
+
+	errs := field.ErrorList{}
+	for _, sub := range s.AllOf {
+		errs = append(errs, v.validate(path, value, sub)...)
+	}
+
+	switch {
+	case s.IntOrString || s.Format == "int-or-string":
+		if !isInteger(value) && !isString(value) {
+			return append(errs, field.Invalid(path, value, "expected an integer or a string"))
+		}
+	case name == quantityRef:
+		if !isNumber(value) && !isString(value) {
+			return append(errs, field.Invalid(path, value, "expected a quantity"))
+		}
+	case s.Type == "object" || (s.Type == "" && len(s.Properties) > 0):
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return append(errs, field.Invalid(path, value, "expected an object"))
+		}
+		errs = append(errs, v.validateObject(path, obj, s)...)
+	case s.Type == "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return append(errs, field.Invalid(path, value, "expected an array"))
+		}
+		for i, item := range items {
+			errs = append(errs, v.validate(path.Index(i), item, s.Items)...)
+		}
+	case s.Type == "string" && !isString(value):
+		return append(errs, field.Invalid(path, value, "expected a string"))
+	case s.Type == "integer" && !isInteger(value):
+		return append(errs, field.Invalid(path, value, "expected an integer"))
+	case s.Type == "number" && !isNumber(value):
+		return append(errs, field.Invalid(path, value, "expected a number"))
+	case s.Type == "boolean":
+		if _, ok := value.(bool); !ok {
+			return append(errs, field.Invalid(path, value, "expected a boolean"))
+		}
+	}
+
+	if len(s.Enum) > 0 && !inEnum(value, s.Enum) {
+		valid := []string{}
+		for _, e := range s.Enum {
+			valid = append(valid, fmt.Sprint(e))
+		}
+		errs = append(errs, field.NotSupported(path, value, valid))
+	}
+	return errs
+}
+
+// validateObject checks the required fields, the declared properties and the
+// additional properties of obj.
+func (v *OpenAPIValidator) validateObject(path *field.Path, obj map[string]interface{}, s *openAPISchema) field.ErrorList {
+	errs := field.ErrorList{}
+	for _, required := range s.Required {
+		if obj[required] == nil {
+			errs = append(errs, field.Required(path.Child(required), ""))
+		}
+	}
+
+	// Fields are sorted to return the errors in a stable order
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// An object without properties is free form unless it declares the
+	// schema of its additional properties.
+	freeForm := len(s.Properties) == 0 || s.PreserveUnknownFields
+	for _, key := range keys {
+		if property, ok := s.Properties[key]; ok {
+			errs = append(errs, v.validate(path.Child(key), obj[key], property)...)
+			continue
+		}
+		switch {
+		case s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil:
+			errs = append(errs, v.validate(path.Child(key), obj[key], s.AdditionalProperties.Schema)...)
+		case s.AdditionalProperties != nil && !s.AdditionalProperties.Allowed:
+			errs = append(errs, field.Forbidden(path.Child(key), "unknown field"))
+		case !freeForm && s.AdditionalProperties == nil:
+			errs = append(errs, field.Forbidden(path.Child(key), "unknown field"))
+		}
+	}
+	return errs
+}
+
+func isString(value interface{}) bool {
+	_, ok := value.(string)
+	return ok
+}
+
+func isInteger(value interface{}) bool {
+	switch n := value.(type) {
+	case int64, int32, int:
+		return true
+	case float64:
+		return n == math.Trunc(n)
+	}
+	return false
+}
+
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case int64, int32, int, float64:
+		return true
+	}
+	return false
+}
+
+func inEnum(value interface{}, enum []interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
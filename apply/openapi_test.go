@@ -0,0 +1,265 @@
+package apply_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/konveyor/crane-lib/apply"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const openAPIV2 = `{
+	"swagger": "2.0",
+	"definitions": {
+		"io.k8s.api.apps.v1.Deployment": {
+			"type": "object",
+			"required": ["spec"],
+			"properties": {
+				"apiVersion": {"type": "string"},
+				"kind": {"type": "string"},
+				"metadata": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"},
+				"spec": {"$ref": "#/definitions/io.k8s.api.apps.v1.DeploymentSpec"}
+			},
+			"x-kubernetes-group-version-kind": [{"group": "apps", "kind": "Deployment", "version": "v1"}]
+		},
+		"io.k8s.api.apps.v1.DeploymentSpec": {
+			"type": "object",
+			"required": ["selector"],
+			"properties": {
+				"replicas": {"type": "integer", "format": "int32"},
+				"paused": {"type": "boolean"},
+				"selector": {"type": "object"},
+				"strategy": {
+					"type": "object",
+					"properties": {
+						"type": {"type": "string", "enum": ["Recreate", "RollingUpdate"]},
+						"maxSurge": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.util.intstr.IntOrString"}
+					}
+				},
+				"resources": {
+					"type": "object",
+					"additionalProperties": {"$ref": "#/definitions/io.k8s.apimachinery.pkg.api.resource.Quantity"}
+				},
+				"args": {"type": "array", "items": {"type": "string"}}
+			}
+		},
+		"io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {
+			"type": "object",
+			"properties": {
+				"name": {"type": "string"},
+				"labels": {"type": "object", "additionalProperties": {"type": "string"}}
+			}
+		},
+		"io.k8s.apimachinery.pkg.util.intstr.IntOrString": {"type": "string", "format": "int-or-string"},
+		"io.k8s.apimachinery.pkg.api.resource.Quantity": {"type": "string"}
+	}
+}`
+
+const openAPIV3 = `{
+	"openapi": "3.0.0",
+	"components": {
+		"schemas": {
+			"io.k8s.api.core.v1.ConfigMap": {
+				"type": "object",
+				"properties": {
+					"apiVersion": {"type": "string"},
+					"kind": {"type": "string"},
+					"metadata": {"allOf": [{"$ref": "#/components/schemas/io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta"}]},
+					"data": {"type": "object", "additionalProperties": {"type": "string"}},
+					"immutable": {"type": "boolean"},
+					"extra": {"type": "object", "x-kubernetes-preserve-unknown-fields": true, "properties": {"known": {"type": "string"}}}
+				},
+				"x-kubernetes-group-version-kind": [{"group": "", "kind": "ConfigMap", "version": "v1"}]
+			},
+			"io.k8s.apimachinery.pkg.apis.meta.v1.ObjectMeta": {
+				"type": "object",
+				"properties": {
+					"name": {"type": "string"},
+					"labels": {"type": "object", "additionalProperties": {"type": "string"}}
+				}
+			}
+		}
+	}
+}`
+
+func TestOpenAPIValidatorValidate(t *testing.T) {
+	cases := []struct {
+		Name           string
+		Object         map[string]interface{}
+		ExpectedErrors []string
+	}{
+		{
+			Name: "ValidDeployment",
+			Object: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata": map[string]interface{}{
+					"name":   "test",
+					"labels": map[string]interface{}{"app": "test"},
+				},
+				"spec": map[string]interface{}{
+					"replicas": int64(2),
+					"selector": map[string]interface{}{"anything": "goes"},
+					"strategy": map[string]interface{}{
+						"type":     "RollingUpdate",
+						"maxSurge": "25%",
+					},
+					"resources": map[string]interface{}{"cpu": int64(1), "memory": "1Gi"},
+					"args":      []interface{}{"a", "b"},
+				},
+			},
+		},
+		{
+			Name: "InvalidDeployment",
+			Object: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata": map[string]interface{}{
+					"name":   "test",
+					"labels": map[string]interface{}{"app": int64(1)},
+				},
+				"spec": map[string]interface{}{
+					"replicas": "two",
+					"paused":   "yes",
+					"strategy": map[string]interface{}{
+						"type":     "Rolling",
+						"maxSurge": true,
+					},
+					"args":    []interface{}{"a", int64(2)},
+					"unknown": "value",
+				},
+			},
+			ExpectedErrors: []string{
+				`metadata.labels.app: Invalid value: 1: expected a string`,
+				`spec.selector: Required value`,
+				`spec.args[1]: Invalid value: 2: expected a string`,
+				`spec.paused: Invalid value: "yes": expected a boolean`,
+				`spec.replicas: Invalid value: "two": expected an integer`,
+				`spec.strategy.maxSurge: Invalid value: true: expected an integer or a string`,
+				`spec.strategy.type: Unsupported value: "Rolling": supported values: "Recreate", "RollingUpdate"`,
+				`spec.unknown: Forbidden: unknown field`,
+			},
+		},
+		{
+			Name: "MissingSpec",
+			Object: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata":   map[string]interface{}{"name": "test"},
+			},
+			ExpectedErrors: []string{`spec: Required value`},
+		},
+		{
+			Name: "ValidConfigMapV3",
+			Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]interface{}{"name": "test"},
+				"data":       map[string]interface{}{"key": "value"},
+				"extra":      map[string]interface{}{"known": "value", "other": int64(1)},
+			},
+		},
+		{
+			Name: "InvalidConfigMapV3",
+			Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]interface{}{"name": int64(1)},
+				"immutable":  "true",
+			},
+			ExpectedErrors: []string{
+				`immutable: Invalid value: "true": expected a boolean`,
+				`metadata.name: Invalid value: 1: expected a string`,
+			},
+		},
+		{
+			Name: "UnknownKind",
+			Object: map[string]interface{}{
+				"apiVersion": "test.io/v1",
+				"kind":       "DumbThing",
+				"spec":       "anything",
+			},
+		},
+	}
+
+	validator, err := apply.NewOpenAPIValidator([]byte(openAPIV2), []byte(openAPIV3))
+	if err != nil {
+		t.Fatalf("unable to create the validator: %v", err)
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			errs := []string{}
+			for _, err := range validator.Validate(unstructured.Unstructured{Object: c.Object}) {
+				errs = append(errs, err.Error())
+			}
+			if len(errs) == 0 && len(c.ExpectedErrors) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(errs, c.ExpectedErrors) {
+				t.Errorf("expected errors:\n%v\ngot:\n%v", c.ExpectedErrors, errs)
+			}
+		})
+	}
+}
+
+func TestLoadOpenAPIValidator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "v2.json")
+	if err := ioutil.WriteFile(valid, []byte(openAPIV2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty.json")
+	if err := ioutil.WriteFile(empty, []byte(`{"swagger": "2.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := apply.LoadOpenAPIValidator(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := apply.LoadOpenAPIValidator(empty); err == nil {
+		t.Errorf("expected an error for a document without schemas")
+	}
+	if _, err := apply.LoadOpenAPIValidator(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestApplierApplyValidation(t *testing.T) {
+	validator, err := apply.NewOpenAPIValidator([]byte(openAPIV2))
+	if err != nil {
+		t.Fatalf("unable to create the validator: %v", err)
+	}
+	object := unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "test"},
+			"spec": map[string]interface{}{
+				"replicas": int64(1),
+				"selector": map[string]interface{}{},
+			},
+		},
+	}
+	applier := apply.Applier{Validator: validator}
+
+	if _, err := applier.Apply(*object.DeepCopy(), []byte(`[{"op": "replace", "path": "/spec/replicas", "value": 3}]`)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	_, err = applier.Apply(*object.DeepCopy(), []byte(`[{"op": "replace", "path": "/spec/replicas", "value": "3"}]`))
+	if !apply.IsSchemaValidation(err) {
+		t.Fatalf("expected a schema validation error, got: %v", err)
+	}
+	fields := err.(*apply.ApplyError).Fields
+	if len(fields) != 1 || fields[0].Field != "spec.replicas" {
+		t.Errorf("expected spec.replicas to be invalid, got: %v", fields)
+	}
+}
@@ -0,0 +1,165 @@
+package pipeline
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// TransformPrefix is the prefix of the JSON patch files.
+	TransformPrefix = "transform-"
+	// MergePatchesPrefix is the prefix of the files listing the merge patches
+	// to apply after the JSON patch.
+	MergePatchesPrefix = "merge-patches-"
+	// WhiteOutPrefix is the prefix of the files marking an object as whited
+	// out, they are empty.
+	WhiteOutPrefix = ".wh."
+	// IgnoredPatchesPrefix is the prefix of the files listing the operations
+	// ignored by the Runner.
+	IgnoredPatchesPrefix = "ignored-patches-"
+	// NewResourcePrefix is the prefix of the objects created by plugins.
+	NewResourcePrefix = "new-"
+	// ClusterScopedDir is the namespace directory of cluster scoped objects.
+	ClusterScopedDir = "_cluster"
+	// StateFile is the name of the file keeping track of the files already
+	// processed, in the transform and output directories.
+	StateFile = ".pipeline-state.json"
+)
+
+// ResourcePath returns the path of the object relative to the root of a tree,
+// namespace/Kind.version.group/name.yaml. The group is omitted for the core
+// group.
+func ResourcePath(u unstructured.Unstructured) string {
+	namespace := u.GetNamespace()
+	if namespace == "" {
+		namespace = ClusterScopedDir
+	}
+	gvk := u.GroupVersionKind()
+	gvkDir := gvk.Kind + "." + gvk.Version
+	if gvk.Group != "" {
+		gvkDir += "." + gvk.Group
+	}
+	return filepath.Join(namespace, gvkDir, u.GetName()+".yaml")
+}
+
+// isResourceFile returns true for the files of an export tree holding an
+// object, hidden files are ignored.
+func isResourceFile(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// baseName is the name of the file without its extension.
+func baseName(path string) string {
+	name := filepath.Base(path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// transformFiles are the paths, relative to the transform directory, of the
+// files written for the export file at rel.
+type transformFiles struct {
+	transform string
+	merge     string
+	whiteOut  string
+	ignored   string
+}
+
+func transformFilesFor(rel string) transformFiles {
+	dir, base := filepath.Dir(rel), baseName(rel)
+	return transformFiles{
+		transform: filepath.Join(dir, TransformPrefix+base+".json"),
+		merge:     filepath.Join(dir, MergePatchesPrefix+base+".json"),
+		whiteOut:  filepath.Join(dir, WhiteOutPrefix+base),
+		ignored:   filepath.Join(dir, IgnoredPatchesPrefix+base+".json"),
+	}
+}
+
+// newResourcePath is the path, relative to the transform directory, of an
+// object created by a plugin.
+func newResourcePath(u unstructured.Unstructured) string {
+	path := ResourcePath(u)
+	return filepath.Join(filepath.Dir(path), NewResourcePrefix+filepath.Base(path))
+}
+
+// outputPath is the path, relative to the output directory, of the object
+// from the export file at rel.
+func outputPath(rel string) string {
+	return filepath.Join(filepath.Dir(rel), baseName(rel)+".yaml")
+}
+
+// readObject reads an object in YAML or JSON.
+func readObject(data []byte) (unstructured.Unstructured, error) {
+	u := unstructured.Unstructured{}
+	doc, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return u, err
+	}
+	if err := u.UnmarshalJSON(doc); err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+// writeObject writes the object in YAML.
+func writeObject(path string, u unstructured.Unstructured) error {
+	doc, err := u.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	data, err := yaml.JSONToYAML(doc)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data)
+}
+
+// writeFile creates the parent directories and replaces the file atomically,
+// an interrupted run never leaves a partial file behind.
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// removeFiles removes the files relative to dir, missing files are ignored.
+func removeFiles(dir string, files ...string) error {
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(dir, file)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("unable to remove %v: %v", file, err)
+		}
+	}
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,512 @@
+// Package pipeline transforms and applies a whole export directory.
+//
+// The export directory holds one object per file, in YAML or JSON, laid out
+// as namespace/Kind.version.group/name.yaml (see ResourcePath). The transform
+// stage runs the plugins against every object and writes, in the transform
+// directory and under the same relative path:
+//
+//   - transform-<name>.json, the JSON patch for the object
+//   - merge-patches-<name>.json, the merge patches returned by plugins, to
+//     apply in order after the JSON patch
+//   - .wh.<name>, an empty file when the object is whited out
+//   - ignored-patches-<name>.json, the operations ignored by the Runner
+//   - new-<name>.yaml, for each object created by a plugin, at the path of
+//     the new object
+//
+// The apply stage applies the patches and writes the resulting objects, along
+// with the new ones, to the output directory. Whited out objects are left out.
+//
+// Each stage keeps a state file in the directory it writes, so that a run that
+// was interrupted, or a run after some inputs changed, only processes the files
+// whose inputs are different from the last successful run.
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/konveyor/crane-lib/apply"
+	"github.com/konveyor/crane-lib/transform"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	errorsutil "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// stateSaveInterval is the number of files processed between two saves of
+// the state, it bounds the work repeated after a crash.
+const stateSaveInterval = 100
+
+// Pipeline runs the transform and apply stages over directory trees.
+type Pipeline struct {
+	ExportDir    string
+	TransformDir string
+	OutputDir    string
+
+	Runner  *transform.Runner
+	Plugins []transform.Plugin
+	Applier apply.Applier
+
+	// Force processes every file, even when its inputs did not change.
+	Force bool
+	Log   *logrus.Logger
+}
+
+// Result lists the files processed by a stage. Paths are relative to the
+// export directory, or to the transform directory for new resources.
+type Result struct {
+	// Processed files were transformed or applied.
+	Processed []string
+	// Unchanged files were skipped, their inputs did not change since the
+	// last run.
+	Unchanged []string
+	// WhitedOut files are whited out, in the transform stage this is a
+	// subset of Processed.
+	WhitedOut []string
+	// Removed files are no longer in the input, the files written for them
+	// were removed.
+	Removed []string
+	// Failed files are left without any output, they are processed again on
+	// the next run.
+	Failed map[string]error
+}
+
+func newResult() Result {
+	return Result{Failed: map[string]error{}}
+}
+
+// err aggregates the failures of the stage.
+func (r Result) err() error {
+	errs := []error{}
+	for rel, err := range r.Failed {
+		errs = append(errs, fmt.Errorf("%v: %v", rel, err))
+	}
+	return errorsutil.NewAggregate(errs)
+}
+
+// Run runs the transform stage followed by the apply stage, the apply stage
+// is not run when the transform stage fails.
+func (p *Pipeline) Run(ctx context.Context) (Result, Result, error) {
+	transformResult, err := p.Transform(ctx)
+	if err != nil {
+		return transformResult, Result{}, err
+	}
+	applyResult, err := p.Apply(ctx)
+	return transformResult, applyResult, err
+}
+
+// Transform runs the plugins against every object of the export directory
+// and writes the results to the transform directory. Objects are handed to
+// Runner.RunAll in batches, which processes up to Runner.Workers of them in
+// parallel. An error is returned when any file failed, the other files are
+// still processed.
+func (p *Pipeline) Transform(ctx context.Context) (Result, error) {
+	result := newResult()
+	st, err := loadState(p.TransformDir)
+	if err != nil {
+		return result, err
+	}
+	config, err := p.config()
+	if err != nil {
+		return result, err
+	}
+	files, err := resourceFiles(p.ExportDir, "")
+	if err != nil {
+		return result, err
+	}
+
+	seen := map[string]bool{}
+	batch := []pendingFile{}
+	for _, rel := range files {
+		if err := ctx.Err(); err != nil {
+			return result, saveAfter(st, err)
+		}
+		seen[rel] = true
+		data, err := ioutil.ReadFile(filepath.Join(p.ExportDir, rel))
+		if err != nil {
+			result.Failed[rel] = err
+			continue
+		}
+		h := hash(config, data)
+		if !p.Force && st.upToDate(st.Files, rel, h) {
+			result.Unchanged = append(result.Unchanged, rel)
+			continue
+		}
+		u, err := readObject(data)
+		if err != nil {
+			st.forget(st.Files, rel)
+			result.Failed[rel] = fmt.Errorf("invalid object: %v", err)
+			continue
+		}
+
+		batch = append(batch, pendingFile{rel: rel, hash: h, object: u})
+		if len(batch) == stateSaveInterval {
+			if err := p.transformBatch(ctx, st, batch, &result); err != nil {
+				return result, saveAfter(st, err)
+			}
+			batch = batch[:0]
+			if err := st.save(); err != nil {
+				return result, err
+			}
+		}
+	}
+	if err := p.transformBatch(ctx, st, batch, &result); err != nil {
+		return result, saveAfter(st, err)
+	}
+
+	result.Removed, err = st.forgetMissing(st.Files, seen)
+	if err != nil {
+		return result, saveAfter(st, err)
+	}
+	if err := st.save(); err != nil {
+		return result, err
+	}
+	return result, result.err()
+}
+
+// pendingFile is an export file waiting to be transformed.
+type pendingFile struct {
+	rel    string
+	hash   string
+	object unstructured.Unstructured
+}
+
+// transformBatch runs the plugins against the objects of the batch and writes
+// their files, in the order of the batch. The context error is returned when
+// it is cancelled before every object was processed.
+func (p *Pipeline) transformBatch(ctx context.Context, st *state, batch []pendingFile, result *Result) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	objects := make([]unstructured.Unstructured, len(batch))
+	for i, f := range batch {
+		objects[i] = f.object
+	}
+	results := p.Runner.RunAll(ctx, objects, p.Plugins)
+	for i, f := range batch {
+		if err := ctx.Err(); err != nil && results[i].Err != nil {
+			return err
+		}
+		if err := st.forget(st.Files, f.rel); err != nil {
+			result.Failed[f.rel] = err
+			continue
+		}
+		if results[i].Err != nil {
+			result.Failed[f.rel] = results[i].Err
+			continue
+		}
+		outputs, err := p.transformFile(f.rel, results[i].Response)
+		if err != nil {
+			removeFiles(p.TransformDir, outputs...)
+			result.Failed[f.rel] = err
+			continue
+		}
+		st.Files[f.rel] = fileState{Hash: f.hash, Outputs: outputs}
+		result.Processed = append(result.Processed, f.rel)
+		if results[i].Response.HaveWhiteOut {
+			result.WhitedOut = append(result.WhitedOut, f.rel)
+		}
+	}
+	return nil
+}
+
+// transformFile writes the files for the response of the Runner, it returns
+// the files written relative to the transform directory. The objects created
+// by plugins are written even when the object is whited out.
+func (p *Pipeline) transformFile(rel string, resp transform.RunnerResponse) ([]string, error) {
+	files := transformFilesFor(rel)
+	outputs := []string{}
+	for _, resource := range resp.NewResources {
+		path := newResourcePath(resource)
+		outputs = append(outputs, path)
+		if err := writeObject(filepath.Join(p.TransformDir, path), resource); err != nil {
+			return outputs, err
+		}
+	}
+	if resp.HaveWhiteOut {
+		outputs = append(outputs, files.whiteOut)
+		return outputs, writeFile(filepath.Join(p.TransformDir, files.whiteOut), nil)
+	}
+
+	outputs = append(outputs, files.transform)
+	if err := writeFile(filepath.Join(p.TransformDir, files.transform), resp.TransformFile); err != nil {
+		return outputs, err
+	}
+	if len(resp.MergePatches) > 0 {
+		mergePatches, err := json.Marshal(resp.MergePatches)
+		if err != nil {
+			return outputs, err
+		}
+		outputs = append(outputs, files.merge)
+		if err := writeFile(filepath.Join(p.TransformDir, files.merge), mergePatches); err != nil {
+			return outputs, err
+		}
+	}
+	if !isEmptyList(resp.IgnoredPatches) {
+		outputs = append(outputs, files.ignored)
+		if err := writeFile(filepath.Join(p.TransformDir, files.ignored), resp.IgnoredPatches); err != nil {
+			return outputs, err
+		}
+	}
+	return outputs, nil
+}
+
+// Apply applies the patches of the transform directory to the objects of the
+// export directory and writes the results, along with the objects created by
+// plugins, to the output directory. An error is returned when any file
+// failed, the other files are still processed.
+func (p *Pipeline) Apply(ctx context.Context) (Result, error) {
+	result := newResult()
+	st, err := loadState(p.OutputDir)
+	if err != nil {
+		return result, err
+	}
+	files, err := resourceFiles(p.ExportDir, "")
+	if err != nil {
+		return result, err
+	}
+
+	seen := map[string]bool{}
+	owners := map[string]string{}
+	for i, rel := range files {
+		if err := ctx.Err(); err != nil {
+			return result, saveAfter(st, err)
+		}
+		seen[rel] = true
+		owners[outputPath(rel)] = rel
+
+		transformFiles := transformFilesFor(rel)
+		whiteOut := exists(filepath.Join(p.TransformDir, transformFiles.whiteOut))
+		data, err := ioutil.ReadFile(filepath.Join(p.ExportDir, rel))
+		if err != nil {
+			result.Failed[rel] = err
+			continue
+		}
+		var patch, mergePatches []byte
+		if !whiteOut {
+			patch, err = ioutil.ReadFile(filepath.Join(p.TransformDir, transformFiles.transform))
+			if os.IsNotExist(err) {
+				err = fmt.Errorf("no transform file, the transform stage failed or was not run")
+			}
+			if err == nil {
+				mergePatches, err = ioutil.ReadFile(filepath.Join(p.TransformDir, transformFiles.merge))
+				if os.IsNotExist(err) {
+					err = nil
+				}
+			}
+			if err != nil {
+				st.forget(st.Files, rel)
+				result.Failed[rel] = err
+				continue
+			}
+		}
+		h := hash(data, []byte(fmt.Sprint(whiteOut)), patch, mergePatches)
+		if !p.Force && st.upToDate(st.Files, rel, h) {
+			result.Unchanged = append(result.Unchanged, rel)
+			continue
+		}
+
+		if err := st.forget(st.Files, rel); err != nil {
+			result.Failed[rel] = err
+			continue
+		}
+		if whiteOut {
+			st.Files[rel] = fileState{Hash: h}
+			result.WhitedOut = append(result.WhitedOut, rel)
+			continue
+		}
+		if err := p.applyFile(rel, data, patch, mergePatches); err != nil {
+			result.Failed[rel] = err
+			continue
+		}
+		st.Files[rel] = fileState{Hash: h, Outputs: []string{outputPath(rel)}}
+		result.Processed = append(result.Processed, rel)
+
+		if (i+1)%stateSaveInterval == 0 {
+			if err := st.save(); err != nil {
+				return result, err
+			}
+		}
+	}
+	result.Removed, err = st.forgetMissing(st.Files, seen)
+	if err != nil {
+		return result, saveAfter(st, err)
+	}
+
+	if err := p.applyNewResources(ctx, st, owners, &result); err != nil {
+		return result, saveAfter(st, err)
+	}
+	if err := st.save(); err != nil {
+		return result, err
+	}
+	return result, result.err()
+}
+
+func (p *Pipeline) applyFile(rel string, data, patch, mergePatches []byte) error {
+	u, err := readObject(data)
+	if err != nil {
+		return fmt.Errorf("invalid object: %v", err)
+	}
+	if !isEmptyList(patch) {
+		doc, report, err := p.Applier.ApplyWithReport(u, patch)
+		if err != nil {
+			return err
+		}
+		for _, skipped := range report.Skipped {
+			p.log().Warnf("%v: skipped %v", rel, skipped)
+		}
+		if err := u.UnmarshalJSON(doc); err != nil {
+			return err
+		}
+	}
+	if !isEmptyList(mergePatches) {
+		patches := []transform.MergePatch{}
+		if err := json.Unmarshal(mergePatches, &patches); err != nil {
+			return fmt.Errorf("invalid merge patches: %v", err)
+		}
+		for _, mergePatch := range patches {
+			doc, err := p.Applier.ApplyPatch(u, mergePatch.Patch, mergePatch.Type)
+			if err != nil {
+				return fmt.Errorf("merge patch from plugin %v: %v", mergePatch.PluginName, err)
+			}
+			if err := u.UnmarshalJSON(doc); err != nil {
+				return err
+			}
+		}
+	}
+	return writeObject(filepath.Join(p.OutputDir, outputPath(rel)), u)
+}
+
+// applyNewResources copies the objects created by plugins to the output
+// directory. They never replace an object of the export directory.
+func (p *Pipeline) applyNewResources(ctx context.Context, st *state, owners map[string]string, result *Result) error {
+	files, err := resourceFiles(p.TransformDir, NewResourcePrefix)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, rel := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		seen[rel] = true
+		output := filepath.Join(filepath.Dir(rel), strings.TrimPrefix(filepath.Base(rel), NewResourcePrefix))
+		if owner, ok := owners[output]; ok {
+			st.forget(st.NewResources, rel)
+			result.Failed[rel] = fmt.Errorf("the new resource would replace %v", owner)
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(p.TransformDir, rel))
+		if err != nil {
+			result.Failed[rel] = err
+			continue
+		}
+		h := hash(data)
+		if !p.Force && st.upToDate(st.NewResources, rel, h) {
+			result.Unchanged = append(result.Unchanged, rel)
+			continue
+		}
+		if err := st.forget(st.NewResources, rel); err != nil {
+			result.Failed[rel] = err
+			continue
+		}
+		if err := writeFile(filepath.Join(p.OutputDir, output), data); err != nil {
+			result.Failed[rel] = err
+			continue
+		}
+		st.NewResources[rel] = fileState{Hash: h, Outputs: []string{output}}
+		result.Processed = append(result.Processed, rel)
+	}
+	removed, err := st.forgetMissing(st.NewResources, seen)
+	result.Removed = append(result.Removed, removed...)
+	return err
+}
+
+// config is the configuration of the transform stage, any change to it
+// invalidates every transformed file. It includes the digest of the
+// Runner.Resources, plugins can read any of them for any object.
+func (p *Pipeline) config() ([]byte, error) {
+	plugins := []transform.PluginMetadata{}
+	for _, plugin := range p.Plugins {
+		plugins = append(plugins, plugin.Metadata())
+	}
+	resources := []byte{}
+	for _, object := range p.Runner.Resources.Objects() {
+		doc, err := object.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, hash(doc)...)
+	}
+	return json.Marshal(struct {
+		Plugins          []transform.PluginMetadata
+		PluginPriorities map[string]int
+		OptionalFlags    map[string]string
+		PluginFlags      map[string]map[string]string
+		ErrorPolicy      transform.ErrorPolicy
+		OptionalPlugins  map[string]bool
+		WhiteOutPolicy   transform.WhiteOutPolicy
+		Resources        string
+	}{
+		Plugins:          plugins,
+		PluginPriorities: p.Runner.PluginPriorities,
+		OptionalFlags:    p.Runner.OptionalFlags,
+		PluginFlags:      p.Runner.PluginFlags,
+		ErrorPolicy:      p.Runner.ErrorPolicy,
+		OptionalPlugins:  p.Runner.OptionalPlugins,
+		WhiteOutPolicy:   p.Runner.WhiteOutPolicy,
+		Resources:        hash(resources),
+	})
+}
+
+func (p *Pipeline) log() logrus.FieldLogger {
+	if p.Log == nil {
+		return logrus.StandardLogger()
+	}
+	return p.Log
+}
+
+// resourceFiles returns the sorted paths, relative to dir, of the resource
+// files starting with prefix.
+func resourceFiles(dir, prefix string) ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == dir {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !isResourceFile(info.Name()) || !strings.HasPrefix(info.Name(), prefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the files of %v: %v", dir, err)
+	}
+	return files, nil
+}
+
+// saveAfter saves the state after the stage was interrupted by err.
+func saveAfter(st *state, err error) error {
+	if saveErr := st.save(); saveErr != nil {
+		return errorsutil.NewAggregate([]error{err, saveErr})
+	}
+	return err
+}
+
+func isEmptyList(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	return len(data) == 0 || bytes.Equal(data, []byte("null")) || bytes.Equal(data, []byte("[]"))
+}
@@ -0,0 +1,386 @@
+package pipeline_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/konveyor/crane-lib/pipeline"
+	"github.com/konveyor/crane-lib/transform"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// fakePlugin whites out Pods, labels every other object, adds a container to
+// Deployments and creates a ConfigMap for every Service.
+type fakePlugin struct {
+	version string
+	calls   int
+}
+
+func (f *fakePlugin) Metadata() transform.PluginMetadata {
+	return transform.PluginMetadata{
+		Name:            "FakePlugin",
+		Version:         f.version,
+		RequestVersion:  []transform.Version{transform.V2},
+		ResponseVersion: []transform.Version{transform.V2},
+	}
+}
+
+func (f *fakePlugin) Run(request transform.PluginRequest) (transform.PluginResponse, error) {
+	f.calls++
+	resp := transform.PluginResponse{Version: string(transform.V2)}
+	switch request.GetKind() {
+	case "Pod":
+		resp.IsWhiteOut = true
+		return resp, nil
+	case "Service":
+		cm := unstructured.Unstructured{}
+		cm.SetAPIVersion("v1")
+		cm.SetKind("ConfigMap")
+		cm.SetNamespace(request.GetNamespace())
+		cm.SetName(request.GetName() + "-config")
+		resp.NewResources = append(resp.NewResources, cm)
+	case "Deployment":
+		resp.StrategicMergePatch = []byte(`{"spec": {"template": {"spec": {"containers": [{"name": "proxy", "image": "proxy:1"}]}}}}`)
+	}
+	patch, err := jsonpatch.DecodePatch([]byte(`[{"op": "add", "path": "/metadata/labels", "value": {"transformed": "true"}}]`))
+	if err != nil {
+		return resp, err
+	}
+	resp.Patches = patch
+	return resp, nil
+}
+
+func object(apiVersion, kind, namespace, name string) unstructured.Unstructured {
+	u := unstructured.Unstructured{}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	return u
+}
+
+func writeExport(t *testing.T, dir string, u unstructured.Unstructured) string {
+	doc, err := u.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := yaml.JSONToYAML(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, pipeline.ResourcePath(u))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return pipeline.ResourcePath(u)
+}
+
+// listFiles returns the files of dir, the state file excluded.
+func listFiles(t *testing.T, dir string) []string {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && info.Name() != pipeline.StateFile {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestResourcePath(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Object   unstructured.Unstructured
+		Expected string
+	}{
+		{
+			Name:     "Namespaced",
+			Object:   object("apps/v1", "Deployment", "test-ns", "test"),
+			Expected: "test-ns/Deployment.v1.apps/test.yaml",
+		},
+		{
+			Name:     "CoreGroup",
+			Object:   object("v1", "Service", "test-ns", "test"),
+			Expected: "test-ns/Service.v1/test.yaml",
+		},
+		{
+			Name:     "ClusterScoped",
+			Object:   object("rbac.authorization.k8s.io/v1", "ClusterRole", "", "test"),
+			Expected: "_cluster/ClusterRole.v1.rbac.authorization.k8s.io/test.yaml",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if path := pipeline.ResourcePath(c.Object); path != c.Expected {
+				t.Errorf("expected %v, got: %v", c.Expected, path)
+			}
+		})
+	}
+}
+
+func TestPipelineRun(t *testing.T) {
+	root, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	exportDir := filepath.Join(root, "export")
+	deployment := writeExport(t, exportDir, object("apps/v1", "Deployment", "test-ns", "test"))
+	pod := writeExport(t, exportDir, object("v1", "Pod", "test-ns", "test"))
+	service := writeExport(t, exportDir, object("v1", "Service", "test-ns", "test"))
+
+	plugin := &fakePlugin{version: "v1"}
+	p := pipeline.Pipeline{
+		ExportDir:    exportDir,
+		TransformDir: filepath.Join(root, "transform"),
+		OutputDir:    filepath.Join(root, "output"),
+		Runner:       &transform.Runner{Log: logrus.New()},
+		Plugins:      []transform.Plugin{plugin},
+	}
+
+	transformResult, applyResult, err := p.Run(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(transformResult.Processed, []string{deployment, pod, service}) {
+		t.Errorf("unexpected transformed files: %v", transformResult.Processed)
+	}
+	if !reflect.DeepEqual(transformResult.WhitedOut, []string{pod}) {
+		t.Errorf("unexpected whited out files: %v", transformResult.WhitedOut)
+	}
+	expectedTransform := []string{
+		"test-ns/ConfigMap.v1/new-test-config.yaml",
+		"test-ns/Deployment.v1.apps/merge-patches-test.json",
+		"test-ns/Deployment.v1.apps/transform-test.json",
+		"test-ns/Pod.v1/.wh.test",
+		"test-ns/Service.v1/transform-test.json",
+	}
+	if files := listFiles(t, p.TransformDir); !reflect.DeepEqual(files, expectedTransform) {
+		t.Errorf("expected transform files %v, got: %v", expectedTransform, files)
+	}
+	expectedOutput := []string{
+		"test-ns/ConfigMap.v1/test-config.yaml",
+		"test-ns/Deployment.v1.apps/test.yaml",
+		"test-ns/Service.v1/test.yaml",
+	}
+	if files := listFiles(t, p.OutputDir); !reflect.DeepEqual(files, expectedOutput) {
+		t.Errorf("expected output files %v, got: %v", expectedOutput, files)
+	}
+	if !reflect.DeepEqual(applyResult.WhitedOut, []string{pod}) {
+		t.Errorf("unexpected whited out files: %v", applyResult.WhitedOut)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(p.OutputDir, deployment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := unstructured.Unstructured{}
+	if err := yaml.Unmarshal(data, &u.Object); err != nil {
+		t.Fatal(err)
+	}
+	if u.GetLabels()["transformed"] != "true" {
+		t.Errorf("expected the patch to be applied, got: %s", data)
+	}
+	containers, _, _ := unstructured.NestedSlice(u.Object, "spec", "template", "spec", "containers")
+	if len(containers) != 1 {
+		t.Errorf("expected the merge patch to be applied, got: %s", data)
+	}
+
+	// Nothing changed, nothing is processed again
+	plugin.calls = 0
+	transformResult, applyResult, err = p.Run(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if plugin.calls != 0 || len(transformResult.Processed) != 0 || len(applyResult.Processed) != 0 {
+		t.Errorf("expected every file to be unchanged, got %v calls, %v transformed and %v applied", plugin.calls, transformResult.Processed, applyResult.Processed)
+	}
+	if len(transformResult.Unchanged) != 3 || len(applyResult.Unchanged) != 4 {
+		t.Errorf("unexpected unchanged files: %v, %v", transformResult.Unchanged, applyResult.Unchanged)
+	}
+
+	// A changed input is processed again, a removed input has its files removed
+	writeExport(t, exportDir, func() unstructured.Unstructured {
+		u := object("apps/v1", "Deployment", "test-ns", "test")
+		u.SetAnnotations(map[string]string{"changed": "true"})
+		return u
+	}())
+	if err := os.Remove(filepath.Join(exportDir, service)); err != nil {
+		t.Fatal(err)
+	}
+	transformResult, applyResult, err = p.Run(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(transformResult.Processed, []string{deployment}) || !reflect.DeepEqual(transformResult.Removed, []string{service}) {
+		t.Errorf("unexpected transform result: %+v", transformResult)
+	}
+	if !reflect.DeepEqual(applyResult.Processed, []string{deployment}) {
+		t.Errorf("unexpected apply result: %+v", applyResult)
+	}
+	expectedOutput = []string{"test-ns/Deployment.v1.apps/test.yaml"}
+	if files := listFiles(t, p.OutputDir); !reflect.DeepEqual(files, expectedOutput) {
+		t.Errorf("expected output files %v, got: %v", expectedOutput, files)
+	}
+
+	// A new plugin version invalidates every transformed file
+	plugin.version = "v2"
+	transformResult, err = p.Transform(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transformResult.Processed) != 2 {
+		t.Errorf("expected every file to be transformed, got: %v", transformResult.Processed)
+	}
+
+	// Plugins can read the related objects, a change to them invalidates
+	// every transformed file
+	related := object("v1", "ConfigMap", "test-ns", "related")
+	p.Runner.Resources = transform.NewResourceIndex([]unstructured.Unstructured{related})
+	if transformResult, err = p.Transform(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transformResult.Processed) != 2 {
+		t.Errorf("expected every file to be transformed with new resources, got: %v", transformResult.Processed)
+	}
+	p.Runner.Resources = transform.NewResourceIndex([]unstructured.Unstructured{related})
+	if transformResult, err = p.Transform(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transformResult.Processed) != 0 {
+		t.Errorf("expected every file to be unchanged with the same resources, got: %v", transformResult.Processed)
+	}
+	related.SetLabels(map[string]string{"changed": "true"})
+	p.Runner.Resources = transform.NewResourceIndex([]unstructured.Unstructured{related})
+	if transformResult, err = p.Transform(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(transformResult.Processed) != 2 {
+		t.Errorf("expected every file to be transformed with a changed resource, got: %v", transformResult.Processed)
+	}
+}
+
+func TestPipelineFailures(t *testing.T) {
+	root, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	exportDir := filepath.Join(root, "export")
+	deployment := writeExport(t, exportDir, object("apps/v1", "Deployment", "test-ns", "test"))
+	invalid := filepath.Join("test-ns", "Invalid.v1", "invalid.yaml")
+	if err := os.MkdirAll(filepath.Join(exportDir, filepath.Dir(invalid)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(exportDir, invalid), []byte("metadata: {name: invalid}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := pipeline.Pipeline{
+		ExportDir:    exportDir,
+		TransformDir: filepath.Join(root, "transform"),
+		OutputDir:    filepath.Join(root, "output"),
+		Runner:       &transform.Runner{Log: logrus.New()},
+		Plugins:      []transform.Plugin{&fakePlugin{version: "v1"}},
+	}
+	result, err := p.Transform(context.TODO())
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if _, ok := result.Failed[invalid]; !ok || len(result.Failed) != 1 {
+		t.Errorf("expected %v to fail, got: %v", invalid, result.Failed)
+	}
+	if !reflect.DeepEqual(result.Processed, []string{deployment}) {
+		t.Errorf("expected the valid file to be transformed, got: %v", result.Processed)
+	}
+
+	// The failed file is retried on the next run
+	result, _ = p.Transform(context.TODO())
+	if _, ok := result.Failed[invalid]; !ok {
+		t.Errorf("expected %v to fail again, got: %+v", invalid, result)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := p.Apply(ctx); err != context.Canceled {
+		t.Errorf("expected the context error, got: %v", err)
+	}
+}
+
+// routePlugin whites out Routes and creates an Ingress in their place.
+type routePlugin struct{}
+
+func (routePlugin) Metadata() transform.PluginMetadata {
+	return transform.PluginMetadata{
+		Name:            "RoutePlugin",
+		Version:         "v1",
+		RequestVersion:  []transform.Version{transform.V2},
+		ResponseVersion: []transform.Version{transform.V2},
+	}
+}
+
+func (routePlugin) Run(request transform.PluginRequest) (transform.PluginResponse, error) {
+	resp := transform.PluginResponse{Version: string(transform.V2)}
+	if request.GetKind() == "Route" {
+		resp.IsWhiteOut = true
+		resp.NewResources = append(resp.NewResources, object("networking.k8s.io/v1", "Ingress", request.GetNamespace(), request.GetName()))
+	}
+	return resp, nil
+}
+
+func TestPipelineWhiteOutNewResources(t *testing.T) {
+	root, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	exportDir := filepath.Join(root, "export")
+	routes := []string{}
+	for _, name := range []string{"a", "b", "c", "d"} {
+		routes = append(routes, writeExport(t, exportDir, object("route.openshift.io/v1", "Route", "test-ns", name)))
+	}
+
+	p := pipeline.Pipeline{
+		ExportDir:    exportDir,
+		TransformDir: filepath.Join(root, "transform"),
+		OutputDir:    filepath.Join(root, "output"),
+		Runner:       &transform.Runner{Log: logrus.New(), Workers: 3},
+		Plugins:      []transform.Plugin{routePlugin{}},
+	}
+	transformResult, _, err := p.Run(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(transformResult.WhitedOut, routes) {
+		t.Errorf("unexpected whited out files: %v", transformResult.WhitedOut)
+	}
+	expectedOutput := []string{
+		"test-ns/Ingress.v1.networking.k8s.io/a.yaml",
+		"test-ns/Ingress.v1.networking.k8s.io/b.yaml",
+		"test-ns/Ingress.v1.networking.k8s.io/c.yaml",
+		"test-ns/Ingress.v1.networking.k8s.io/d.yaml",
+	}
+	if files := listFiles(t, p.OutputDir); !reflect.DeepEqual(files, expectedOutput) {
+		t.Errorf("expected output files %v, got: %v", expectedOutput, files)
+	}
+}
@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// state records, for every file processed by a stage, the hash of its inputs
+// and the files written for it. It is saved in the directory written by the
+// stage so that a later run only processes files whose inputs changed.
+type state struct {
+	dir string
+	// Files are keyed by their path relative to the export directory.
+	Files fileStates `json:"files"`
+	// NewResources are keyed by their path relative to the transform
+	// directory.
+	NewResources fileStates `json:"newResources,omitempty"`
+}
+
+type fileStates map[string]fileState
+
+type fileState struct {
+	Hash    string   `json:"hash"`
+	Outputs []string `json:"outputs,omitempty"`
+}
+
+func loadState(dir string) (*state, error) {
+	s := &state{dir: dir}
+	data, err := ioutil.ReadFile(filepath.Join(dir, StateFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("unable to read the pipeline state: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("invalid pipeline state %v: %v", filepath.Join(dir, StateFile), err)
+		}
+	}
+	if s.Files == nil {
+		s.Files = fileStates{}
+	}
+	if s.NewResources == nil {
+		s.NewResources = fileStates{}
+	}
+	return s, nil
+}
+
+func (s *state) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(s.dir, StateFile), data); err != nil {
+		return fmt.Errorf("unable to save the pipeline state: %v", err)
+	}
+	return nil
+}
+
+// upToDate returns true when the file was processed with the same inputs and
+// every file written for it is still there.
+func (s *state) upToDate(files fileStates, rel, hash string) bool {
+	f, ok := files[rel]
+	if !ok || f.Hash != hash {
+		return false
+	}
+	for _, output := range f.Outputs {
+		if !exists(filepath.Join(s.dir, output)) {
+			return false
+		}
+	}
+	return true
+}
+
+// forget removes the files written for rel and its entry.
+func (s *state) forget(files fileStates, rel string) error {
+	if err := removeFiles(s.dir, files[rel].Outputs...); err != nil {
+		return err
+	}
+	delete(files, rel)
+	return nil
+}
+
+// forgetMissing forgets every file not in seen, their input is gone.
+func (s *state) forgetMissing(files fileStates, seen map[string]bool) ([]string, error) {
+	removed := []string{}
+	for rel := range files {
+		if seen[rel] {
+			continue
+		}
+		if err := s.forget(files, rel); err != nil {
+			return removed, err
+		}
+		removed = append(removed, rel)
+	}
+	sort.Strings(removed)
+	return removed, nil
+}
+
+// hash returns the hex encoded sha256 of the values, each one is prefixed by
+// its length so that different splits do not collide.
+func hash(values ...[]byte) string {
+	h := sha256.New()
+	for _, v := range values {
+		fmt.Fprintf(h, "%d:", len(v))
+		h.Write(v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -2,113 +2,322 @@ package binary_plugin
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/konveyor/crane-lib/transform"
+	"github.com/konveyor/crane-lib/transform/errors"
 	"github.com/sirupsen/logrus"
+	errorsutil "k8s.io/apimachinery/pkg/util/errors"
 )
 
 const (
 	MetadataRequest string = `{}`
 )
 
+// PluginOptions are the caller provided options of a BinaryPlugin
+type PluginOptions struct {
+	// Persistent starts the plugin once and sends it every request over a
+	// single stdin/stdout stream, for plugins whose metadata allows it.
+	Persistent bool
+	// Timeout bounds every call to the plugin, including the metadata call.
+	// Zero means no timeout.
+	Timeout time.Duration
+	// OutputLimit is the maximum number of bytes read from each of stdout and
+	// stderr of a single call. Zero means no limit.
+	OutputLimit int64
+	// StderrLevel is the level of the lines the plugin writes to stderr that
+	// are not log records. Defaults to Info.
+	StderrLevel logrus.Level
+	// Metadata, when set, is used instead of running the metadata command.
+	Metadata *transform.PluginMetadata
+	// Digest, SignatureFile and TrustStore verify the binary once, when the
+	// plugin is created, see verifier. A private copy of the verified binary
+	// is run afterwards, it is removed by Close.
+	Digest        string
+	SignatureFile string
+	TrustStore    *TrustStore
+}
+
+// PluginOption knows how to apply a user provided option to a given PluginOptions
+type PluginOption interface {
+	ApplyTo(*PluginOptions) error
+}
+
+func (p *PluginOptions) Apply(opts ...PluginOption) error {
+	errs := []error{}
+	for _, opt := range opts {
+		if err := opt.ApplyTo(p); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errorsutil.NewAggregate(errs)
+}
+
+// PersistentProcess runs the plugin as a long lived process when the plugin
+// supports it, otherwise the plugin is still run once per object.
+type PersistentProcess bool
+
+func (p PersistentProcess) ApplyTo(opts *PluginOptions) error {
+	opts.Persistent = bool(p)
+	return nil
+}
+
+// Timeout stops the plugin and fails the call with a PluginTimeoutError when
+// the plugin takes longer than the given duration.
+type Timeout time.Duration
+
+func (t Timeout) ApplyTo(opts *PluginOptions) error {
+	if t < 0 {
+		return fmt.Errorf("plugin timeout must not be negative")
+	}
+	opts.Timeout = time.Duration(t)
+	return nil
+}
+
+// OutputLimit stops the plugin when it writes more than the given number of
+// bytes to stdout or stderr.
+type OutputLimit int64
+
+func (o OutputLimit) ApplyTo(opts *PluginOptions) error {
+	if o < 0 {
+		return fmt.Errorf("plugin output limit must not be negative")
+	}
+	opts.OutputLimit = int64(o)
+	return nil
+}
+
+// StderrLevel sets the level of the lines the plugin writes to stderr that
+// are not log records.
+type StderrLevel logrus.Level
+
+func (s StderrLevel) ApplyTo(opts *PluginOptions) error {
+	opts.StderrLevel = logrus.Level(s)
+	return nil
+}
+
+// KnownMetadata is the metadata of the plugin, when it was saved from an
+// earlier run of the metadata command of the same binary.
+type KnownMetadata transform.PluginMetadata
+
+func (k KnownMetadata) ApplyTo(opts *PluginOptions) error {
+	metadata := transform.PluginMetadata(k)
+	opts.Metadata = &metadata
+	return nil
+}
+
 type BinaryPlugin struct {
 	commandRunner
 	pluginMetadata transform.PluginMetadata
+	version        transform.Version
+	options        PluginOptions
 	log            logrus.FieldLogger
 }
 
 // NewBinaryPlugin -
-func NewBinaryPlugin(path string, logger *logrus.Logger) (transform.Plugin, error) {
+func NewBinaryPlugin(path string, logger *logrus.Logger, opts ...PluginOption) (transform.Plugin, error) {
+	options := PluginOptions{StderrLevel: logrus.InfoLevel}
+	if err := options.Apply(opts...); err != nil {
+		return nil, err
+	}
 
-	commandRunner := &binaryRunner{pluginPath: path}
+	verifier, err := newVerifier(path, options)
+	if err != nil {
+		return nil, err
+	}
+	binaryRunner := &binaryRunner{pluginPath: path, outputLimit: options.OutputLimit}
+	// The binary is verified even when its metadata is known, a cached
+	// metadata must not skip the verification.
+	if verifier != nil {
+		binaryRunner.verifiedPath, err = verifier.verifiedCopy()
+		if err != nil {
+			return nil, err
+		}
+	}
 	log := logger.WithField("pluginPath", path)
 
-	out, errBytes, err := commandRunner.Metadata(log)
+	var metadata transform.PluginMetadata
+	if options.Metadata != nil {
+		metadata = *options.Metadata
+	} else {
+		metadata, err = runMetadata(binaryRunner, options, log)
+		if err != nil {
+			binaryRunner.Close()
+			return nil, err
+		}
+	}
+
+	// Validate version return error
+	version, ok := transform.NegotiateVersion(metadata)
+	if !ok || len(metadata.RequestVersion) == 0 || len(metadata.ResponseVersion) == 0 {
+		binaryRunner.Close()
+		return nil, fmt.Errorf("invalid versions supported by plugin defined by caller responseVersions: %v, requestVersions: %v", metadata.ResponseVersion, metadata.RequestVersion)
+	}
+
+	var commandRunner commandRunner = binaryRunner
+	if options.Persistent {
+		if metadata.Persistent {
+			commandRunner = newPersistentRunner(*binaryRunner, options, log)
+		} else {
+			log.Debugf("plugin does not support persistent mode, running it once per object")
+		}
+	}
+
+	log = log.WithField("plugin", metadata.Name)
+	return &BinaryPlugin{commandRunner: commandRunner, pluginMetadata: metadata, version: version, options: options, log: log}, nil
+}
+
+// runMetadata runs the metadata command of the plugin.
+func runMetadata(runner commandRunner, options PluginOptions, log logrus.FieldLogger) (transform.PluginMetadata, error) {
+	metadata := transform.PluginMetadata{}
+	ctx, cancel := options.context(context.Background())
+	defer cancel()
+	out, errBytes, err := runner.Metadata(ctx, log)
 	// TODO: Create specific error for command not being run.
 	if err != nil {
 		log.Errorf("error running the plugin metadata command")
-		return nil, fmt.Errorf("error running the plugin metadata command: %v", err)
+		if perr, ok := err.(*errors.PluginError); ok {
+			return metadata, perr
+		}
+		if errors.IsVerificationError(err) {
+			return metadata, err
+		}
+		return metadata, fmt.Errorf("error running the plugin metadata command: %v", err)
 	}
 
 	if len(errBytes) != 0 {
 		log.Errorf("error from plugin binary")
-		return nil, fmt.Errorf("error from plugin binary: %s", string(errBytes))
+		return metadata, fmt.Errorf("error from plugin binary: %s", string(errBytes))
 	}
 
-	metadata := transform.PluginMetadata{}
 	err = json.Unmarshal(out, &metadata)
 	if err != nil {
 		log.Errorf("unable to decode json sent by the plugin")
-		return nil, fmt.Errorf("unable to decode metadata sent by the plugin: %s, err: %v", string(out), err)
+		return metadata, fmt.Errorf("unable to decode metadata sent by the plugin: %s, err: %v", string(out), err)
 	}
-
-	// Validate version return error
-	if !contain(transform.RequestVersion, metadata.RequestVersion) || !contain(transform.ResponseVersion, metadata.ResponseVersion) {
-		return nil, fmt.Errorf("invalid versions supported by plugin defined by caller responseVersions: %v, requestVersions: %v", metadata.ResponseVersion, metadata.RequestVersion)
-	}
-
-	// TODO: Validate Versions contain the versions that this wrapper can use.
-	return &BinaryPlugin{commandRunner: commandRunner, pluginMetadata: metadata, log: log}, nil
+	return metadata, nil
 }
 
-func contain(validVersion transform.Version, versions []transform.Version) bool {
-	for _, v := range versions {
-		if validVersion == v {
-			return true
-		}
+// context applies the Timeout to ctx.
+func (o PluginOptions) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.Timeout > 0 {
+		return context.WithTimeout(ctx, o.Timeout)
 	}
-	return false
+	return context.WithCancel(ctx)
 }
 
 func (b *BinaryPlugin) Run(request transform.PluginRequest) (transform.PluginResponse, error) {
+	return b.RunWithContext(context.Background(), request)
+}
+
+// RunWithContext runs the plugin, the plugin is killed when ctx is done or
+// the Timeout passes.
+func (b *BinaryPlugin) RunWithContext(ctx context.Context, request transform.PluginRequest) (transform.PluginResponse, error) {
 	p := transform.PluginResponse{}
+	if request.Version == "" {
+		request.Version = b.version
+	}
+	// Only the objects the plugin asked for are sent
+	if b.pluginMetadata.ContextRequest != nil && request.Version != transform.V1 {
+		request.Resources = transform.NewResourceIndex(request.Resources.Context(request.Unstructured, *b.pluginMetadata.ContextRequest))
+	} else {
+		request.Resources = nil
+	}
 
-	out, logBytes, err := b.commandRunner.Run(request, b.log)
+	ctx, cancel := b.options.context(ctx)
+	defer cancel()
+	log := b.log.WithFields(logrus.Fields{
+		"gvk":       request.GroupVersionKind().String(),
+		"namespace": request.GetNamespace(),
+		"name":      request.GetName(),
+	})
+	out, logBytes, err := b.commandRunner.Run(ctx, request, log)
 	if err != nil {
-		b.log.Errorf("error running the plugin command")
+		log.Errorf("error running the plugin command")
+		if perr, ok := err.(*errors.PluginError); ok {
+			return p, perr
+		}
+		if errors.IsVerificationError(err) {
+			return p, err
+		}
+		if perr := pluginErrorFromStderr(logBytes); perr != nil {
+			return p, perr
+		}
 		return p, fmt.Errorf("error running the plugin command: %v", err)
 	}
 	if len(logBytes) != 0 {
-		logs := strings.Split(string(logBytes), "\n")
-		//TODO: we should be able to find what type of log, warning, debug, error, info and based on debug level filter them out
-		for _, line := range logs {
-			switch {
-			case strings.Contains(line, "level=info"):
-				b.log.Infof("Plugin: %v -- %v ", b.pluginMetadata.Name, line)
-			case strings.Contains(line, "level=warning"):
-				b.log.Warnf("Plugin: %v -- %v ", b.pluginMetadata.Name, line)
-			case strings.Contains(line, "level=error"):
-				b.log.Errorf("Plugin: %v -- %v ", b.pluginMetadata.Name, line)
-			case strings.Contains(line, "level=debug"):
-				b.log.Debugf("Plugin: %v -- %v ", b.pluginMetadata.Name, line)
-			}
+		for _, line := range strings.Split(string(logBytes), "\n") {
+			logPluginLine(log, line, b.options.StderrLevel)
 		}
 	}
 
 	err = json.Unmarshal(out, &p)
 	if err != nil {
-		b.log.Errorf("unable to decode json sent by the plugin")
+		log.Errorf("unable to decode json sent by the plugin")
 		return p, fmt.Errorf("unable to decode object sent by the plugin: %s, err: %v", string(out), err)
 	}
 
 	return p, nil
 }
 
+// pluginErrorFromStderr returns the PluginError written by cli.WriterErrorAndExit,
+// which is always the last thing a failing plugin writes to stderr.
+func pluginErrorFromStderr(stderr []byte) *errors.PluginError {
+	lines := strings.Split(strings.TrimSpace(string(stderr)), "\n")
+	perr := &errors.PluginError{}
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), perr); err != nil || perr.Type == "" {
+		return nil
+	}
+	return perr
+}
+
 func (b *BinaryPlugin) Metadata() transform.PluginMetadata {
 	return b.pluginMetadata
 }
 
+// Close stops the plugin process when running in persistent mode, and removes
+// the copy of a verified binary.
+func (b *BinaryPlugin) Close() error {
+	if closer, ok := b.commandRunner.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 type commandRunner interface {
-	Run(request transform.PluginRequest, log logrus.FieldLogger) ([]byte, []byte, error)
-	Metadata(log logrus.FieldLogger) ([]byte, []byte, error)
+	Run(ctx context.Context, request transform.PluginRequest, log logrus.FieldLogger) ([]byte, []byte, error)
+	Metadata(ctx context.Context, log logrus.FieldLogger) ([]byte, []byte, error)
 }
 
 type binaryRunner struct {
-	pluginPath string
+	pluginPath  string
+	outputLimit int64
+	// verifiedPath is the copy of the verified binary which is run instead
+	// of pluginPath, it is empty when the binary is not verified.
+	verifiedPath string
+}
+
+// newCommand returns a command running the plugin.
+func (b *binaryRunner) newCommand() *exec.Cmd {
+	if b.verifiedPath != "" {
+		return cliContext.getCommand(b.verifiedPath)
+	}
+	return cliContext.getCommand(b.pluginPath)
+}
+
+// Close removes the copy of the verified binary.
+func (b *binaryRunner) Close() error {
+	if b.verifiedPath == "" {
+		return nil
+	}
+	return os.RemoveAll(filepath.Dir(b.verifiedPath))
 }
 
 // Type to use for
@@ -123,51 +332,64 @@ func (e execContext) getCommand(name string, arg ...string) *exec.Cmd {
 
 var cliContext execContext
 
-func (b *binaryRunner) Metadata(log logrus.FieldLogger) ([]byte, []byte, error) {
-	command := cliContext.getCommand(b.pluginPath)
-
-	// set var to get the output
-	var out bytes.Buffer
-	var errorBytes bytes.Buffer
+func (b *binaryRunner) Metadata(ctx context.Context, log logrus.FieldLogger) ([]byte, []byte, error) {
+	command := b.newCommand()
 
-	// set the output to our variable
-	command.Stdout = &out
-	command.Stdin = bytes.NewBufferString(MetadataRequest)
-	command.Stderr = &errorBytes
-	err := command.Run()
+	out, errorBytes, err := runCommand(ctx, command, bytes.NewBufferString(MetadataRequest), b.outputLimit)
 	if err != nil {
 		log.Errorf("unable to run the plugin binary")
+		if perr, ok := err.(*errors.PluginError); ok {
+			return nil, nil, perr
+		}
 		return nil, nil, fmt.Errorf("unable to run the plugin binary, err: %v", err)
 	}
 
-	return out.Bytes(), errorBytes.Bytes(), nil
+	return out, errorBytes, nil
 
 }
 
-func (b *binaryRunner) Run(request transform.PluginRequest, log logrus.FieldLogger) ([]byte, []byte, error) {
+// marshalRequest returns the JSON sent to the plugin on stdin. The extras and,
+// for any version after V1, the protocol version and the related objects are
+// added as top level fields.
+func marshalRequest(request transform.PluginRequest) ([]byte, error) {
 	unstructuredJson, err := request.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
 	objMap := map[string]interface{}{}
-	json.Unmarshal(unstructuredJson, &objMap)
+	err = json.Unmarshal(unstructuredJson, &objMap)
+	if err != nil {
+		return nil, err
+	}
 	objMap["extras"] = request.Extras
-	objJson, err := json.Marshal(objMap)
+	if request.Version != "" && request.Version != transform.V1 {
+		objMap[transform.RequestVersionKey] = request.Version
+		if request.Resources.Len() > 0 {
+			related := []map[string]interface{}{}
+			for _, object := range request.Resources.Objects() {
+				related = append(related, object.Object)
+			}
+			objMap[transform.RequestContextKey] = related
+		}
+	}
+	return json.Marshal(objMap)
+}
+
+func (b *binaryRunner) Run(ctx context.Context, request transform.PluginRequest, log logrus.FieldLogger) ([]byte, []byte, error) {
+	objJson, err := marshalRequest(request)
 	if err != nil {
 		log.Errorf("unable to marshal unstructured Object")
-		return nil, nil, fmt.Errorf("unable to marshal unstructured Object: %s, err: %v", request, err)
+		return nil, nil, fmt.Errorf("unable to marshal unstructured Object: %v, err: %v", request.Object, err)
 	}
-	command := cliContext.getCommand(b.pluginPath)
+	command := b.newCommand()
 
-	// set var to get the output
-	var out bytes.Buffer
-	var errorBytes bytes.Buffer
-
-	// set the output to our variable
-	command.Stdout = &out
-	command.Stdin = bytes.NewBuffer(objJson)
-	command.Stderr = &errorBytes
-	err = command.Run()
+	out, errorBytes, err := runCommand(ctx, command, bytes.NewBuffer(objJson), b.outputLimit)
 	if err != nil {
 		log.Errorf("unable to run the plugin binary")
-		return nil, errorBytes.Bytes(), fmt.Errorf("unable to run the plugin binary, err: %v", err)
+		if perr, ok := err.(*errors.PluginError); ok {
+			return nil, errorBytes, perr
+		}
+		return nil, errorBytes, fmt.Errorf("unable to run the plugin binary, err: %v", err)
 	}
-	return out.Bytes(), errorBytes.Bytes(), nil
+	return out, errorBytes, nil
 }
@@ -1,16 +1,23 @@
 package binary_plugin
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/konveyor/crane-lib/transform"
+	"github.com/konveyor/crane-lib/transform/cli"
+	"github.com/konveyor/crane-lib/transform/errors"
 	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 type fakeCommandRunner struct {
@@ -19,11 +26,11 @@ type fakeCommandRunner struct {
 	metadataStdout, metadataStderr            []byte
 }
 
-func (f *fakeCommandRunner) Run(_ transform.PluginRequest, _ logrus.FieldLogger) ([]byte, []byte, error) {
+func (f *fakeCommandRunner) Run(_ context.Context, _ transform.PluginRequest, _ logrus.FieldLogger) ([]byte, []byte, error) {
 	return f.stdout, f.stderr, f.errorRunningCommand
 }
 
-func (f *fakeCommandRunner) Metadata(_ logrus.FieldLogger) ([]byte, []byte, error) {
+func (f *fakeCommandRunner) Metadata(_ context.Context, _ logrus.FieldLogger) ([]byte, []byte, error) {
 	return f.metadataStdout, f.metadataStderr, f.errorRunningMetadata
 
 }
@@ -99,6 +106,38 @@ func TestShellMetadataSuccessOptionalField(t *testing.T) {
 	os.Exit(0)
 }
 
+func TestShellMetadataSuccessV2(t *testing.T) {
+	if os.Getenv("GO_TEST_PROCESS") != "1" {
+		return
+	}
+
+	var s string
+	_, err := fmt.Scanln(&s)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	if s != `{}` {
+		os.Exit(1)
+	}
+
+	res, err := json.Marshal(transform.PluginMetadata{
+		Name:            "fakeShellMetadata",
+		Version:         "v1",
+		RequestVersion:  []transform.Version{transform.V1, transform.V2},
+		ResponseVersion: []transform.Version{transform.V1, transform.V2},
+		GroupKinds:      []schema.GroupKind{{Group: "route.openshift.io", Kind: "Route"}},
+	})
+
+	if err != nil {
+		fmt.Fprint(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Fprint(os.Stdout, string(res))
+	os.Exit(0)
+}
+
 func TestShellMetadataFailure(t *testing.T) {
 	if os.Getenv("GO_TEST_PROCESS") != "1" {
 		return
@@ -168,10 +207,11 @@ func TestShellMetadataInvalid(t *testing.T) {
 
 func TestNewBinaryPlugin(t *testing.T) {
 	tests := []struct {
-		name       string
-		want       transform.PluginMetadata
-		wantErr    bool
-		cliContext execContext
+		name        string
+		want        transform.PluginMetadata
+		wantVersion transform.Version
+		wantErr     bool
+		cliContext  execContext
 	}{
 		{
 			name: "ValidStdoutNoStderr",
@@ -210,6 +250,25 @@ func TestNewBinaryPlugin(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "ValidStdoutV2",
+			want: transform.PluginMetadata{
+				Name:            "fakeShellMetadata",
+				Version:         "v1",
+				RequestVersion:  []transform.Version{transform.V1, transform.V2},
+				ResponseVersion: []transform.Version{transform.V1, transform.V2},
+				GroupKinds:      []schema.GroupKind{{Group: "route.openshift.io", Kind: "Route"}},
+			},
+			wantVersion: transform.V2,
+			cliContext: func(name string, args ...string) *exec.Cmd {
+				cs := []string{"-test.run=TestShellMetadataSuccessV2", "--", name}
+				cs = append(cs, args...)
+				cmd := exec.Command(os.Args[0], cs...)
+				cmd.Env = []string{"GO_TEST_PROCESS=1"}
+				return cmd
+			},
+			wantErr: false,
+		},
 		{
 			name: "InValidStdoutNoStderr",
 			cliContext: func(name string, args ...string) *exec.Cmd {
@@ -283,6 +342,13 @@ func TestNewBinaryPlugin(t *testing.T) {
 			if !reflect.DeepEqual(b.Metadata(), tt.want) {
 				t.Errorf("Metadata() got = %v, want %v", b.Metadata(), tt.want)
 			}
+			wantVersion := tt.wantVersion
+			if wantVersion == "" {
+				wantVersion = transform.V1
+			}
+			if version := b.(*BinaryPlugin).version; version != wantVersion {
+				t.Errorf("version got = %v, want %v", version, wantVersion)
+			}
 		})
 	}
 }
@@ -294,6 +360,7 @@ func TestBinaryPlugin_Run(t *testing.T) {
 		runErr         error
 		want           transform.PluginResponse
 		wantErr        bool
+		wantErrorType  string
 	}{
 		{
 			name:   "ValidStdoutNoStderr",
@@ -331,6 +398,14 @@ func TestBinaryPlugin_Run(t *testing.T) {
 			want:    transform.PluginResponse{},
 			wantErr: true,
 		},
+		{
+			name:          "RunErrorPluginError",
+			stderr:        []byte("level=info msg=starting\n" + `{"type":"PluginInvalidInputError","message":"bad input","error":"missing kind"}`),
+			runErr:        fmt.Errorf("exit status 1"),
+			want:          transform.PluginResponse{},
+			wantErr:       true,
+			wantErrorType: errors.PluginInvalidInputError,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -347,9 +422,417 @@ func TestBinaryPlugin_Run(t *testing.T) {
 				t.Errorf("Run() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErrorType != "" {
+				perr, ok := err.(*errors.PluginError)
+				if !ok || perr.Type != tt.wantErrorType {
+					t.Errorf("Run() error = %#v, wantErrorType %v", err, tt.wantErrorType)
+				}
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("Run() got = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestMarshalRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		request transform.PluginRequest
+		want    string
+	}{
+		{
+			name: "V1",
+			request: transform.PluginRequest{
+				Unstructured: unstructured.Unstructured{Object: map[string]interface{}{"kind": "Pod"}},
+				Extras:       map[string]string{"flag": "value"},
+				Version:      transform.V1,
+			},
+			want: `{"extras":{"flag":"value"},"kind":"Pod"}`,
+		},
+		{
+			name: "V2",
+			request: transform.PluginRequest{
+				Unstructured: unstructured.Unstructured{Object: map[string]interface{}{"kind": "Pod"}},
+				Version:      transform.V2,
+			},
+			want: `{"extras":null,"kind":"Pod","requestVersion":"v2"}`,
+		},
+		{
+			name: "V2WithContext",
+			request: transform.PluginRequest{
+				Unstructured: unstructured.Unstructured{Object: map[string]interface{}{"kind": "Pod"}},
+				Version:      transform.V2,
+				Resources: transform.NewResourceIndex([]unstructured.Unstructured{
+					{Object: map[string]interface{}{"kind": "Deployment"}},
+				}),
+			},
+			want: `{"extras":null,"kind":"Pod","requestContext":[{"kind":"Deployment"}],"requestVersion":"v2"}`,
+		},
+		{
+			name: "V1WithContext",
+			request: transform.PluginRequest{
+				Unstructured: unstructured.Unstructured{Object: map[string]interface{}{"kind": "Pod"}},
+				Version:      transform.V1,
+				Resources: transform.NewResourceIndex([]unstructured.Unstructured{
+					{Object: map[string]interface{}{"kind": "Deployment"}},
+				}),
+			},
+			want: `{"extras":null,"kind":"Pod"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := marshalRequest(tt.request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("marshalRequest() got = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// requestRunner records the request it is called with.
+type requestRunner struct {
+	request transform.PluginRequest
+}
+
+func (r *requestRunner) Run(ctx context.Context, request transform.PluginRequest, log logrus.FieldLogger) ([]byte, []byte, error) {
+	r.request = request
+	return []byte(`{}`), nil, nil
+}
+
+func (r *requestRunner) Metadata(ctx context.Context, log logrus.FieldLogger) ([]byte, []byte, error) {
+	return nil, nil, nil
+}
+
+func TestBinaryPluginContextRequest(t *testing.T) {
+	object := func(apiVersion, kind, name string, owner string) unstructured.Unstructured {
+		u := unstructured.Unstructured{}
+		u.SetAPIVersion(apiVersion)
+		u.SetKind(kind)
+		u.SetNamespace("test")
+		u.SetName(name)
+		if owner != "" {
+			u.SetOwnerReferences([]metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: owner}})
+		}
+		return u
+	}
+	pod := object("v1", "Pod", "pod", "rs")
+	index := transform.NewResourceIndex([]unstructured.Unstructured{
+		object("apps/v1", "ReplicaSet", "rs", ""),
+		object("v1", "Service", "svc", ""),
+		pod,
+	})
+
+	tests := []struct {
+		name           string
+		contextRequest *transform.ContextRequest
+		version        transform.Version
+		want           []string
+	}{
+		{
+			name:    "NoContextRequest",
+			version: transform.V2,
+		},
+		{
+			name:           "Owners",
+			contextRequest: &transform.ContextRequest{Owners: true},
+			version:        transform.V2,
+			want:           []string{"rs"},
+		},
+		{
+			name:           "GroupKinds",
+			contextRequest: &transform.ContextRequest{GroupKinds: []schema.GroupKind{{Kind: "Service"}}},
+			version:        transform.V2,
+			want:           []string{"svc"},
+		},
+		{
+			name:           "V1",
+			contextRequest: &transform.ContextRequest{Owners: true},
+			version:        transform.V1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &requestRunner{}
+			b := &BinaryPlugin{
+				commandRunner:  runner,
+				pluginMetadata: transform.PluginMetadata{ContextRequest: tt.contextRequest},
+				version:        tt.version,
+				log:            logrus.New().WithField("test", tt.name),
+			}
+			if _, err := b.Run(transform.PluginRequest{Unstructured: pod, Resources: index}); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, object := range runner.request.Resources.Objects() {
+				got = append(got, object.GetName())
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("expected related objects %v, got: %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestShellPersistent is a plugin served by cli.RunAndExit, it annotates every
+// response with its pid so callers can tell whether the process was reused.
+func TestShellPersistent(t *testing.T) {
+	if os.Getenv("GO_TEST_PROCESS") != "1" {
+		return
+	}
+	cli.RunAndExit(cli.NewCustomPlugin("fakeShellPersistent", "v1", nil, func(request transform.PluginRequest) (transform.PluginResponse, error) {
+		switch request.GetName() {
+		case "crash":
+			os.Exit(2)
+		case "hang":
+			time.Sleep(time.Minute)
+		case "fail":
+			return transform.PluginResponse{}, fmt.Errorf("failed")
+		}
+		return transform.PluginResponse{
+			Version:     "v1",
+			Annotations: map[string]string{"pid": strconv.Itoa(os.Getpid())},
+		}, nil
+	}))
+	os.Exit(0)
+}
+
+func TestBinaryPluginPersistent(t *testing.T) {
+	cliContext = func(name string, args ...string) *exec.Cmd {
+		cs := []string{"-test.run=TestShellPersistent", "--", name}
+		cs = append(cs, args...)
+		cmd := exec.Command(os.Args[0], cs...)
+		cmd.Env = []string{"GO_TEST_PROCESS=1"}
+		return cmd
+	}
+	defer func() { cliContext = nil }()
+
+	plugin, err := NewBinaryPlugin("persistent", logrus.New(), PersistentProcess(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := plugin.(*BinaryPlugin)
+	defer b.Close()
+	if _, ok := b.commandRunner.(*persistentRunner); !ok {
+		t.Fatalf("plugin is not run in persistent mode")
+	}
+
+	request := func(name string) transform.PluginRequest {
+		u := unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "v1", "kind": "Pod"}}
+		u.SetName(name)
+		return transform.PluginRequest{Unstructured: u}
+	}
+	pid := func(name string) string {
+		resp, err := b.Run(request(name))
+		if err != nil {
+			t.Fatalf("Run(%v) error = %v", name, err)
+		}
+		return resp.Annotations["pid"]
+	}
+
+	first := pid("first")
+	if second := pid("second"); second != first {
+		t.Errorf("process was not reused, got pid %v then %v", first, second)
+	}
+
+	_, err = b.Run(request("fail"))
+	if perr, ok := err.(*errors.PluginError); !ok || perr.Type != errors.PluginRunError {
+		t.Errorf("Run() error = %#v, want %v", err, errors.PluginRunError)
+	}
+	if afterFailure := pid("after-failure"); afterFailure != first {
+		t.Errorf("process was restarted after a plugin error, got pid %v then %v", first, afterFailure)
+	}
+
+	if _, err = b.Run(request("crash")); err == nil {
+		t.Errorf("Run() expected an error when the plugin crashes")
+	}
+	if afterCrash := pid("after-crash"); afterCrash == "" || afterCrash == first {
+		t.Errorf("process was not restarted after a crash, got pid %v then %v", first, afterCrash)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestBinaryPluginPersistentFallback(t *testing.T) {
+	cliContext = func(name string, args ...string) *exec.Cmd {
+		cs := []string{"-test.run=TestShellMetadataSuccess", "--", name}
+		cs = append(cs, args...)
+		cmd := exec.Command(os.Args[0], cs...)
+		cmd.Env = []string{"GO_TEST_PROCESS=1"}
+		return cmd
+	}
+	defer func() { cliContext = nil }()
+
+	plugin, err := NewBinaryPlugin("fallback", logrus.New(), PersistentProcess(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := plugin.(*BinaryPlugin).commandRunner.(*binaryRunner); !ok {
+		t.Errorf("plugin without persistent support is not run once per object")
+	}
+}
+
+// TestShellHang answers the metadata request and then, for any object, starts
+// a child holding stdout open and hangs, or floods stdout. With HANG_METADATA
+// it hangs on the metadata request.
+func TestShellHang(t *testing.T) {
+	if os.Getenv("GO_TEST_PROCESS") != "1" {
+		return
+	}
+	m := map[string]interface{}{}
+	if err := json.NewDecoder(os.Stdin).Decode(&m); err != nil {
+		os.Exit(1)
+	}
+	if len(m) == 0 && os.Getenv("HANG_METADATA") == "1" {
+		time.Sleep(time.Minute)
+	}
+	if len(m) == 0 {
+		json.NewEncoder(os.Stdout).Encode(transform.PluginMetadata{
+			Name:            "fakeShellHang",
+			Version:         "v1",
+			RequestVersion:  []transform.Version{transform.V1},
+			ResponseVersion: []transform.Version{transform.V1},
+		})
+		os.Exit(0)
+	}
+	if os.Getenv("FLOOD") == "1" {
+		for {
+			fmt.Fprint(os.Stdout, "flood")
+		}
+	}
+	child := exec.Command("sleep", "60")
+	child.Stdout = os.Stdout
+	child.Start()
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+var hangMetadata = KnownMetadata{
+	Name:            "fakeShellHang",
+	Version:         "v1",
+	RequestVersion:  []transform.Version{transform.V1},
+	ResponseVersion: []transform.Version{transform.V1},
+}
+
+func TestBinaryPluginLimits(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       []string
+		opts      []PluginOption
+		ctx       func() (context.Context, context.CancelFunc)
+		wantError func(error) bool
+	}{
+		{
+			name: "Timeout",
+			env:  []string{"GO_TEST_PROCESS=1"},
+			// The metadata is known so the timeout only bounds the run
+			opts:      []PluginOption{Timeout(200 * time.Millisecond), hangMetadata},
+			wantError: errors.IsTimeoutError,
+		},
+		{
+			name: "ContextDeadline",
+			env:  []string{"GO_TEST_PROCESS=1"},
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 200*time.Millisecond)
+			},
+			wantError: errors.IsTimeoutError,
+		},
+		{
+			name:      "OutputLimit",
+			env:       []string{"GO_TEST_PROCESS=1", "FLOOD=1"},
+			opts:      []PluginOption{OutputLimit(1024)},
+			wantError: errors.IsInvalidIOError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cliContext = func(name string, args ...string) *exec.Cmd {
+				cs := []string{"-test.run=TestShellHang", "--", name}
+				cs = append(cs, args...)
+				cmd := exec.Command(os.Args[0], cs...)
+				cmd.Env = append(os.Environ(), tt.env...)
+				return cmd
+			}
+			defer func() { cliContext = nil }()
+
+			plugin, err := NewBinaryPlugin(tt.name, logrus.New(), tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.Background(), context.CancelFunc(func() {})
+			if tt.ctx != nil {
+				ctx, cancel = tt.ctx()
+			}
+			defer cancel()
+
+			start := time.Now()
+			_, err = plugin.(*BinaryPlugin).RunWithContext(ctx, transform.PluginRequest{Unstructured: unstructured.Unstructured{Object: map[string]interface{}{"kind": "Pod"}}})
+			if !tt.wantError(err) {
+				t.Errorf("RunWithContext() error = %v", err)
+			}
+			if elapsed := time.Since(start); elapsed > 10*time.Second {
+				t.Errorf("RunWithContext() took %v, the plugin was not killed", elapsed)
+			}
+		})
+	}
+}
+
+func TestBinaryPluginPersistentTimeout(t *testing.T) {
+	cliContext = func(name string, args ...string) *exec.Cmd {
+		cs := []string{"-test.run=TestShellPersistent", "--", name}
+		cs = append(cs, args...)
+		cmd := exec.Command(os.Args[0], cs...)
+		cmd.Env = []string{"GO_TEST_PROCESS=1"}
+		return cmd
+	}
+	defer func() { cliContext = nil }()
+
+	// The metadata is known so the timeout only bounds the runs
+	metadata := KnownMetadata{
+		Name:            "fakeShellPersistent",
+		Version:         "v1",
+		RequestVersion:  []transform.Version{transform.V1},
+		ResponseVersion: []transform.Version{transform.V1},
+		Persistent:      true,
+	}
+	plugin, err := NewBinaryPlugin("persistent", logrus.New(), PersistentProcess(true), Timeout(time.Second), metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := plugin.(*BinaryPlugin)
+	defer b.Close()
+
+	request := func(name string) transform.PluginRequest {
+		u := unstructured.Unstructured{Object: map[string]interface{}{"apiVersion": "v1", "kind": "Pod"}}
+		u.SetName(name)
+		return transform.PluginRequest{Unstructured: u}
+	}
+	if _, err := b.Run(request("hang")); !errors.IsTimeoutError(err) {
+		t.Errorf("Run() error = %v, want a timeout", err)
+	}
+	if _, err := b.Run(request("after-timeout")); err != nil {
+		t.Errorf("Run() after a timeout error = %v", err)
+	}
+}
+
+func TestBinaryPluginMetadataTimeout(t *testing.T) {
+	cliContext = func(name string, args ...string) *exec.Cmd {
+		cs := []string{"-test.run=TestShellHang", "--", name}
+		cs = append(cs, args...)
+		cmd := exec.Command(os.Args[0], cs...)
+		cmd.Env = append(os.Environ(), "GO_TEST_PROCESS=1", "HANG_METADATA=1")
+		return cmd
+	}
+	defer func() { cliContext = nil }()
+
+	_, err := NewBinaryPlugin("hang-metadata", logrus.New(), Timeout(200*time.Millisecond))
+	if !errors.IsTimeoutError(err) {
+		t.Errorf("NewBinaryPlugin() error = %v, want a timeout", err)
+	}
+}
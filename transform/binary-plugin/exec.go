@@ -0,0 +1,102 @@
+package binary_plugin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+
+	"github.com/konveyor/crane-lib/transform/errors"
+)
+
+// runCommand runs the command until it exits, the context is done or either
+// of its outputs grows past limit bytes. In the last two cases the process and
+// all of its children are killed. A limit of 0 leaves the output unbounded.
+func runCommand(ctx context.Context, command *exec.Cmd, stdin io.Reader, limit int64) ([]byte, []byte, error) {
+	exceeded := make(chan struct{})
+	once := sync.Once{}
+	onExceeded := func() { once.Do(func() { close(exceeded) }) }
+
+	out := &limitedBuffer{limit: limit, exceeded: onExceeded}
+	errorBytes := &limitedBuffer{limit: limit, exceeded: onExceeded}
+	command.Stdin = stdin
+	command.Stdout = out
+	command.Stderr = errorBytes
+	setProcessGroup(command)
+
+	if err := command.Start(); err != nil {
+		return nil, nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- command.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		// Wait returns once the outputs are copied, the output limit is
+		// always detected by then.
+		select {
+		case <-exceeded:
+			return nil, errorBytes.Bytes(), outputLimitError(limit)
+		default:
+		}
+		return out.Bytes(), errorBytes.Bytes(), err
+	case <-ctx.Done():
+		killProcessTree(command)
+		<-done
+		return nil, errorBytes.Bytes(), contextError(ctx)
+	case <-exceeded:
+		killProcessTree(command)
+		<-done
+		return nil, errorBytes.Bytes(), outputLimitError(limit)
+	}
+}
+
+// contextError returns a PluginTimeoutError when the deadline of the context
+// passed, otherwise the error of the context.
+func contextError(ctx context.Context) error {
+	if ctx.Err() != context.DeadlineExceeded {
+		return ctx.Err()
+	}
+	return &errors.PluginError{
+		Type:         errors.PluginTimeoutError,
+		Message:      "plugin did not finish in time",
+		ErrorMessage: ctx.Err().Error(),
+	}
+}
+
+func outputLimitError(limit int64) error {
+	return &errors.PluginError{
+		Type:         errors.PluginInvalidIOError,
+		Message:      "plugin output is too large",
+		ErrorMessage: fmt.Sprintf("output exceeded the limit of %d bytes", limit),
+	}
+}
+
+// limitedBuffer keeps at most limit bytes and calls exceeded once more is
+// written. Writes never fail so the process is not blocked before it is killed.
+// The buffer is not embedded so io.Copy can not bypass Write with ReadFrom.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int64
+	exceeded func()
+}
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	if l.limit <= 0 {
+		return l.buf.Write(p)
+	}
+	if remaining := l.limit - int64(l.buf.Len()); int64(len(p)) > remaining {
+		l.buf.Write(p[:remaining])
+		l.exceeded()
+		return len(p), nil
+	}
+	return l.buf.Write(p)
+}
+
+func (l *limitedBuffer) Bytes() []byte {
+	return l.buf.Bytes()
+}
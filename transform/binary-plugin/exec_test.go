@@ -0,0 +1,26 @@
+package binary_plugin
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/konveyor/crane-lib/transform/errors"
+)
+
+func TestRunCommandOutputLimit(t *testing.T) {
+	// The process exits right after going over the limit, which can be seen
+	// before or after the limit
+	for i := 0; i < 20; i++ {
+		out, _, err := runCommand(context.TODO(), exec.Command("sh", "-c", "printf 0123456789"), nil, 4)
+		perr, ok := err.(*errors.PluginError)
+		if !ok || perr.Type != errors.PluginInvalidIOError {
+			t.Fatalf("expected a PluginInvalidIOError, got %q, %v", out, err)
+		}
+	}
+
+	out, _, err := runCommand(context.TODO(), exec.Command("sh", "-c", "printf 0123"), nil, 4)
+	if err != nil || string(out) != "0123" {
+		t.Errorf("expected the output within the limit, got %q, %v", out, err)
+	}
+}
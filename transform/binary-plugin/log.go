@@ -0,0 +1,65 @@
+package binary_plugin
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// textLevel finds the level of a record written by the logrus text formatter,
+// which was used by plugins built before cli.Logger wrote JSON.
+var textLevel = regexp.MustCompile(`(?:^|\s)level=(\w+)`)
+
+// logPluginLine logs a line the plugin wrote to stderr. JSON records written
+// by cli.Logger keep their level, message and fields and text records keep
+// their level. Any other line is logged as is at the fallback level.
+func logPluginLine(log logrus.FieldLogger, line string, fallback logrus.Level) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	record := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(line), &record); err == nil {
+		if l, ok := record[logrus.FieldKeyLevel].(string); ok {
+			if level, err := logrus.ParseLevel(l); err == nil {
+				msg, _ := record[logrus.FieldKeyMsg].(string)
+				fields := logrus.Fields{}
+				for key, value := range record {
+					switch key {
+					case logrus.FieldKeyLevel, logrus.FieldKeyMsg, logrus.FieldKeyTime:
+					default:
+						fields[key] = value
+					}
+				}
+				logAtLevel(log.WithFields(fields), level, msg)
+				return
+			}
+		}
+	}
+
+	if match := textLevel.FindStringSubmatch(line); match != nil {
+		if level, err := logrus.ParseLevel(match[1]); err == nil {
+			logAtLevel(log, level, line)
+			return
+		}
+	}
+	logAtLevel(log, fallback, line)
+}
+
+// logAtLevel logs msg at level, a plugin can not make the caller panic or exit
+// so those levels are logged as errors.
+func logAtLevel(log logrus.FieldLogger, level logrus.Level, msg string) {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		log.Error(msg)
+	case logrus.WarnLevel:
+		log.Warn(msg)
+	case logrus.InfoLevel:
+		log.Info(msg)
+	default:
+		log.Debug(msg)
+	}
+}
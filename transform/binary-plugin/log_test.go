@@ -0,0 +1,120 @@
+package binary_plugin
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/konveyor/crane-lib/transform"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestLogPluginLine(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		wantLogged bool
+		wantLevel  logrus.Level
+		wantMsg    string
+		wantFields logrus.Fields
+	}{
+		{
+			name:       "JSONRecord",
+			line:       `{"level":"warning","msg":"route has no host","time":"2021-06-10T04:11:21Z","route":"foo"}`,
+			wantLogged: true,
+			wantLevel:  logrus.WarnLevel,
+			wantMsg:    "route has no host",
+			wantFields: logrus.Fields{"plugin": "test", "route": "foo"},
+		},
+		{
+			name:       "JSONRecordFatal",
+			line:       `{"level":"fatal","msg":"giving up"}`,
+			wantLogged: true,
+			wantLevel:  logrus.ErrorLevel,
+			wantMsg:    "giving up",
+			wantFields: logrus.Fields{"plugin": "test"},
+		},
+		{
+			name:       "TextRecord",
+			line:       `time="2021-06-10T04:11:21Z" level=debug msg="checking route"`,
+			wantLogged: true,
+			wantLevel:  logrus.DebugLevel,
+			wantMsg:    `time="2021-06-10T04:11:21Z" level=debug msg="checking route"`,
+			wantFields: logrus.Fields{"plugin": "test"},
+		},
+		{
+			name:       "UnparseableLine",
+			line:       "panic: invalid reference",
+			wantLogged: true,
+			wantLevel:  logrus.InfoLevel,
+			wantMsg:    "panic: invalid reference",
+			wantFields: logrus.Fields{"plugin": "test"},
+		},
+		{
+			name:       "JSONWithoutLevel",
+			line:       `{"type":"PluginRunError"}`,
+			wantLogged: true,
+			wantLevel:  logrus.InfoLevel,
+			wantMsg:    `{"type":"PluginRunError"}`,
+			wantFields: logrus.Fields{"plugin": "test"},
+		},
+		{
+			name: "EmptyLine",
+			line: "  ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, hook := test.NewNullLogger()
+			logger.SetLevel(logrus.DebugLevel)
+			logPluginLine(logger.WithField("plugin", "test"), tt.line, logrus.InfoLevel)
+
+			if !tt.wantLogged {
+				if len(hook.Entries) != 0 {
+					t.Errorf("logPluginLine() logged %v", hook.Entries)
+				}
+				return
+			}
+			if len(hook.Entries) != 1 {
+				t.Fatalf("logPluginLine() logged %v entries, want 1", len(hook.Entries))
+			}
+			entry := hook.LastEntry()
+			if entry.Level != tt.wantLevel || entry.Message != tt.wantMsg {
+				t.Errorf("logPluginLine() logged %v %q, want %v %q", entry.Level, entry.Message, tt.wantLevel, tt.wantMsg)
+			}
+			if !reflect.DeepEqual(entry.Data, tt.wantFields) {
+				t.Errorf("logPluginLine() fields = %v, want %v", entry.Data, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestBinaryPlugin_RunLogFields(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	b := &BinaryPlugin{
+		commandRunner: &fakeCommandRunner{
+			stdout: []byte(`{"version": "v1"}`),
+			stderr: []byte(`{"level":"info","msg":"adding annotation"}` + "\n"),
+		},
+		options: PluginOptions{StderrLevel: logrus.InfoLevel},
+		log:     logger.WithField("plugin", "test"),
+	}
+	u := unstructured.Unstructured{}
+	u.SetAPIVersion("route.openshift.io/v1")
+	u.SetKind("Route")
+	u.SetNamespace("bar")
+	u.SetName("foo")
+	if _, err := b.Run(transform.PluginRequest{Unstructured: u}); err != nil {
+		t.Fatal(err)
+	}
+	want := logrus.Fields{
+		"plugin":    "test",
+		"gvk":       "route.openshift.io/v1, Kind=Route",
+		"namespace": "bar",
+		"name":      "foo",
+	}
+	if len(hook.Entries) != 1 || !reflect.DeepEqual(hook.LastEntry().Data, want) {
+		t.Errorf("Run() logged %v, want one entry with fields %v", hook.Entries, want)
+	}
+}
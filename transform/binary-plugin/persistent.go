@@ -0,0 +1,234 @@
+package binary_plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/konveyor/crane-lib/transform"
+	"github.com/konveyor/crane-lib/transform/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// closeTimeout is how long a plugin gets to exit after its stdin is closed
+// before it is killed.
+const closeTimeout = 5 * time.Second
+
+// maxLogLine is the longest line kept from stderr, longer lines are split.
+const maxLogLine = 64 * 1024
+
+// persistentRunner keeps a single plugin process running and sends it one
+// request at a time. The process is started on the first request and started
+// again on the next request if it dies.
+type persistentRunner struct {
+	binaryRunner
+	stderr *lineLogger
+
+	mu      sync.Mutex
+	command *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+}
+
+// streamResponse is transform.StreamResponse with the response left encoded.
+type streamResponse struct {
+	Response json.RawMessage     `json:"response,omitempty"`
+	Error    *errors.PluginError `json:"error,omitempty"`
+}
+
+func newPersistentRunner(runner binaryRunner, options PluginOptions, log logrus.FieldLogger) *persistentRunner {
+	return &persistentRunner{
+		binaryRunner: runner,
+		stderr:       &lineLogger{log: log, level: options.StderrLevel},
+	}
+}
+
+func (p *persistentRunner) Run(ctx context.Context, request transform.PluginRequest, log logrus.FieldLogger) ([]byte, []byte, error) {
+	objJson, err := marshalRequest(request)
+	if err != nil {
+		log.Errorf("unable to marshal unstructured Object")
+		return nil, nil, fmt.Errorf("unable to marshal unstructured Object: %v, err: %v", request.Object, err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Lines written to stderr while this request runs are logged with its fields
+	p.stderr.setLog(log)
+	if p.command == nil {
+		if err := p.start(); err != nil {
+			log.Errorf("unable to start the plugin binary")
+			return nil, nil, fmt.Errorf("unable to start the plugin binary, err: %v", err)
+		}
+	}
+
+	// The process is killed when ctx is done, which unblocks the round trip.
+	type result struct {
+		line []byte
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		line, err := p.roundTrip(objJson)
+		results <- result{line: line, err: err}
+	}()
+	var line []byte
+	select {
+	case r := <-results:
+		line, err = r.line, r.err
+	case <-ctx.Done():
+		err = contextError(ctx)
+		p.stop()
+		<-results
+	}
+	if err != nil {
+		log.Errorf("plugin process failed, it will be restarted on the next request")
+		p.stop()
+		if perr, ok := err.(*errors.PluginError); ok {
+			return nil, nil, perr
+		}
+		return nil, nil, fmt.Errorf("unable to run the plugin binary, err: %v", err)
+	}
+
+	resp := streamResponse{}
+	if err := json.Unmarshal(line, &resp); err != nil {
+		log.Errorf("unable to decode json sent by the plugin")
+		return nil, nil, fmt.Errorf("unable to decode response sent by the plugin: %s, err: %v", string(line), err)
+	}
+	if resp.Error != nil {
+		return nil, nil, resp.Error
+	}
+	return resp.Response, nil, nil
+}
+
+// roundTrip writes a single request and reads the response line, which may
+// not be longer than the output limit.
+func (p *persistentRunner) roundTrip(request []byte) ([]byte, error) {
+	if _, err := p.stdin.Write(append(request, '\n')); err != nil {
+		return nil, err
+	}
+	var line []byte
+	for {
+		chunk, err := p.stdout.ReadSlice('\n')
+		line = append(line, chunk...)
+		if p.outputLimit > 0 && int64(len(line)) > p.outputLimit {
+			return nil, outputLimitError(p.outputLimit)
+		}
+		if err != bufio.ErrBufferFull {
+			return line, err
+		}
+	}
+}
+
+func (p *persistentRunner) start() error {
+	command := p.newCommand()
+	if command.Env == nil {
+		command.Env = os.Environ()
+	}
+	command.Env = append(command.Env, transform.PersistentModeEnv+"=true")
+	command.Stderr = p.stderr
+	setProcessGroup(command)
+
+	stdin, err := command.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := command.Start(); err != nil {
+		return err
+	}
+	p.command, p.stdin, p.stdout = command, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+// stop kills the process, it must be called with the lock held.
+func (p *persistentRunner) stop() {
+	if p.command == nil {
+		return
+	}
+	p.stdin.Close()
+	killProcessTree(p.command)
+	p.command.Wait()
+	p.command = nil
+}
+
+// Close closes the stdin of the plugin and waits for it to exit, the plugin
+// is killed if it does not exit within closeTimeout. The copy of a verified
+// binary is removed afterwards.
+func (p *persistentRunner) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.closeProcess(); err != nil {
+		p.binaryRunner.Close()
+		return err
+	}
+	return p.binaryRunner.Close()
+}
+
+// closeProcess stops the process for Close, it must be called with the lock
+// held.
+func (p *persistentRunner) closeProcess() error {
+	if p.command == nil {
+		return nil
+	}
+	command := p.command
+	p.command = nil
+	p.stdin.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- command.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(closeTimeout):
+		killProcessTree(command)
+		<-done
+		return fmt.Errorf("plugin %v did not exit after its input was closed", p.pluginPath)
+	}
+}
+
+// lineLogger logs every complete line written to it with logPluginLine.
+type lineLogger struct {
+	mu    sync.Mutex
+	log   logrus.FieldLogger
+	level logrus.Level
+	buf   bytes.Buffer
+}
+
+// setLog changes the logger used for the following lines.
+func (l *lineLogger) setLog(log logrus.FieldLogger) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.log = log
+}
+
+func (l *lineLogger) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf.Write(b)
+	for {
+		i := bytes.IndexByte(l.buf.Bytes(), '\n')
+		if i < 0 {
+			if l.buf.Len() > maxLogLine {
+				logPluginLine(l.log, l.buf.String(), l.level)
+				l.buf.Reset()
+			}
+			return len(b), nil
+		}
+		line := string(l.buf.Next(i + 1))
+		logPluginLine(l.log, line[:len(line)-1], l.level)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package binary_plugin
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup is a no-op, process groups are not available.
+func setProcessGroup(command *exec.Cmd) {}
+
+// killProcessTree only kills the plugin itself, processes it started are left
+// running.
+func killProcessTree(command *exec.Cmd) {
+	if command.Process == nil {
+		return
+	}
+	command.Process.Kill()
+}
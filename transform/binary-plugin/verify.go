@@ -0,0 +1,248 @@
+package binary_plugin
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/konveyor/crane-lib/transform/errors"
+)
+
+// SignatureSuffix is appended to the path of a plugin to find its detached
+// signature when no SignatureFile is given.
+const SignatureSuffix = ".sig"
+
+// Digest is the hex encoded SHA-256 digest the plugin binary must have.
+type Digest string
+
+func (d Digest) ApplyTo(opts *PluginOptions) error {
+	digest := strings.ToLower(strings.TrimPrefix(string(d), "sha256:"))
+	if b, err := hex.DecodeString(digest); err != nil || len(b) != sha256.Size {
+		return fmt.Errorf("invalid SHA-256 digest %q", d)
+	}
+	opts.Digest = digest
+	return nil
+}
+
+// SignatureFile is the path of the detached signature of the plugin binary,
+// which must be verified by a key of the TrustStore. Defaults to the path of
+// the binary with the SignatureSuffix.
+type SignatureFile string
+
+func (s SignatureFile) ApplyTo(opts *PluginOptions) error {
+	opts.SignatureFile = string(s)
+	return nil
+}
+
+// TrustStore holds the public keys trusted to sign plugin binaries. When
+// given as an option, the binary must have a detached signature verified by
+// one of the keys.
+//
+// Both ed25519 signatures of the binary and cosign style ECDSA signatures,
+// made with `cosign sign-blob`, of its SHA-256 digest are supported. Signature
+// files can be raw or base64 encoded.
+type TrustStore struct {
+	keys []trustedKey
+}
+
+type trustedKey struct {
+	name string
+	key  crypto.PublicKey
+}
+
+// LoadTrustStore reads the PEM encoded public keys in the given files, and in
+// the *.pem and *.pub files of the given directories.
+func LoadTrustStore(paths ...string) (*TrustStore, error) {
+	t := &TrustStore{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read trust store: %v", err)
+		}
+		files := []string{path}
+		if info.IsDir() {
+			files = nil
+			for _, pattern := range []string{"*.pem", "*.pub"} {
+				matches, err := filepath.Glob(filepath.Join(path, pattern))
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, matches...)
+			}
+			sort.Strings(files)
+		}
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read trust store: %v", err)
+			}
+			if err := t.AddKeys(file, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return t, nil
+}
+
+// AddKeys adds the PEM encoded PKIX public keys, ed25519 or ECDSA, in data.
+// The keys are named after source in the logs.
+func (t *TrustStore) AddKeys(source string, data []byte) error {
+	added := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "PUBLIC KEY" {
+			continue
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("invalid public key in %v: %v", source, err)
+		}
+		switch key.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey:
+		default:
+			return fmt.Errorf("unsupported public key type %T in %v", key, source)
+		}
+		t.keys = append(t.keys, trustedKey{name: fmt.Sprintf("%v#%v", source, added), key: key})
+		added++
+	}
+	if added == 0 {
+		return fmt.Errorf("no public key found in %v", source)
+	}
+	return nil
+}
+
+// Len returns the number of keys in the trust store.
+func (t *TrustStore) Len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.keys)
+}
+
+// Verify returns the name of the first key verifying the signature of data,
+// or an error when no key does.
+func (t *TrustStore) Verify(data, signature []byte) (string, error) {
+	digest := sha256.Sum256(data)
+	for _, k := range t.keys {
+		switch key := k.key.(type) {
+		case ed25519.PublicKey:
+			if len(signature) == ed25519.SignatureSize && ed25519.Verify(key, data, signature) {
+				return k.name, nil
+			}
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(key, digest[:], signature) {
+				return k.name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("signature not verified by any of the %v trusted keys", t.Len())
+}
+
+func (t *TrustStore) ApplyTo(opts *PluginOptions) error {
+	if t.Len() == 0 {
+		return fmt.Errorf("empty trust store")
+	}
+	opts.TrustStore = t
+	return nil
+}
+
+// decodeSignature returns the signature in a signature file, which is either
+// base64 encoded, as written by cosign, or raw.
+func decodeSignature(data []byte) []byte {
+	if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data))); err == nil {
+		return decoded
+	}
+	return data
+}
+
+// verifier checks the binary against its digest and signature once, when the
+// plugin is created. The verified bytes are copied to a private file which is
+// run instead of the binary, so a binary changed or replaced after the check
+// is never run.
+type verifier struct {
+	path          string
+	digest        string
+	signatureFile string
+	trustStore    *TrustStore
+}
+
+// newVerifier returns nil when the options do not ask for any verification.
+func newVerifier(path string, options PluginOptions) (*verifier, error) {
+	if options.SignatureFile != "" && options.TrustStore == nil {
+		return nil, fmt.Errorf("a signature file requires a trust store")
+	}
+	if options.Digest == "" && options.TrustStore == nil {
+		return nil, nil
+	}
+	signatureFile := options.SignatureFile
+	if signatureFile == "" {
+		signatureFile = path + SignatureSuffix
+	}
+	return &verifier{path: path, digest: options.Digest, signatureFile: signatureFile, trustStore: options.TrustStore}, nil
+}
+
+// verify returns the content of the binary, or an errors.VerificationError
+// when it does not match.
+func (v *verifier) verify() ([]byte, error) {
+	data, err := ioutil.ReadFile(v.path)
+	if err != nil {
+		return nil, v.failure(err.Error())
+	}
+	if v.digest != "" {
+		sum := sha256.Sum256(data)
+		if actual := hex.EncodeToString(sum[:]); actual != v.digest {
+			return nil, v.failure(fmt.Sprintf("digest sha256:%v does not match the expected sha256:%v", actual, v.digest))
+		}
+	}
+	if v.trustStore != nil {
+		signature, err := ioutil.ReadFile(v.signatureFile)
+		if err != nil {
+			return nil, v.failure(fmt.Sprintf("unable to read signature: %v", err))
+		}
+		if _, err := v.trustStore.Verify(data, decodeSignature(signature)); err != nil {
+			return nil, v.failure(err.Error())
+		}
+	}
+	return data, nil
+}
+
+// verifiedCopy verifies the binary and writes it to a new directory only
+// accessible by the current user, it returns the path of the copy.
+func (v *verifier) verifiedCopy() (string, error) {
+	data, err := v.verify()
+	if err != nil {
+		return "", err
+	}
+	dir, err := ioutil.TempDir("", "crane-plugin-")
+	if err != nil {
+		return "", fmt.Errorf("unable to copy the verified plugin binary: %v", err)
+	}
+	// The name is kept, it is the name of the process and holds the
+	// extension of executables on Windows.
+	path := filepath.Join(dir, filepath.Base(v.path))
+	if err := ioutil.WriteFile(path, data, 0500); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("unable to copy the verified plugin binary: %v", err)
+	}
+	return path, nil
+}
+
+func (v *verifier) failure(reason string) error {
+	return &errors.VerificationError{Path: v.path, Reason: reason}
+}
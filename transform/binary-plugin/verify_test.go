@@ -0,0 +1,241 @@
+package binary_plugin
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/konveyor/crane-lib/transform/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func publicKeyPEM(t *testing.T, key interface{}) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestVerifier(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	binary := []byte("#!/bin/sh\necho plugin\n")
+	path := filepath.Join(tmp, "plugin")
+	if err := ioutil.WriteFile(path, binary, 0755); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(binary)
+	digest := hex.EncodeToString(sum[:])
+
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keysDir := filepath.Join(tmp, "keys")
+	if err := os.Mkdir(keysDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(keysDir, "ed25519.pub"), publicKeyPEM(t, edPublic), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(keysDir, "cosign.pem"), publicKeyPEM(t, &ecPrivate.PublicKey), 0644); err != nil {
+		t.Fatal(err)
+	}
+	trustStore, err := LoadTrustStore(keysDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trustStore.Len() != 2 {
+		t.Fatalf("expected 2 trusted keys, got %v", trustStore.Len())
+	}
+
+	cosignSignature, err := ecdsa.SignASN1(rand.Reader, ecPrivate, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		opts      []PluginOption
+		signature []byte
+		wantErr   bool
+	}{
+		{name: "Digest", opts: []PluginOption{Digest(digest)}},
+		{name: "PrefixedDigest", opts: []PluginOption{Digest("sha256:" + digest)}},
+		{name: "DigestMismatch", opts: []PluginOption{Digest(hex.EncodeToString(make([]byte, sha256.Size)))}, wantErr: true},
+		{name: "Ed25519", opts: []PluginOption{trustStore}, signature: ed25519.Sign(edPrivate, binary)},
+		{
+			name:      "Cosign",
+			opts:      []PluginOption{Digest(digest), trustStore},
+			signature: []byte(base64.StdEncoding.EncodeToString(cosignSignature) + "\n"),
+		},
+		{name: "UntrustedKey", opts: []PluginOption{trustStore}, signature: ed25519.Sign(otherPrivate, binary), wantErr: true},
+		{name: "MissingSignature", opts: []PluginOption{trustStore}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(path + SignatureSuffix)
+			if tt.signature != nil {
+				if err := ioutil.WriteFile(path+SignatureSuffix, tt.signature, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			options := PluginOptions{}
+			if err := options.Apply(tt.opts...); err != nil {
+				t.Fatal(err)
+			}
+			v, err := newVerifier(path, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = v.verify()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.IsVerificationError(err) {
+				t.Errorf("expected a VerificationError, got %T: %v", err, err)
+			}
+		})
+	}
+
+	// The copy keeps the verified content when the binary changes afterwards
+	options := PluginOptions{}
+	if err := options.Apply(Digest(digest)); err != nil {
+		t.Fatal(err)
+	}
+	v, err := newVerifier(path, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, err := v.verifiedCopy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(verified))
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\necho replaced plugin\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(verified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(binary) {
+		t.Errorf("expected the copy to hold the verified binary, got %q", data)
+	}
+	info, err := os.Stat(verified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0500 {
+		t.Errorf("expected the copy to only be readable and executable by its owner, got %v", info.Mode().Perm())
+	}
+	if _, err := v.verifiedCopy(); !errors.IsVerificationError(err) {
+		t.Errorf("expected a VerificationError for a changed binary, got: %v", err)
+	}
+}
+
+func TestVerifierOptions(t *testing.T) {
+	if err := (&PluginOptions{}).Apply(Digest("not-a-digest")); err == nil {
+		t.Errorf("expected an error for an invalid digest")
+	}
+	if err := (&PluginOptions{}).Apply(&TrustStore{}); err == nil {
+		t.Errorf("expected an error for an empty trust store")
+	}
+	if _, err := newVerifier("plugin", PluginOptions{SignatureFile: "plugin.sig"}); err == nil {
+		t.Errorf("expected an error for a signature without a trust store")
+	}
+	if v, err := newVerifier("plugin", PluginOptions{}); v != nil || err != nil {
+		t.Errorf("expected no verifier without options, got %v, %v", v, err)
+	}
+	if _, err := LoadTrustStore("/nonexistent"); err == nil {
+		t.Errorf("expected an error for a missing trust store")
+	}
+	store := &TrustStore{}
+	if err := store.AddKeys("empty", []byte("not a key")); err == nil {
+		t.Errorf("expected an error without a public key")
+	}
+}
+
+func TestNewBinaryPluginVerification(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, "plugin")
+	if err := ioutil.WriteFile(path, []byte("plugin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	started := ""
+	cliContext = func(name string, args ...string) *exec.Cmd {
+		started = name
+		cs := []string{"-test.run=TestShellMetadataSuccess", "--", name}
+		cmd := exec.Command(os.Args[0], cs...)
+		cmd.Env = []string{"GO_TEST_PROCESS=1"}
+		return cmd
+	}
+	defer func() { cliContext = nil }()
+
+	_, err = NewBinaryPlugin(path, logrus.New(), Digest(hex.EncodeToString(make([]byte, sha256.Size))))
+	if !errors.IsVerificationError(err) {
+		t.Errorf("expected a VerificationError, got: %v", err)
+	}
+	if started != "" {
+		t.Errorf("expected the binary not to run")
+	}
+
+	sum := sha256.Sum256([]byte("plugin"))
+	plugin, err := NewBinaryPlugin(path, logrus.New(), Digest(hex.EncodeToString(sum[:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plugin.Metadata().Name != "fakeShellMetadata" {
+		t.Errorf("expected the verified binary to run, got metadata %v", plugin.Metadata())
+	}
+	// The binary changed after its verification is not run
+	if err := ioutil.WriteFile(path, []byte("replaced"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if started == "" || started == path {
+		t.Fatalf("expected a copy of the verified binary to run, got %q", started)
+	}
+	data, err := ioutil.ReadFile(started)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "plugin" {
+		t.Errorf("expected the copy to hold the verified binary, got %q", data)
+	}
+	if err := plugin.(*BinaryPlugin).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(started); !os.IsNotExist(err) {
+		t.Errorf("expected Close to remove the copy, got: %v", err)
+	}
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/konveyor/crane-lib/transform"
 	"github.com/konveyor/crane-lib/transform/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 var (
@@ -29,6 +30,8 @@ func init() {
 	exiter = os.Exit
 	logger = logrus.New()
 	logger.SetOutput(stdErr)
+	// BinaryPlugin parses JSON records into fields of its own logger
+	logger.SetFormatter(&logrus.JSONFormatter{})
 }
 
 type customPlugin struct {
@@ -61,17 +64,40 @@ func NewCustomPlugin(name, version string, optionalFields []transform.OptionalFi
 	}
 }
 
+// NewCustomPluginWithMetadata creates a plugin advertising the given metadata,
+// this is needed to use features of newer protocol versions such as V2. When
+// no versions are set the plugin speaks V1.
+func NewCustomPluginWithMetadata(metadata transform.PluginMetadata, runFunc func(transform.PluginRequest) (transform.PluginResponse, error)) transform.Plugin {
+	if len(metadata.RequestVersion) == 0 {
+		metadata.RequestVersion = []transform.Version{transform.V1}
+	}
+	if len(metadata.ResponseVersion) == 0 {
+		metadata.ResponseVersion = []transform.Version{transform.V1}
+	}
+	return &customPlugin{
+		metadata: metadata,
+		runFunc:  runFunc,
+	}
+}
+
 // Will write the error the standard error and will exit with 1
 func WriterErrorAndExit(err error) {
 	fmt.Fprint(stdErr, err.Error())
 	exiter(1)
 }
 
+// Logger returns the logger plugins should use. It writes JSON records to
+// stderr, which BinaryPlugin logs with their level and fields.
 func Logger() *logrus.Logger {
 	return logger
 }
 
 func RunAndExit(plugin transform.Plugin) {
+	if os.Getenv(transform.PersistentModeEnv) != "" {
+		serve(plugin)
+		return
+	}
+
 	// Get the reader from Standard In.
 	decoder := json.NewDecoder(reader)
 	m := map[string]interface{}{}
@@ -87,7 +113,10 @@ func RunAndExit(plugin transform.Plugin) {
 
 	// Determine if Metadata Call
 	if len(m) == 0 {
-		err := json.NewEncoder(stdOut).Encode(plugin.Metadata())
+		// Every plugin run through RunAndExit can serve persistent mode
+		metadata := plugin.Metadata()
+		metadata.Persistent = true
+		err := json.NewEncoder(stdOut).Encode(metadata)
 		if err != nil {
 			WriterErrorAndExit(&errors.PluginError{
 				Type:         errors.PluginInvalidIOError,
@@ -98,16 +127,112 @@ func RunAndExit(plugin transform.Plugin) {
 		return
 	}
 
+	resp, perr := run(plugin, m)
+	if perr != nil {
+		WriterErrorAndExit(perr)
+		return
+	}
+
+	respBytes, err := json.Marshal(&resp)
+	if err != nil {
+		WriterErrorAndExit(&errors.PluginError{
+			Type:         errors.PluginRunError,
+			Message:      "invalid json plugin output, unable to marshal in",
+			ErrorMessage: err.Error(),
+		})
+	}
+
+	_, err = io.Copy(stdOut, bytes.NewReader(respBytes))
+	if err != nil {
+		WriterErrorAndExit(&errors.PluginError{
+			Type:         errors.PluginInvalidIOError,
+			Message:      "error writing plugin response to stdOut",
+			ErrorMessage: err.Error(),
+		})
+	}
+}
+
+// serve answers every request read from stdin with one StreamResponse line
+// on stdout until stdin is closed. Errors from a single request are sent back
+// in the response, only errors reading or writing the stream end the process.
+func serve(plugin transform.Plugin) {
+	decoder := json.NewDecoder(reader)
+	encoder := json.NewEncoder(stdOut)
+	for {
+		m := map[string]interface{}{}
+		err := decoder.Decode(&m)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			WriterErrorAndExit(&errors.PluginError{
+				Type:         errors.PluginInvalidIOError,
+				Message:      "error reading plugin input from input",
+				ErrorMessage: err.Error(),
+			})
+			return
+		}
+
+		streamResp := transform.StreamResponse{}
+		if len(m) == 0 {
+			streamResp.Error = &errors.PluginError{
+				Type:    errors.PluginInvalidInputError,
+				Message: "metadata is not served in persistent mode",
+			}
+		} else if resp, perr := run(plugin, m); perr != nil {
+			streamResp.Error = perr
+		} else {
+			streamResp.Response = &resp
+		}
+
+		err = encoder.Encode(&streamResp)
+		if err != nil {
+			WriterErrorAndExit(&errors.PluginError{
+				Type:         errors.PluginInvalidIOError,
+				Message:      "error writing plugin response to stdOut",
+				ErrorMessage: err.Error(),
+			})
+			return
+		}
+	}
+}
+
+// run decodes the request sent by the caller and runs the plugin with it.
+func run(plugin transform.Plugin, m map[string]interface{}) (transform.PluginResponse, *errors.PluginError) {
+	// The protocol version is not part of the object
+	version := transform.V1
+	if v, ok := m[transform.RequestVersionKey]; ok {
+		if vString, ok := v.(string); ok {
+			version = transform.Version(vString)
+		}
+		delete(m, transform.RequestVersionKey)
+	}
+
+	// The related objects are not part of the object either
+	var resources *transform.ResourceIndex
+	if related, ok := m[transform.RequestContextKey]; ok {
+		delete(m, transform.RequestContextKey)
+		objects, err := decodeObjects(related)
+		if err != nil {
+			return transform.PluginResponse{}, &errors.PluginError{
+				Type:         errors.PluginInvalidInputError,
+				Message:      "error reading the related objects",
+				ErrorMessage: err.Error(),
+			}
+		}
+		resources = transform.NewResourceIndex(objects)
+	}
+
 	// Ignoring this error as anthing wrong here will be caught in the unmarshalJSON below
 	b, _ := json.Marshal(m)
 	req := transform.PluginRequest{}
-	err = json.Unmarshal(b, &req)
+	err := json.Unmarshal(b, &req)
 	if err != nil {
-		WriterErrorAndExit(&errors.PluginError{
+		return transform.PluginResponse{}, &errors.PluginError{
 			Type:         errors.PluginInvalidInputError,
 			Message:      "error writing plugin response to stdOut",
 			ErrorMessage: err.Error(),
-		})
+		}
 	}
 	extrasIn, ok := m["extras"]
 	var extrasInMap map[string]interface{}
@@ -121,40 +246,46 @@ func RunAndExit(plugin transform.Plugin) {
 			case string:
 				extras[key] = value.(string)
 			default:
-				WriterErrorAndExit(&errors.PluginError{
+				return transform.PluginResponse{}, &errors.PluginError{
 					Type:         errors.PluginInvalidIOError,
 					Message:      "error getting extras value string",
 					ErrorMessage: fmt.Sprintf("value %v for param %v is not a string", value, key),
-				})
+				}
 			}
 		}
 		req.Extras = extras
 	}
 
+	req.Version = version
+	req.Resources = resources
+
 	resp, err := plugin.Run(req)
 	if err != nil {
-		WriterErrorAndExit(&errors.PluginError{
+		return transform.PluginResponse{}, &errors.PluginError{
 			Type:         errors.PluginRunError,
 			Message:      "error when running plugin",
 			ErrorMessage: err.Error(),
-		})
+		}
 	}
-
-	respBytes, err := json.Marshal(&resp)
-	if err != nil {
-		WriterErrorAndExit(&errors.PluginError{
-			Type:         errors.PluginRunError,
-			Message:      "invalid json plugin output, unable to marshal in",
-			ErrorMessage: err.Error(),
-		})
+	if resp.Version == "" && version != transform.V1 {
+		resp.Version = string(version)
 	}
+	return resp, nil
+}
 
-	_, err = io.Copy(stdOut, bytes.NewReader(respBytes))
-	if err != nil {
-		WriterErrorAndExit(&errors.PluginError{
-			Type:         errors.PluginInvalidIOError,
-			Message:      "error writing plugin response to stdOut",
-			ErrorMessage: err.Error(),
-		})
+// decodeObjects reads the list of objects sent in the RequestContextKey field.
+func decodeObjects(value interface{}) ([]unstructured.Unstructured, error) {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v is not a list", transform.RequestContextKey)
+	}
+	objects := []unstructured.Unstructured{}
+	for i, item := range list {
+		object, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("item %v of %v is not an object", i, transform.RequestContextKey)
+		}
+		objects = append(objects, unstructured.Unstructured{Object: object})
 	}
+	return objects, nil
 }
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	jsonpatch "github.com/evanphx/json-patch"
@@ -14,45 +16,33 @@ import (
 	"github.com/konveyor/crane-lib/transform/errors"
 	ijsonpath "github.com/konveyor/crane-lib/transform/internal/jsonpatch"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 type fakeReader struct {
 	*unstructured.Unstructured
 	extras map[string]string
-	err error
-	readObj bool
-	readExtras bool
+	err    error
+	data   io.Reader
 }
 
+// Read returns the object followed by the extras, both as JSON.
 func (f *fakeReader) Read(p []byte) (int, error) {
 	if f.err != nil {
 		return 0, f.err
 	}
-	if !f.readObj {
-		b, err := f.Unstructured.MarshalJSON()
-		for i := range p {
-			if i >= len(b) {
-				f.readObj = true
-				return len(b), err
-			}
-			p[i] = b[i]
+	if f.data == nil {
+		obj, err := f.Unstructured.MarshalJSON()
+		if err != nil {
+			return 0, err
 		}
-		f.readObj = true
-		return len(b), err
-	}
-	if !f.readExtras {
-		b, err := json.Marshal(&f.extras)
-		for i := range p {
-			if i >= len(b) {
-				f.readExtras = true
-				return len(b), err
-			}
-			p[i] = b[i]
+		extras, err := json.Marshal(&f.extras)
+		if err != nil {
+			return 0, err
 		}
-		f.readExtras = true
-		return len(b), err
+		f.data = bytes.NewReader(append(obj, extras...))
 	}
-	return 0, io.EOF
+	return f.data.Read(p)
 }
 
 func TestRunAndExit(t *testing.T) {
@@ -109,6 +99,7 @@ func TestRunAndExit(t *testing.T) {
 				Version:         "v2",
 				RequestVersion:  []transform.Version{transform.V1},
 				ResponseVersion: []transform.Version{transform.V1},
+				Persistent:      true,
 			},
 			errCapture: bytes.Buffer{},
 			outCapture: bytes.Buffer{},
@@ -237,3 +228,115 @@ func TestRunAndExit(t *testing.T) {
 		})
 	}
 }
+
+func TestRunAndExitV2(t *testing.T) {
+	metadata := transform.PluginMetadata{
+		Name:            "V2Plugin",
+		Version:         "v1.0.0",
+		RequestVersion:  []transform.Version{transform.V2, transform.V1},
+		ResponseVersion: []transform.Version{transform.V2, transform.V1},
+		GroupKinds:      []schema.GroupKind{{Group: "apps", Kind: "Deployment"}},
+	}
+	policy := unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "networking.k8s.io/v1",
+		"kind":       "NetworkPolicy",
+		"metadata":   map[string]interface{}{"name": "foo"},
+	}}
+	var gotRequest transform.PluginRequest
+	plugin := NewCustomPluginWithMetadata(metadata, func(request transform.PluginRequest) (transform.PluginResponse, error) {
+		gotRequest = request
+		return transform.PluginResponse{
+			Warnings:     []string{"deprecated field"},
+			Annotations:  map[string]string{"decision": "kept"},
+			NewResources: []unstructured.Unstructured{policy},
+		}, nil
+	})
+	exiter = func(i int) {
+		t.Fatalf("unexpected exit: %v", i)
+	}
+
+	errCapture, outCapture := bytes.Buffer{}, bytes.Buffer{}
+	stdErr, stdOut = &errCapture, &outCapture
+	reader = bytes.NewBufferString(bplugin.MetadataRequest)
+	RunAndExit(plugin)
+	gotMetadata := transform.PluginMetadata{}
+	if err := json.Unmarshal(outCapture.Bytes(), &gotMetadata); err != nil {
+		t.Fatal(err)
+	}
+	metadata.Persistent = true
+	if !reflect.DeepEqual(gotMetadata, metadata) {
+		t.Errorf("metadata got = %#v, want %#v", gotMetadata, metadata)
+	}
+
+	outCapture.Reset()
+	reader = bytes.NewBufferString(`{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "foo"}, "requestVersion": "v2",
+		"requestContext": [{"apiVersion": "v1", "kind": "Service", "metadata": {"name": "foo"}}]}`)
+	RunAndExit(plugin)
+	if gotRequest.Version != transform.V2 {
+		t.Errorf("request version got = %v, want %v", gotRequest.Version, transform.V2)
+	}
+	if _, ok := gotRequest.Object[transform.RequestVersionKey]; ok {
+		t.Errorf("request version was left in the object: %v", gotRequest.Object)
+	}
+	if _, ok := gotRequest.Object[transform.RequestContextKey]; ok {
+		t.Errorf("related objects were left in the object: %v", gotRequest.Object)
+	}
+	if _, ok := gotRequest.Resources.Get(schema.GroupVersionKind{Version: "v1", Kind: "Service"}, "", "foo"); !ok || gotRequest.Resources.Len() != 1 {
+		t.Errorf("related objects got = %v, want the foo Service", gotRequest.Resources.Objects())
+	}
+	resp := transform.PluginResponse{}
+	if err := json.Unmarshal(outCapture.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := transform.PluginResponse{
+		Version:      string(transform.V2),
+		Warnings:     []string{"deprecated field"},
+		Annotations:  map[string]string{"decision": "kept"},
+		NewResources: []unstructured.Unstructured{policy},
+	}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("response got = %#v, want %#v", resp, want)
+	}
+}
+
+func TestRunAndExitPersistent(t *testing.T) {
+	os.Setenv(transform.PersistentModeEnv, "true")
+	defer os.Unsetenv(transform.PersistentModeEnv)
+
+	plugin := NewCustomPlugin("PersistentPlugin", "v1", nil, func(request transform.PluginRequest) (transform.PluginResponse, error) {
+		if request.GetName() == "fail" {
+			return transform.PluginResponse{}, fmt.Errorf("invalid run")
+		}
+		return transform.PluginResponse{Version: "v1", IsWhiteOut: request.GetName() == "whiteout"}, nil
+	})
+	exiter = func(i int) {
+		t.Fatalf("unexpected exit: %v", i)
+	}
+
+	errCapture, outCapture := bytes.Buffer{}, bytes.Buffer{}
+	stdErr, stdOut = &errCapture, &outCapture
+	reader = bytes.NewBufferString(`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "whiteout"}}
+{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "fail"}}
+{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "keep"}}
+`)
+	RunAndExit(plugin)
+
+	want := []transform.StreamResponse{
+		{Response: &transform.PluginResponse{Version: "v1", IsWhiteOut: true}},
+		{Error: &errors.PluginError{Type: errors.PluginRunError, Message: "error when running plugin", ErrorMessage: "invalid run"}},
+		{Response: &transform.PluginResponse{Version: "v1"}},
+	}
+	lines := strings.Split(strings.TrimSpace(outCapture.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("got %v responses, want %v: %s", len(lines), len(want), outCapture.String())
+	}
+	for i, line := range lines {
+		got := transform.StreamResponse{}
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("response %v got = %s, want %#v", i, line, want[i])
+		}
+	}
+}
@@ -1,11 +1,18 @@
 package errors
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
 
 const (
 	PluginInvalidInputError = "PluginInvalidInputError"
 	PluginRunError          = "PluginRunError"
 	PluginInvalidIOError    = "PluginInvalidIOError"
+	PluginTimeoutError      = "PluginTimeoutError"
+	PluginVerificationError = "PluginVerificationError"
 )
 
 type PluginError struct {
@@ -42,3 +49,91 @@ func IsInvalidIOError(err error) bool {
 	}
 	return perr.Type == PluginInvalidIOError
 }
+
+func IsTimeoutError(err error) bool {
+	perr, ok := err.(*PluginError)
+	if !ok {
+		return false
+	}
+	return perr.Type == PluginTimeoutError
+}
+
+// VerificationError is returned instead of running a plugin binary that does
+// not match its expected digest or is not signed by a trusted key.
+type VerificationError struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+func (v *VerificationError) Error() string {
+	return fmt.Sprintf("verification of plugin %v failed: %v", v.Path, v.Reason)
+}
+
+func IsVerificationError(err error) bool {
+	var verr *VerificationError
+	return errors.As(err, &verr)
+}
+
+// PluginFailure records the error returned by a single plugin while the
+// Runner was processing an object.
+type PluginFailure struct {
+	PluginName    string `json:"pluginName"`
+	PluginVersion string `json:"pluginVersion"`
+	Type          string `json:"type"`
+	Err           error  `json:"-"`
+}
+
+// NewPluginFailure creates a PluginFailure for the plugin. The failure type is
+// taken from err when it is a PluginError, it is a PluginVerificationError for
+// a VerificationError and a PluginRunError otherwise.
+func NewPluginFailure(pluginName, pluginVersion string, err error) PluginFailure {
+	failureType := PluginRunError
+	var perr *PluginError
+	if errors.As(err, &perr) && perr.Type != "" {
+		failureType = perr.Type
+	} else if IsVerificationError(err) {
+		failureType = PluginVerificationError
+	}
+	return PluginFailure{
+		PluginName:    pluginName,
+		PluginVersion: pluginVersion,
+		Type:          failureType,
+		Err:           err,
+	}
+}
+
+func (p PluginFailure) Error() string {
+	return fmt.Sprintf("plugin %v (version %v) failed with %v: %v", p.PluginName, p.PluginVersion, p.Type, p.Err)
+}
+
+func (p PluginFailure) Unwrap() error {
+	return p.Err
+}
+
+// RunnerError aggregates every plugin failure for a single object.
+type RunnerError struct {
+	Failures []PluginFailure `json:"failures"`
+}
+
+func (r *RunnerError) Error() string {
+	msgs := []string{}
+	for _, f := range r.Failures {
+		msgs = append(msgs, f.Error())
+	}
+	return fmt.Sprintf("%v plugin(s) failed: [%v]", len(r.Failures), strings.Join(msgs, ", "))
+}
+
+// FailedPlugins returns the names of the plugins that failed, in the order
+// the failures were recorded.
+func (r *RunnerError) FailedPlugins() []string {
+	names := []string{}
+	for _, f := range r.Failures {
+		names = append(names, f.PluginName)
+	}
+	return names
+}
+
+func IsRunnerError(err error) bool {
+	_, ok := err.(*RunnerError)
+	return ok
+}
@@ -1,6 +1,9 @@
 package errors
 
-import "testing"
+import (
+	"fmt"
+	"testing"
+)
 
 func TestPluginError_Error(t *testing.T) {
 	tests := []struct {
@@ -26,3 +29,60 @@ func TestPluginError_Error(t *testing.T) {
 		})
 	}
 }
+
+func TestNewPluginFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantType string
+	}{
+		{
+			name:     "plugin error keeps its type",
+			err:      &PluginError{Type: PluginInvalidIOError, Message: "unable to read"},
+			wantType: PluginInvalidIOError,
+		},
+		{
+			name:     "wrapped plugin error keeps its type",
+			err:      fmt.Errorf("running plugin: %w", &PluginError{Type: PluginInvalidInputError}),
+			wantType: PluginInvalidInputError,
+		},
+		{
+			name:     "verification errors",
+			err:      fmt.Errorf("loading plugin: %w", &VerificationError{Path: "/plugin", Reason: "digest mismatch"}),
+			wantType: PluginVerificationError,
+		},
+		{
+			name:     "other errors are run errors",
+			err:      fmt.Errorf("exit status 1"),
+			wantType: PluginRunError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewPluginFailure("test", "v1", tt.err)
+			if got.Type != tt.wantType {
+				t.Errorf("Type = %v, want %v", got.Type, tt.wantType)
+			}
+			if got.PluginName != "test" || got.PluginVersion != "v1" || got.Err != tt.err {
+				t.Errorf("NewPluginFailure() = %#v", got)
+			}
+		})
+	}
+}
+
+func TestRunnerError_Error(t *testing.T) {
+	err := &RunnerError{Failures: []PluginFailure{
+		NewPluginFailure("first", "v1", fmt.Errorf("boom")),
+		NewPluginFailure("second", "v2", fmt.Errorf("bang")),
+	}}
+	want := "2 plugin(s) failed: [plugin first (version v1) failed with PluginRunError: boom, plugin second (version v2) failed with PluginRunError: bang]"
+	if got := err.Error(); got != want {
+		t.Errorf("Error() = %v, want %v", got, want)
+	}
+	if !IsRunnerError(err) {
+		t.Errorf("IsRunnerError() = false, want true")
+	}
+	if got := err.FailedPlugins(); len(got) != 2 || got[0] != "first" || got[1] != "second" {
+		t.Errorf("FailedPlugins() = %v", got)
+	}
+}
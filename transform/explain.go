@@ -0,0 +1,173 @@
+package transform
+
+import (
+	"encoding/json"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/konveyor/crane-lib/apply"
+	"github.com/konveyor/crane-lib/transform/internal/textdiff"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// Trace explains how the Runner built its response for an object. It is set
+// in RunnerResponse.Trace when Runner.Explain is true.
+type Trace struct {
+	// Plugins are in the order they were given to the Runner.
+	Plugins []PluginTrace `json:"plugins"`
+	// Operations are the operations proposed by the plugins, in plugin
+	// order, along with whether they were kept in the transform file.
+	Operations []OperationTrace `json:"operations"`
+	// After is the object with the transform file and the merge patches
+	// applied, it is nil when the object is whited out or nothing was built.
+	After *unstructured.Unstructured `json:"after,omitempty"`
+	// Diff is the unified diff from the YAML of the object to the YAML of
+	// After, it is empty when nothing changed.
+	Diff string `json:"diff,omitempty"`
+	// Error is set when the transform file or the merge patches could not
+	// be applied to the object.
+	Error string `json:"error,omitempty"`
+}
+
+// PluginTrace is what a single plugin did with the object.
+type PluginTrace struct {
+	PluginName string `json:"pluginName"`
+	// Called is false when the plugin does not handle the kind of the object
+	// or was not called after a failure with ErrorPolicyFailFast.
+	Called   bool          `json:"called"`
+	Version  Version       `json:"version,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	WhiteOut bool          `json:"whiteOut,omitempty"`
+	// Operations are the operations proposed by the plugin.
+	Operations   jsonpatch.Patch `json:"operations,omitempty"`
+	MergePatches []MergePatch    `json:"mergePatches,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+// OperationTrace is an operation proposed by a plugin. The Reason is set when
+// the operation is not Kept.
+type OperationTrace struct {
+	PluginOperation
+	Kept bool `json:"kept"`
+}
+
+const (
+	// ReasonDuplicate is used in a Trace when an equal operation from
+	// another plugin is kept.
+	ReasonDuplicate IgnoredReason = "Duplicate"
+	// ReasonWhiteOut is used in a Trace for every operation when the object
+	// is whited out.
+	ReasonWhiteOut IgnoredReason = "WhiteOut"
+	// ReasonPluginFailure is used in a Trace for every operation when a
+	// plugin failed and the ErrorPolicy discards the result.
+	ReasonPluginFailure IgnoredReason = "PluginFailure"
+)
+
+// newTrace records the result of every plugin.
+func newTrace(plugins []Plugin, results []pluginResult) *Trace {
+	trace := &Trace{Plugins: make([]PluginTrace, len(plugins)), Operations: []OperationTrace{}}
+	for i, plugin := range plugins {
+		trace.Plugins[i] = PluginTrace{
+			PluginName: plugin.Metadata().Name,
+			Called:     results[i].ran,
+			Version:    results[i].version,
+			Duration:   results[i].duration,
+			WhiteOut:   results[i].response.IsWhiteOut,
+		}
+		if results[i].err != nil {
+			trace.Plugins[i].Error = results[i].err.Error()
+		}
+	}
+	return trace
+}
+
+// dropAll records every operation as dropped for the reason.
+func (t *Trace) dropAll(operations []PluginOperation, reason IgnoredReason) {
+	if t == nil {
+		return
+	}
+	for _, o := range operations {
+		o.Reason = reason
+		t.Operations = append(t.Operations, OperationTrace{PluginOperation: o})
+	}
+}
+
+// decide records whether each operation was kept, matching it against the
+// ones ignored and found to be duplicates by sanitizePatches. Operations that
+// are equal and come from the same plugin can not be told apart, any of them
+// may be reported as the one kept.
+func (t *Trace) decide(operations []PluginOperation, ignored, duplicates []PluginOperation) {
+	if t == nil {
+		return
+	}
+	dropped := append(append([]PluginOperation{}, ignored...), duplicates...)
+	for _, o := range operations {
+		trace := OperationTrace{PluginOperation: o, Kept: true}
+		for i, d := range dropped {
+			if EqualPluginOperation(o, d) {
+				trace = OperationTrace{PluginOperation: d}
+				dropped = append(dropped[:i], dropped[i+1:]...)
+				break
+			}
+		}
+		t.Operations = append(t.Operations, trace)
+	}
+}
+
+// applyTransform sets After and Diff by applying the transform file followed
+// by the merge patches.
+func (t *Trace) applyTransform(object unstructured.Unstructured, transformFile []byte, mergePatches []MergePatch) {
+	if t == nil {
+		return
+	}
+	patched, err := apply.Applier{}.Apply(object, transformFile)
+	if err != nil {
+		t.Error = err.Error()
+		return
+	}
+	after := unstructured.Unstructured{}
+	if err := after.UnmarshalJSON(patched); err != nil {
+		t.Error = err.Error()
+		return
+	}
+	for _, mergePatch := range mergePatches {
+		patched, err = apply.Applier{}.ApplyPatch(after, mergePatch.Patch, mergePatch.Type)
+		if err != nil {
+			t.Error = err.Error()
+			return
+		}
+		after = unstructured.Unstructured{}
+		if err := after.UnmarshalJSON(patched); err != nil {
+			t.Error = err.Error()
+			return
+		}
+	}
+	t.After = &after
+	t.Diff, err = objectDiff(object, after)
+	if err != nil {
+		t.Error = err.Error()
+	}
+}
+
+// objectDiff returns the unified diff between the YAML of the objects.
+func objectDiff(before, after unstructured.Unstructured) (string, error) {
+	beforeYAML, err := toYAML(before)
+	if err != nil {
+		return "", err
+	}
+	afterYAML, err := toYAML(after)
+	if err != nil {
+		return "", err
+	}
+	return textdiff.Unified(beforeYAML, afterYAML), nil
+}
+
+func toYAML(object unstructured.Unstructured) (string, error) {
+	data, err := json.Marshal(object.Object)
+	if err != nil {
+		return "", err
+	}
+	data, err = yaml.JSONToYAML(data)
+	return string(data), err
+}
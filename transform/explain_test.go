@@ -0,0 +1,129 @@
+package transform
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestRunnerExplain(t *testing.T) {
+	object := unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"name": "web"},
+		"spec":       map[string]interface{}{"type": "ClusterIP"},
+	}}
+	plugins := []Plugin{
+		patchPlugin("first", `[{"op": "add", "path": "/spec/a", "value": "1"}, {"op": "add", "path": "/spec/b", "value": "x"}]`, 0),
+		patchPlugin("second", `[{"op": "add", "path": "/spec/a", "value": "2"}, {"op": "add", "path": "/spec/b", "value": "x"}]`, 0),
+		mergePatchPlugin("routes", V2, PluginResponse{}),
+	}
+	// routes is only called for routes
+	plugins[2].(fakePlugin).metadata.GroupKinds = []schema.GroupKind{{Group: "route.openshift.io", Kind: "Route"}}
+
+	runner := Runner{Log: logrus.New(), PluginPriorities: map[string]int{"first": 0, "second": 1}}
+	response, err := runner.Run(object, plugins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Trace != nil {
+		t.Errorf("expected no trace without Explain")
+	}
+
+	runner.Explain = true
+	response, err = runner.Run(object, plugins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace := response.Trace
+	if trace == nil {
+		t.Fatal("expected a trace")
+	}
+	called := []bool{}
+	for _, p := range trace.Plugins {
+		called = append(called, p.Called)
+	}
+	if !reflect.DeepEqual(called, []bool{true, true, false}) || len(trace.Plugins[0].Operations) != 2 {
+		t.Errorf("unexpected plugin traces: %+v", trace.Plugins)
+	}
+
+	type decision struct {
+		plugin string
+		path   string
+		kept   bool
+		reason IgnoredReason
+	}
+	decisions := []decision{}
+	for _, o := range trace.Operations {
+		path, _ := o.Operation.Path()
+		decisions = append(decisions, decision{plugin: o.PluginName, path: path, kept: o.Kept, reason: o.Reason})
+	}
+	expected := []decision{
+		{plugin: "first", path: "/spec/a", kept: true},
+		{plugin: "first", path: "/spec/b", kept: true},
+		{plugin: "second", path: "/spec/a", reason: ReasonPathConflict},
+		{plugin: "second", path: "/spec/b", reason: ReasonDuplicate},
+	}
+	if !reflect.DeepEqual(decisions, expected) {
+		t.Errorf("operations got = %+v, want %+v", decisions, expected)
+	}
+
+	if trace.After == nil || trace.Error != "" {
+		t.Fatalf("expected the patched object, got error %v", trace.Error)
+	}
+	if a, _, _ := unstructured.NestedString(trace.After.Object, "spec", "a"); a != "1" {
+		t.Errorf("expected spec.a to be 1 after the transform, got %v", a)
+	}
+	if !strings.Contains(trace.Diff, "+  a: \"1\"\n") || !strings.Contains(trace.Diff, "+  b: x\n") {
+		t.Errorf("unexpected diff:\n%v", trace.Diff)
+	}
+
+	// Every operation is dropped when the object is whited out
+	response, err = runner.Run(object, append(plugins, whiteOutPlugin("whiteout")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace = response.Trace
+	if !trace.Plugins[3].WhiteOut || trace.After != nil || len(trace.Operations) != 4 {
+		t.Fatalf("unexpected trace for a whiteout: %+v", trace)
+	}
+	for _, o := range trace.Operations {
+		if o.Kept || o.Reason != ReasonWhiteOut {
+			t.Errorf("expected operations to be dropped by the whiteout, got %+v", o)
+		}
+	}
+}
+
+func TestRunnerExplainMergePatches(t *testing.T) {
+	object := unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]interface{}{"name": "web"},
+		"spec":       map[string]interface{}{"type": "ClusterIP"},
+	}}
+	plugins := []Plugin{
+		patchPlugin("first", `[{"op": "add", "path": "/spec/a", "value": "1"}]`, 0),
+		mergePatchPlugin("second", V2, PluginResponse{MergePatch: []byte(`{"spec": {"b": "2"}}`)}),
+	}
+
+	runner := Runner{Log: logrus.New(), Explain: true}
+	response, err := runner.Run(object, plugins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trace := response.Trace
+	if len(trace.Plugins[1].MergePatches) != 1 || trace.Plugins[1].MergePatches[0].PluginName != "second" {
+		t.Errorf("expected the merge patch of the plugin, got %+v", trace.Plugins[1])
+	}
+	if trace.After == nil || trace.Error != "" {
+		t.Fatalf("expected the patched object, got error %v", trace.Error)
+	}
+	spec, _, _ := unstructured.NestedStringMap(trace.After.Object, "spec")
+	if !reflect.DeepEqual(spec, map[string]string{"type": "ClusterIP", "a": "1", "b": "2"}) {
+		t.Errorf("expected the transform file and the merge patch to be applied, got %v", spec)
+	}
+}
@@ -0,0 +1,242 @@
+package transform
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// OptionalFieldType is the type of the value of an optional field. Lists and
+// maps are separated by commas and map keys from values by an equal sign.
+// Separators can be escaped with a backslash or be part of a double quoted
+// string, see FormatStringList and FormatMap.
+type OptionalFieldType string
+
+const (
+	// FieldTypeString is any string, it is the type of fields without one.
+	FieldTypeString OptionalFieldType = "string"
+	// FieldTypeBool is a value accepted by strconv.ParseBool.
+	FieldTypeBool OptionalFieldType = "bool"
+	// FieldTypeInt is a value accepted by strconv.Atoi.
+	FieldTypeInt OptionalFieldType = "int"
+	// FieldTypeStringList is a list of strings, such as a,b,"c,d".
+	FieldTypeStringList OptionalFieldType = "stringList"
+	// FieldTypeMap is a map of strings, such as a=b,"c=d"=e.
+	FieldTypeMap OptionalFieldType = "map"
+	// FieldTypeGroupKindList is a list of GroupKinds in the Kind.group
+	// format, such as Deployment.apps,Service.
+	FieldTypeGroupKindList OptionalFieldType = "groupKindList"
+	// FieldTypeDuration is a value accepted by time.ParseDuration.
+	FieldTypeDuration OptionalFieldType = "duration"
+)
+
+// Validate returns an error when value is not valid for the type of the field.
+func (f OptionalFields) Validate(value string) error {
+	var err error
+	switch f.Type {
+	case "", FieldTypeString:
+	case FieldTypeBool:
+		_, err = strconv.ParseBool(value)
+	case FieldTypeInt:
+		_, err = strconv.Atoi(value)
+	case FieldTypeStringList:
+		_, err = ParseStringList(value)
+	case FieldTypeMap:
+		_, err = ParseMap(value)
+	case FieldTypeGroupKindList:
+		_, err = ParseGroupKindList(value)
+	case FieldTypeDuration:
+		_, err = time.ParseDuration(value)
+	default:
+		return fmt.Errorf("field %v has an unknown type %v", f.FlagName, f.Type)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %v value %q for field %v: %v", f.Type, value, f.FlagName, err)
+	}
+	return nil
+}
+
+// ValidateOptionalFields checks the value of every field in flags and returns
+// the flags with the defaults of the missing fields added. An error is
+// returned for invalid values, invalid defaults and missing required fields.
+func ValidateOptionalFields(fields []OptionalFields, flags map[string]string) (map[string]string, error) {
+	// flags is only copied when a default is added
+	withDefaults, copied := flags, false
+	errs := []string{}
+	for _, field := range fields {
+		value, ok := flags[field.FlagName]
+		if !ok && field.Default != "" {
+			if !copied {
+				withDefaults, copied = copyFlags(flags), true
+			}
+			value, ok = field.Default, true
+			withDefaults[field.FlagName] = value
+		}
+		if !ok {
+			if field.Required {
+				errs = append(errs, fmt.Sprintf("field %v is required", field.FlagName))
+			}
+			continue
+		}
+		if err := field.Validate(value); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%v", strings.Join(errs, ", "))
+	}
+	return withDefaults, nil
+}
+
+func copyFlags(flags map[string]string) map[string]string {
+	c := make(map[string]string, len(flags))
+	for key, value := range flags {
+		c[key] = value
+	}
+	return c
+}
+
+// ParseStringList parses a FieldTypeStringList value, an empty value is an
+// empty list.
+func ParseStringList(value string) ([]string, error) {
+	if value == "" {
+		return []string{}, nil
+	}
+	parts, err := splitQuoted(value, ',')
+	if err != nil {
+		return nil, err
+	}
+	for i := range parts {
+		parts[i] = unquote(parts[i])
+	}
+	return parts, nil
+}
+
+// ParseMap parses a FieldTypeMap value. Keys without a value are mapped to
+// an empty string.
+func ParseMap(value string) (map[string]string, error) {
+	m := map[string]string{}
+	if value == "" {
+		return m, nil
+	}
+	entries, err := splitQuoted(value, ',')
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		// Only the first separator splits the key from the value
+		parts, err := splitQuoted(entry, '=')
+		if err != nil {
+			return nil, err
+		}
+		key := unquote(parts[0])
+		if key == "" {
+			return nil, fmt.Errorf("empty key in %q", entry)
+		}
+		m[key] = unquote(strings.Join(parts[1:], "="))
+	}
+	return m, nil
+}
+
+// ParseGroupKindList parses a FieldTypeGroupKindList value.
+func ParseGroupKindList(value string) ([]schema.GroupKind, error) {
+	list, err := ParseStringList(value)
+	if err != nil {
+		return nil, err
+	}
+	gks := []schema.GroupKind{}
+	for _, item := range list {
+		gk := schema.ParseGroupKind(item)
+		if gk.Kind == "" {
+			return nil, fmt.Errorf("%q is not a GroupKind", item)
+		}
+		gks = append(gks, gk)
+	}
+	return gks, nil
+}
+
+// FormatStringList returns the FieldTypeStringList value for the list,
+// quoting the items that need it.
+func FormatStringList(list []string) string {
+	items := make([]string, 0, len(list))
+	for _, item := range list {
+		items = append(items, quote(item))
+	}
+	return strings.Join(items, ",")
+}
+
+// FormatMap returns the FieldTypeMap value for the map, sorted by key and
+// quoting the keys and values that need it.
+func FormatMap(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := make([]string, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, quote(key)+"="+quote(m[key]))
+	}
+	return strings.Join(entries, ",")
+}
+
+// splitQuoted splits value on sep, ignoring the separators that are escaped
+// or within double quotes. The parts keep their quotes and escapes so they can
+// be split again.
+func splitQuoted(value string, sep rune) ([]string, error) {
+	parts := []string{}
+	start, quoted, escaped := 0, false, false
+	for i, c := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, value[start:i])
+			start = i + len(string(sep))
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in %q", value)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash in %q", value)
+	}
+	return append(parts, value[start:]), nil
+}
+
+// unquote removes the quotes and escapes of a part returned by splitQuoted.
+func unquote(part string) string {
+	b := strings.Builder{}
+	escaped := false
+	for _, c := range part {
+		switch {
+		case escaped:
+			b.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '"':
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// quote returns value in double quotes when it holds a separator, a quote or
+// a backslash.
+func quote(value string) string {
+	if !strings.ContainsAny(value, `,="\`) {
+		return value
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+	return `"` + escaped + `"`
+}
@@ -0,0 +1,284 @@
+package transform
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/konveyor/crane-lib/transform/errors"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestParseStringList(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Value     string
+		Expected  []string
+		ShouldErr bool
+	}{
+		{Name: "Empty", Value: "", Expected: []string{}},
+		{Name: "Simple", Value: "a,b,c", Expected: []string{"a", "b", "c"}},
+		{Name: "EmptyItem", Value: "a,,b", Expected: []string{"a", "", "b"}},
+		{Name: "Quoted", Value: `a,"b,c",d`, Expected: []string{"a", "b,c", "d"}},
+		{Name: "Escaped", Value: `a\,b,c`, Expected: []string{"a,b", "c"}},
+		{Name: "EscapedQuote", Value: `"a\"b",c\\`, Expected: []string{`a"b`, `c\`}},
+		{Name: "UnterminatedQuote", Value: `a,"b`, ShouldErr: true},
+		{Name: "TrailingBackslash", Value: `a,b\`, ShouldErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			got, err := ParseStringList(c.Value)
+			if (err != nil) != c.ShouldErr {
+				t.Fatalf("ParseStringList() error = %v, shouldErr %v", err, c.ShouldErr)
+			}
+			if !c.ShouldErr && !reflect.DeepEqual(got, c.Expected) {
+				t.Errorf("ParseStringList() got = %q, want %q", got, c.Expected)
+			}
+		})
+	}
+}
+
+func TestParseMap(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Value     string
+		Expected  map[string]string
+		ShouldErr bool
+	}{
+		{Name: "Empty", Value: "", Expected: map[string]string{}},
+		{Name: "Simple", Value: "a=1,b=2", Expected: map[string]string{"a": "1", "b": "2"}},
+		{Name: "NoValue", Value: "a,b=", Expected: map[string]string{"a": "", "b": ""}},
+		{Name: "EqualInValue", Value: "a=b=c", Expected: map[string]string{"a": "b=c"}},
+		{Name: "QuotedKey", Value: `"a=b"=c`, Expected: map[string]string{"a=b": "c"}},
+		{Name: "QuotedValue", Value: `a="1,2",b=3`, Expected: map[string]string{"a": "1,2", "b": "3"}},
+		{Name: "Escaped", Value: `a\=b=1\,2`, Expected: map[string]string{"a=b": "1,2"}},
+		{Name: "EmptyKey", Value: "=a", ShouldErr: true},
+		{Name: "UnterminatedQuote", Value: `a="b`, ShouldErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			got, err := ParseMap(c.Value)
+			if (err != nil) != c.ShouldErr {
+				t.Fatalf("ParseMap() error = %v, shouldErr %v", err, c.ShouldErr)
+			}
+			if !c.ShouldErr && !reflect.DeepEqual(got, c.Expected) {
+				t.Errorf("ParseMap() got = %q, want %q", got, c.Expected)
+			}
+		})
+	}
+}
+
+func TestParseGroupKindList(t *testing.T) {
+	got, err := ParseGroupKindList("Deployment.apps,Service,Route.route.openshift.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []schema.GroupKind{
+		{Group: "apps", Kind: "Deployment"},
+		{Kind: "Service"},
+		{Group: "route.openshift.io", Kind: "Route"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("ParseGroupKindList() got = %v, want %v", got, expected)
+	}
+	if _, err := ParseGroupKindList("Service,.apps"); err == nil {
+		t.Errorf("expected an error for a GroupKind without a kind")
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	list := []string{"a", "b,c", `d"e`, `f\g`, "h=i"}
+	got, err := ParseStringList(FormatStringList(list))
+	if err != nil || !reflect.DeepEqual(got, list) {
+		t.Errorf("list round trip got = %q (%v), want %q", got, err, list)
+	}
+
+	m := map[string]string{"a": "1", "b=c": "2,3", `d"e`: `f\g`, "h": ""}
+	gotMap, err := ParseMap(FormatMap(m))
+	if err != nil || !reflect.DeepEqual(gotMap, m) {
+		t.Errorf("map round trip got = %q (%v), want %q", gotMap, err, m)
+	}
+	if formatted := FormatMap(map[string]string{"b": "2", "a": "1"}); formatted != "a=1,b=2" {
+		t.Errorf("FormatMap() got = %v, want a=1,b=2", formatted)
+	}
+}
+
+func TestParseOptionalFieldLegacyValues(t *testing.T) {
+	// Values of fields without a type are split as is, without unquoting
+	list := ParseOptionalFieldSliceVal(`C:\plugins\bin,^a\.b$,"c"`)
+	if expected := []string{`C:\plugins\bin`, `^a\.b$`, `"c"`}; !reflect.DeepEqual(list, expected) {
+		t.Errorf("ParseOptionalFieldSliceVal() got = %q, want %q", list, expected)
+	}
+	m := ParseOptionalFieldMapVal(`path=C:\plugins\bin,json={"a":"b"}`)
+	if expected := map[string]string{"path": `C:\plugins\bin`, "json": `{"a":"b"}`}; !reflect.DeepEqual(m, expected) {
+		t.Errorf("ParseOptionalFieldMapVal() got = %q, want %q", m, expected)
+	}
+}
+
+func TestValidateOptionalFields(t *testing.T) {
+	fields := []OptionalFields{
+		{FlagName: "name"},
+		{FlagName: "enabled", Type: FieldTypeBool, Default: "true"},
+		{FlagName: "count", Type: FieldTypeInt},
+		{FlagName: "labels", Type: FieldTypeMap},
+		{FlagName: "kinds", Type: FieldTypeGroupKindList},
+		{FlagName: "timeout", Type: FieldTypeDuration},
+		{FlagName: "namespace", Required: true},
+	}
+	cases := []struct {
+		Name      string
+		Flags     map[string]string
+		Expected  map[string]string
+		ShouldErr bool
+	}{
+		{
+			Name:     "Defaults",
+			Flags:    map[string]string{"namespace": "test"},
+			Expected: map[string]string{"namespace": "test", "enabled": "true"},
+		},
+		{
+			Name: "Valid",
+			Flags: map[string]string{
+				"namespace": "test",
+				"enabled":   "false",
+				"count":     "3",
+				"labels":    `a=1,b="2,3"`,
+				"kinds":     "Deployment.apps,Service",
+				"timeout":   "30s",
+				"unknown":   "anything",
+			},
+			Expected: map[string]string{
+				"namespace": "test",
+				"enabled":   "false",
+				"count":     "3",
+				"labels":    `a=1,b="2,3"`,
+				"kinds":     "Deployment.apps,Service",
+				"timeout":   "30s",
+				"unknown":   "anything",
+			},
+		},
+		{
+			Name:      "MissingRequired",
+			Flags:     map[string]string{},
+			ShouldErr: true,
+		},
+		{
+			Name:      "InvalidInt",
+			Flags:     map[string]string{"namespace": "test", "count": "three"},
+			ShouldErr: true,
+		},
+		{
+			Name:      "InvalidDuration",
+			Flags:     map[string]string{"namespace": "test", "timeout": "30"},
+			ShouldErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			flags := map[string]string{}
+			for key, value := range c.Flags {
+				flags[key] = value
+			}
+			got, err := ValidateOptionalFields(fields, flags)
+			if (err != nil) != c.ShouldErr {
+				t.Fatalf("ValidateOptionalFields() error = %v, shouldErr %v", err, c.ShouldErr)
+			}
+			if !c.ShouldErr && !reflect.DeepEqual(got, c.Expected) {
+				t.Errorf("ValidateOptionalFields() got = %v, want %v", got, c.Expected)
+			}
+			if !reflect.DeepEqual(flags, c.Flags) {
+				t.Errorf("ValidateOptionalFields() changed the flags to %v", flags)
+			}
+		})
+	}
+
+	if _, err := ValidateOptionalFields([]OptionalFields{{FlagName: "bad", Type: "float"}}, map[string]string{"bad": "1"}); err == nil {
+		t.Errorf("expected an error for an unknown type")
+	}
+}
+
+func TestRunnerOptionalFields(t *testing.T) {
+	var gotExtras map[string]string
+	called := false
+	plugin := fakePlugin{
+		Func: func(request PluginRequest) (PluginResponse, error) {
+			called = true
+			gotExtras = request.Extras
+			return PluginResponse{}, nil
+		},
+		metadata: &PluginMetadata{
+			Name:            "typed",
+			RequestVersion:  []Version{V1},
+			ResponseVersion: []Version{V1},
+			OptionalFields: []OptionalFields{
+				{FlagName: "count", Type: FieldTypeInt, Required: true},
+				{FlagName: "enabled", Type: FieldTypeBool, Default: "true"},
+			},
+		},
+	}
+
+	runner := Runner{Log: logrus.New(), OptionalFlags: map[string]string{"count": "3"}}
+	if _, err := runner.Run(unstructured.Unstructured{}, []Plugin{plugin}); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"count": "3", "enabled": "true"}
+	if !reflect.DeepEqual(gotExtras, expected) {
+		t.Errorf("extras got = %v, want %v", gotExtras, expected)
+	}
+
+	called = false
+	runner.OptionalFlags = map[string]string{"count": "three"}
+	if err := runner.ValidateOptionalFlags([]Plugin{plugin}); !errors.IsRunnerError(err) {
+		t.Errorf("expected a RunnerError, got: %v", err)
+	}
+	_, err := runner.Run(unstructured.Unstructured{}, []Plugin{plugin})
+	runnerErr, ok := err.(*errors.RunnerError)
+	if !ok || !reflect.DeepEqual(runnerErr.FailedPlugins(), []string{"typed"}) || runnerErr.Failures[0].Type != errors.PluginInvalidInputError {
+		t.Errorf("expected the typed plugin to fail with invalid input, got: %v", err)
+	}
+	if called {
+		t.Errorf("expected no plugin to run with invalid flags")
+	}
+}
+
+func TestRunnerPluginFlags(t *testing.T) {
+	gotExtras := map[string]map[string]string{}
+	newPlugin := func(name string, fields ...string) Plugin {
+		metadata := &PluginMetadata{Name: name, RequestVersion: []Version{V1}, ResponseVersion: []Version{V1}}
+		for _, field := range fields {
+			metadata.OptionalFields = append(metadata.OptionalFields, OptionalFields{FlagName: field})
+		}
+		return fakePlugin{
+			Func: func(request PluginRequest) (PluginResponse, error) {
+				gotExtras[name] = request.Extras
+				return PluginResponse{}, nil
+			},
+			metadata: metadata,
+		}
+	}
+	plugins := []Plugin{newPlugin("a", "registry", "token"), newPlugin("b", "registry")}
+
+	runner := Runner{
+		Log:           logrus.New(),
+		OptionalFlags: map[string]string{"registry": "shared", "token": "secret", "other": "value"},
+		PluginFlags:   map[string]map[string]string{"b": {"registry": "b-only"}},
+	}
+	if _, err := runner.Run(unstructured.Unstructured{}, plugins); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]map[string]string{
+		"a": {"registry": "shared", "token": "secret"},
+		"b": {"registry": "b-only"},
+	}
+	if !reflect.DeepEqual(gotExtras, expected) {
+		t.Errorf("extras got = %v, want %v", gotExtras, expected)
+	}
+
+	runner.PluginFlags = map[string]map[string]string{"b": {"token": "secret"}}
+	err := runner.ValidateOptionalFlags(plugins)
+	runnerErr, ok := err.(*errors.RunnerError)
+	if !ok || !reflect.DeepEqual(runnerErr.FailedPlugins(), []string{"b"}) {
+		t.Errorf("expected plugin b to fail with an undeclared flag, got: %v", err)
+	}
+}
@@ -67,5 +67,3 @@ func EqualOperation(operation1, operation2 jsonpatch.Operation) bool {
 	}
 	return false
 }
-
-//TODO: diff function that makes it easy to determine the diffs between two things
@@ -0,0 +1,38 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// JoinPath builds a JSON Pointer from unescaped reference tokens, it is the
+// reverse of SplitPath.
+func JoinPath(tokens []string) string {
+	if len(tokens) == 0 {
+		return ""
+	}
+	escaped := make([]string, len(tokens))
+	for i, token := range tokens {
+		escaped[i] = strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+// NewOperation creates an operation, the value is ignored for removals.
+func NewOperation(op, path string, value interface{}) (jsonpatch.Operation, error) {
+	o := map[string]interface{}{"op": op, "path": path}
+	if op != "remove" {
+		o["value"] = value
+	}
+	b, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	operation := jsonpatch.Operation{}
+	if err := json.Unmarshal(b, &operation); err != nil {
+		return nil, err
+	}
+	return operation, nil
+}
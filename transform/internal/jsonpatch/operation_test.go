@@ -0,0 +1,49 @@
+package jsonpatch_test
+
+import (
+	"testing"
+
+	internaljsonpatch "github.com/konveyor/crane-lib/transform/internal/jsonpatch"
+)
+
+func TestJoinPath(t *testing.T) {
+	cases := []struct {
+		Tokens   []string
+		Expected string
+	}{
+		{Tokens: []string{}, Expected: ""},
+		{Tokens: []string{"spec", "containers", "0"}, Expected: "/spec/containers/0"},
+		{Tokens: []string{"annotations", "app.io/name~x"}, Expected: "/annotations/app.io~1name~0x"},
+	}
+	for _, c := range cases {
+		path := internaljsonpatch.JoinPath(c.Tokens)
+		if path != c.Expected {
+			t.Errorf("JoinPath(%v) = %v, expected %v", c.Tokens, path, c.Expected)
+		}
+		if tokens := internaljsonpatch.SplitPath(path); len(c.Tokens) > 0 && internaljsonpatch.JoinPath(tokens) != path {
+			t.Errorf("SplitPath(%v) = %v does not round trip", path, tokens)
+		}
+	}
+}
+
+func TestNewOperation(t *testing.T) {
+	operation, err := internaljsonpatch.NewOperation("add", "/metadata/labels/app", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := operation.ValueInterface()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path, _ := operation.Path(); operation.Kind() != "add" || path != "/metadata/labels/app" || value != "a" {
+		t.Errorf("unexpected operation: %v", operation)
+	}
+
+	operation, err = internaljsonpatch.NewOperation("remove", "/status", "ignored")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := operation.ValueInterface(); err == nil {
+		t.Errorf("expected no value for a removal, got operation %v", operation)
+	}
+}
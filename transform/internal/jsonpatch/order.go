@@ -0,0 +1,221 @@
+package jsonpatch
+
+import (
+	"strconv"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// SplitPath breaks a JSON Pointer into its unescaped reference tokens.
+func SplitPath(path string) []string {
+	if path == "" {
+		return []string{}
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	for i, part := range parts {
+		parts[i] = strings.Replace(strings.Replace(part, "~1", "/", -1), "~0", "~", -1)
+	}
+	return parts
+}
+
+// IsParentPath returns true when the JSON Pointer parent refers to an
+// ancestor of the location referred to by child.
+func IsParentPath(parent, child []string) bool {
+	if len(parent) >= len(child) {
+		return false
+	}
+	for i := range parent {
+		if parent[i] != child[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge combines the patches of several plugins into one patch that is
+// reproducible and can be applied in order. The operations of each patch keep
+// the order they were sent in. Across patches:
+//   - an operation creating a parent path, or the source of a copy or move,
+//     comes before the operations using it.
+//   - operations on children of a path, or copying or moving from it, come
+//     before the operation removing or replacing that path.
+//   - operations on array elements come before the removal or insertion of
+//     an element that would shift their index.
+//   - everything else is ordered by path, with array indexes compared
+//     numerically.
+func Merge(patches []jsonpatch.Patch) jsonpatch.Patch {
+	ops := []mergeOperation{}
+	for i, patch := range patches {
+		for _, op := range patch {
+			ops = append(ops, newMergeOperation(op, i, len(ops)))
+		}
+	}
+
+	// before[i] lists the operations that must come after operation i, and
+	// blocked[i] counts the operations that must come before it.
+	before := make([][]int, len(ops))
+	blocked := make([]int, len(ops))
+	for i := range ops {
+		for j := range ops {
+			if i == j {
+				continue
+			}
+			sequence := ops[i].patch == ops[j].patch && ops[i].index+1 == ops[j].index
+			if sequence || (ops[i].patch != ops[j].patch && mustPrecede(ops[i], ops[j])) {
+				before[i] = append(before[i], j)
+				blocked[j]++
+			}
+		}
+	}
+
+	merged := make(jsonpatch.Patch, 0, len(ops))
+	done := make([]bool, len(ops))
+	for len(merged) < len(ops) {
+		// When the order of a patch contradicts the order of another one,
+		// every remaining operation is blocked, the next operation of each
+		// patch is then a candidate.
+		cycle := true
+		for i := range ops {
+			if !done[i] && blocked[i] == 0 {
+				cycle = false
+				break
+			}
+		}
+		next := -1
+		for i := range ops {
+			if done[i] || (!cycle && blocked[i] > 0) {
+				continue
+			}
+			if cycle && i > 0 && ops[i-1].patch == ops[i].patch && !done[i-1] {
+				continue
+			}
+			if next == -1 || lessPath(ops[i], ops[next]) {
+				next = i
+			}
+		}
+		done[next] = true
+		merged = append(merged, ops[next].operation)
+		for _, j := range before[next] {
+			blocked[j]--
+		}
+	}
+	return merged
+}
+
+type mergeOperation struct {
+	operation jsonpatch.Operation
+	kind      string
+	path      []string
+	// from is the source of a copy or move, nil for other kinds.
+	from  []string
+	patch int
+	index int
+}
+
+func newMergeOperation(op jsonpatch.Operation, patch, index int) mergeOperation {
+	path, _ := op.Path()
+	o := mergeOperation{operation: op, kind: op.Kind(), path: SplitPath(path), patch: patch, index: index}
+	if o.kind == "copy" || o.kind == "move" {
+		from, _ := op.From()
+		o.from = SplitPath(from)
+	}
+	return o
+}
+
+// creates returns the path an operation creates a value at.
+func (o mergeOperation) creates() []string {
+	switch o.kind {
+	case "add", "copy", "move":
+		return o.path
+	}
+	return nil
+}
+
+// destroys returns the path an operation discards the value of.
+func (o mergeOperation) destroys() []string {
+	switch o.kind {
+	case "remove", "replace":
+		return o.path
+	case "move":
+		return o.from
+	}
+	return nil
+}
+
+// mustPrecede returns true when a has to be applied before b.
+func mustPrecede(a, b mergeOperation) bool {
+	if created := a.creates(); created != nil {
+		if IsParentPath(created, b.path) || (b.from != nil && isParentOrEqual(created, b.from)) {
+			return true
+		}
+	}
+	if destroyed := b.destroys(); destroyed != nil {
+		if IsParentPath(destroyed, a.path) || (a.from != nil && isParentOrEqual(destroyed, a.from)) {
+			return true
+		}
+	}
+	return shifts(b, a)
+}
+
+// shifts returns true when the operation removes or inserts an array element
+// before the element the other operation refers to.
+func shifts(o, other mergeOperation) bool {
+	if o.kind != "remove" && o.kind != "add" {
+		return false
+	}
+	last := len(o.path) - 1
+	if last < 0 || len(other.path) <= last || !IsParentPath(o.path[:last], other.path) {
+		return false
+	}
+	index, err := strconv.Atoi(o.path[last])
+	if err != nil {
+		return false
+	}
+	otherIndex, err := strconv.Atoi(other.path[last])
+	if err != nil {
+		return false
+	}
+	if o.kind == "remove" {
+		return otherIndex > index
+	}
+	return otherIndex >= index
+}
+
+func isParentOrEqual(parent, child []string) bool {
+	if len(parent) > len(child) {
+		return false
+	}
+	for i := range parent {
+		if parent[i] != child[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// lessPath orders operations by path, array indexes are compared numerically
+// and come before other tokens, parents come before their children.
+func lessPath(o1, o2 mergeOperation) bool {
+	for i := 0; i < len(o1.path) && i < len(o2.path); i++ {
+		if o1.path[i] == o2.path[i] {
+			continue
+		}
+		index1, err1 := strconv.Atoi(o1.path[i])
+		index2, err2 := strconv.Atoi(o2.path[i])
+		switch {
+		case err1 == nil && err2 == nil && index1 != index2:
+			return index1 < index2
+		case err1 == nil && err2 != nil:
+			return true
+		case err1 != nil && err2 == nil:
+			return false
+		default:
+			return o1.path[i] < o2.path[i]
+		}
+	}
+	if len(o1.path) != len(o2.path) {
+		return len(o1.path) < len(o2.path)
+	}
+	return o1.index < o2.index
+}
@@ -0,0 +1,168 @@
+package jsonpatch_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	jpatch "github.com/evanphx/json-patch"
+	internaljsonpatch "github.com/konveyor/crane-lib/transform/internal/jsonpatch"
+)
+
+func TestSplitPath(t *testing.T) {
+	cases := []struct {
+		Path     string
+		Expected []string
+	}{
+		{Path: "", Expected: []string{}},
+		{Path: "/", Expected: []string{""}},
+		{Path: "/spec/containers/0/image", Expected: []string{"spec", "containers", "0", "image"}},
+		{Path: "/metadata/annotations/app.io~1name~0x", Expected: []string{"metadata", "annotations", "app.io/name~x"}},
+	}
+	for _, c := range cases {
+		t.Run(c.Path, func(t *testing.T) {
+			if actual := internaljsonpatch.SplitPath(c.Path); !reflect.DeepEqual(actual, c.Expected) {
+				t.Errorf("incorrect split, actual: %#v expected: %#v", actual, c.Expected)
+			}
+		})
+	}
+}
+
+func TestIsParentPath(t *testing.T) {
+	cases := []struct {
+		Parent   string
+		Child    string
+		Expected bool
+	}{
+		{Parent: "/spec/template", Child: "/spec/template/spec/containers/0/image", Expected: true},
+		{Parent: "/spec/template", Child: "/spec/template", Expected: false},
+		{Parent: "/spec/template", Child: "/spec/templates/spec", Expected: false},
+		{Parent: "/spec/template/spec", Child: "/spec/template", Expected: false},
+		{Parent: "", Child: "/spec", Expected: true},
+	}
+	for _, c := range cases {
+		t.Run(c.Parent+"|"+c.Child, func(t *testing.T) {
+			actual := internaljsonpatch.IsParentPath(internaljsonpatch.SplitPath(c.Parent), internaljsonpatch.SplitPath(c.Child))
+			if actual != c.Expected {
+				t.Errorf("incorrect result, actual: %v expected: %v", actual, c.Expected)
+			}
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	cases := []struct {
+		Name string
+		// Patches are one JSON patch per plugin
+		Patches  []string
+		Expected string
+	}{
+		{
+			Name:     "ParentBeforeChild",
+			Patches:  []string{`[{"op":"add","path":"/metadata/annotations/x","value":"y"}]`, `[{"op":"add","path":"/metadata/annotations","value":{}}]`},
+			Expected: `[{"op":"add","path":"/metadata/annotations","value":{}},{"op":"add","path":"/metadata/annotations/x","value":"y"}]`,
+		},
+		{
+			Name:     "RemovedParentAfterChild",
+			Patches:  []string{`[{"op":"remove","path":"/spec"}]`, `[{"op":"remove","path":"/spec/nodeName"}]`},
+			Expected: `[{"op":"remove","path":"/spec/nodeName"},{"op":"remove","path":"/spec"}]`,
+		},
+		{
+			Name:     "SortedByPath",
+			Patches:  []string{`[{"op":"remove","path":"/status"}]`, `[{"op":"remove","path":"/metadata/uid"}]`, `[{"op":"replace","path":"/spec/image","value":"a"}]`},
+			Expected: `[{"op":"remove","path":"/metadata/uid"},{"op":"replace","path":"/spec/image","value":"a"},{"op":"remove","path":"/status"}]`,
+		},
+		{
+			Name:     "NumericIndexes",
+			Patches:  []string{`[{"op":"replace","path":"/spec/ports/10/port","value":1}]`, `[{"op":"add","path":"/spec/ports/-","value":{}}]`, `[{"op":"replace","path":"/spec/ports/2/port","value":1}]`},
+			Expected: `[{"op":"replace","path":"/spec/ports/2/port","value":1},{"op":"replace","path":"/spec/ports/10/port","value":1},{"op":"add","path":"/spec/ports/-","value":{}}]`,
+		},
+		{
+			Name:     "RemoveIndexesDescending",
+			Patches:  []string{`[{"op":"remove","path":"/subjects/0/namespace"}]`, `[{"op":"remove","path":"/subjects/2"}]`, `[{"op":"remove","path":"/subjects/10"}]`},
+			Expected: `[{"op":"remove","path":"/subjects/0/namespace"},{"op":"remove","path":"/subjects/10"},{"op":"remove","path":"/subjects/2"}]`,
+		},
+		{
+			Name:     "PluginOrderKept",
+			Patches:  []string{`[{"op":"add","path":"/spec/b","value":1},{"op":"add","path":"/spec/a","value":2}]`},
+			Expected: `[{"op":"add","path":"/spec/b","value":1},{"op":"add","path":"/spec/a","value":2}]`,
+		},
+		{
+			Name:     "CopyThenRemove",
+			Patches:  []string{`[{"op":"copy","from":"/spec/a","path":"/spec/b"},{"op":"remove","path":"/spec/a"}]`},
+			Expected: `[{"from":"/spec/a","op":"copy","path":"/spec/b"},{"op":"remove","path":"/spec/a"}]`,
+		},
+		{
+			Name:     "CopyBeforeRemoveFromOtherPlugin",
+			Patches:  []string{`[{"op":"remove","path":"/spec/a"}]`, `[{"op":"copy","from":"/spec/a/x","path":"/spec/z"}]`},
+			Expected: `[{"from":"/spec/a/x","op":"copy","path":"/spec/z"},{"op":"remove","path":"/spec/a"}]`,
+		},
+		{
+			Name:     "MoveSourceBeforeReplace",
+			Patches:  []string{`[{"op":"replace","path":"/spec/a","value":1}]`, `[{"op":"move","from":"/spec/a","path":"/spec/b"}]`},
+			Expected: `[{"from":"/spec/a","op":"move","path":"/spec/b"},{"op":"replace","path":"/spec/a","value":1}]`,
+		},
+		{
+			Name:     "CopyAfterCreatedSource",
+			Patches:  []string{`[{"op":"copy","from":"/spec/a","path":"/spec/b"}]`, `[{"op":"add","path":"/spec/a","value":1}]`},
+			Expected: `[{"op":"add","path":"/spec/a","value":1},{"from":"/spec/a","op":"copy","path":"/spec/b"}]`,
+		},
+		{
+			Name:     "MixedIndexesInOnePatch",
+			Patches:  []string{`[{"op":"remove","path":"/a/1"},{"op":"remove","path":"/a/3"},{"op":"replace","path":"/a/2","value":0}]`},
+			Expected: `[{"op":"remove","path":"/a/1"},{"op":"remove","path":"/a/3"},{"op":"replace","path":"/a/2","value":0}]`,
+		},
+		{
+			Name:     "MixedIndexesAcrossPlugins",
+			Patches:  []string{`[{"op":"remove","path":"/a/1"}]`, `[{"op":"remove","path":"/a/3"}]`, `[{"op":"replace","path":"/a/2","value":0}]`},
+			Expected: `[{"op":"replace","path":"/a/2","value":0},{"op":"remove","path":"/a/3"},{"op":"remove","path":"/a/1"}]`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			patches := []jpatch.Patch{}
+			for _, p := range c.Patches {
+				patch, err := jpatch.DecodePatch([]byte(p))
+				if err != nil {
+					t.Fatal(err)
+				}
+				patches = append(patches, patch)
+			}
+			actual, err := json.Marshal(internaljsonpatch.Merge(patches))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(actual) != c.Expected {
+				t.Errorf("incorrect order, actual: %s expected: %s", actual, c.Expected)
+			}
+			// The merged patch does not depend on the order of the plugins
+			reversed := make([]jpatch.Patch, len(patches))
+			for i, p := range patches {
+				reversed[len(patches)-1-i] = p
+			}
+			actual, err = json.Marshal(internaljsonpatch.Merge(reversed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(actual) != c.Expected {
+				t.Errorf("incorrect order for reversed plugins, actual: %s expected: %s", actual, c.Expected)
+			}
+		})
+	}
+}
+
+func TestMergeApply(t *testing.T) {
+	// Copy then remove from one plugin must not lose the copied value
+	patch, err := jpatch.DecodePatch([]byte(`[{"op":"copy","from":"/spec/a","path":"/spec/b"},{"op":"remove","path":"/spec/a"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := internaljsonpatch.Merge([]jpatch.Patch{patch})
+	actual, err := merged.Apply([]byte(`{"spec":{"a":"value"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != `{"spec":{"b":"value"}}` {
+		t.Errorf("incorrect result, actual: %s", actual)
+	}
+}
@@ -0,0 +1,153 @@
+package textdiff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Context is the number of unchanged lines shown around each change.
+const Context = 3
+
+// Unified returns the line diff from a to b in the unified format, without
+// the file headers. It is empty when a and b are equal.
+func Unified(a, b string) string {
+	aLines, bLines := splitLines(a), splitLines(b)
+	edits := lineEdits(aLines, bLines)
+
+	out := strings.Builder{}
+	for start := 0; start < len(edits); {
+		// Find the next change and the end of its hunk, changes closer than
+		// twice the context share a hunk.
+		first := start
+		for first < len(edits) && edits[first].kind == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		last := first
+		for i := first; i < len(edits); i++ {
+			if edits[i].kind != ' ' {
+				last = i
+			} else if i-last > 2*Context {
+				break
+			}
+		}
+		from := max(first-Context, start)
+		to := min(last+Context+1, len(edits))
+
+		aStart, bStart, aCount, bCount := edits[from].a, edits[from].b, 0, 0
+		for _, e := range edits[from:to] {
+			if e.kind != '+' {
+				aCount++
+			}
+			if e.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%v +%v @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, e := range edits[from:to] {
+			out.WriteByte(e.kind)
+			out.WriteString(e.line)
+			out.WriteByte('\n')
+		}
+		start = to
+	}
+	return out.String()
+}
+
+// hunkRange formats the 1-based start line and the line count of a hunk.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%v,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%v", start+1)
+	}
+	return fmt.Sprintf("%v,%v", start+1, count)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// edit is a line kept (' '), removed ('-') or added ('+'), along with the
+// index of the next line of a and b when it is reached.
+type edit struct {
+	kind byte
+	line string
+	a, b int
+}
+
+// lineEdits returns the edits turning a into b, based on their longest common
+// subsequence of lines. The common prefix and suffix are skipped first, as
+// objects changed by a transform mostly differ by a few lines.
+func lineEdits(a, b []string) []edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	am, bm := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	// lcs[i][j] is the length of the longest common subsequence of am[i:]
+	// and bm[j:]
+	lcs := make([][]int, len(am)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bm)+1)
+	}
+	for i := len(am) - 1; i >= 0; i-- {
+		for j := len(bm) - 1; j >= 0; j-- {
+			if am[i] == bm[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	edits := make([]edit, 0, len(a)+len(b))
+	for i := 0; i < prefix; i++ {
+		edits = append(edits, edit{kind: ' ', line: a[i], a: i, b: i})
+	}
+	i, j := 0, 0
+	for i < len(am) || j < len(bm) {
+		switch {
+		case i < len(am) && j < len(bm) && am[i] == bm[j]:
+			edits = append(edits, edit{kind: ' ', line: am[i], a: prefix + i, b: prefix + j})
+			i++
+			j++
+		case i < len(am) && (j == len(bm) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{kind: '-', line: am[i], a: prefix + i, b: prefix + j})
+			i++
+		default:
+			edits = append(edits, edit{kind: '+', line: bm[j], a: prefix + i, b: prefix + j})
+			j++
+		}
+	}
+	for k := 0; k < suffix; k++ {
+		ai, bi := len(a)-suffix+k, len(b)-suffix+k
+		edits = append(edits, edit{kind: ' ', line: a[ai], a: ai, b: bi})
+	}
+	return edits
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
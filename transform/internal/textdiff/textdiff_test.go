@@ -0,0 +1,64 @@
+package textdiff
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	cases := []struct {
+		Name     string
+		A        string
+		B        string
+		Expected string
+	}{
+		{Name: "Equal", A: "a\nb\n", B: "a\nb\n", Expected: ""},
+		{Name: "Empty", A: "", B: "", Expected: ""},
+		{
+			Name:     "Added",
+			A:        "",
+			B:        "a\n",
+			Expected: "@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			Name:     "Replaced",
+			A:        "a\nb\nc\n",
+			B:        "a\nB\nc\n",
+			Expected: "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			Name: "SeparateHunks",
+			A:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			B:    "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n",
+			Expected: "@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n" +
+				"@@ -9,4 +10,3 @@\n 9\n 10\n 11\n-12\n",
+		},
+		{
+			Name:     "CloseChangesShareAHunk",
+			A:        "1\n2\n3\n4\n5\n",
+			B:        "1\nb\n3\n4\nd\n",
+			Expected: "@@ -1,5 +1,5 @@\n 1\n-2\n+b\n 3\n 4\n-5\n+d\n",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if got := Unified(c.A, c.B); got != c.Expected {
+				t.Errorf("Unified() got =\n%v\nwant =\n%v", got, c.Expected)
+			}
+		})
+	}
+}
+
+func TestUnifiedLarge(t *testing.T) {
+	lines := []string{}
+	for i := 0; i < 5000; i++ {
+		lines = append(lines, strings.Repeat("x", i%7))
+	}
+	a := strings.Join(lines, "\n")
+	lines[2500] = "changed"
+	b := strings.Join(lines, "\n")
+	got := Unified(a, b)
+	if !strings.Contains(got, "-x\n+changed\n") || strings.Count(got, "@@ -") != 1 {
+		t.Errorf("Unified() got = %v", got)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 
 	jsonpatch "github.com/evanphx/json-patch"
 	transform "github.com/konveyor/crane-lib/transform"
@@ -13,7 +14,6 @@ import (
 	"github.com/konveyor/crane-lib/version"
 	"github.com/sirupsen/logrus"
 	appsv1 "k8s.io/api/apps/v1"
-	batchv1 "k8s.io/api/batch/v1"
 	v1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -21,7 +21,7 @@ import (
 	"k8s.io/apimachinery/pkg/util/sets"
 )
 
-var logger logrus.FieldLogger
+var logger logrus.FieldLogger = logrus.New()
 
 const (
 	AddAnnotationsFlag       = "add-annotations"
@@ -33,14 +33,13 @@ const (
 	StripDefaultRBACFlag     = "strip-default-rbac"
 	StripDefaultCABundleFlag = "strip-default-cabundle"
 	PVCRenameMap             = "pvc-rename-map"
+	ImageRulesFlag           = "image-rules"
+	NamespaceMapFlag         = "namespace-map"
 )
 
 const (
-	containerImageUpdate        = "/spec/template/spec/containers/%v/image"
-	initContainerImageUpdate    = "/spec/template/spec/initContainers/%v/image"
-	podContainerImageUpdate     = "/spec/containers/%v/image"
-	podInitContainerImageUpdate = "/spec/initContainers/%v/image"
-	annotationInitial           = `%v
+	pvcClaimName      = "/volumes/%d/persistentVolumeClaim/claimName"
+	annotationInitial = `%v
 {"op": "add", "path": "/metadata/annotations/%v", "value": "%v"}`
 	annotationNext = `%v,
 {"op": "add", "path": "/metadata/annotations/%v", "value": "%v"}`
@@ -78,23 +77,19 @@ var fieldsToStrip = [...][]string{
 
 // GroupKinds we are likely to interact with
 var (
-	configMapGK             = schema.GroupKind{Group: "", Kind: "ConfigMap"}
-	cronJobGK               = schema.GroupKind{Group: "batch", Kind: "CronJob"}
-	daemonSetGK             = schema.GroupKind{Group: "apps", Kind: "DaemonSet"}
-	deploymentGK            = schema.GroupKind{Group: "apps", Kind: "Deployment"}
-	endpointGK              = schema.GroupKind{Group: "", Kind: "Endpoints"}
-	endpointSliceGK         = schema.GroupKind{Group: "discovery.k8s.io", Kind: "EndpointSlice"}
-	extensionsGroup         = "extensions"
-	jobGK                   = schema.GroupKind{Group: "batch", Kind: "Job"}
-	pvcGK                   = schema.GroupKind{Group: "", Kind: "PersistentVolumeClaim"}
-	podGK                   = schema.GroupKind{Group: "", Kind: "Pod"}
-	replicaSetGK            = schema.GroupKind{Group: "apps", Kind: "ReplicaSet"}
-	replicationControllerGK = schema.GroupKind{Group: "", Kind: "ReplicationController"}
-	roleBindingGK           = schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"}
-	serviceGK               = schema.GroupKind{Group: "", Kind: "Service"}
-	secretGK                = schema.GroupKind{Group: "", Kind: "Secret"}
-	serviceAccountGK        = schema.GroupKind{Group: "", Kind: "ServiceAccount"}
-	statefulSetGK           = schema.GroupKind{Group: "apps", Kind: "StatefulSet"}
+	clusterRoleBindingGK = schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"}
+	configMapGK          = schema.GroupKind{Group: "", Kind: "ConfigMap"}
+	endpointGK           = schema.GroupKind{Group: "", Kind: "Endpoints"}
+	endpointSliceGK      = schema.GroupKind{Group: "discovery.k8s.io", Kind: "EndpointSlice"}
+	extensionsGroup      = "extensions"
+	networkPolicyGK      = schema.GroupKind{Group: "networking.k8s.io", Kind: "NetworkPolicy"}
+	pvcGK                = schema.GroupKind{Group: "", Kind: "PersistentVolumeClaim"}
+	podGK                = schema.GroupKind{Group: "", Kind: "Pod"}
+	roleBindingGK        = schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "RoleBinding"}
+	serviceGK            = schema.GroupKind{Group: "", Kind: "Service"}
+	secretGK             = schema.GroupKind{Group: "", Kind: "Secret"}
+	serviceAccountGK     = schema.GroupKind{Group: "", Kind: "ServiceAccount"}
+	statefulSetGK        = schema.GroupKind{Group: "apps", Kind: "StatefulSet"}
 )
 
 var gksToWhiteout = []schema.GroupKind{
@@ -113,22 +108,33 @@ type KubernetesTransformPlugin struct {
 	StripDefaultRBAC     bool
 	StripDefaultCABundle bool
 	PVCRenameMap         map[string]string
+	// ImageRules rewrite the images after the RegistryReplacement.
+	ImageRules *util.ImageRewriter
+	// NamespaceMap moves objects from the source namespaces to the
+	// destination namespaces, along with the namespaced references they hold.
+	NamespaceMap map[string]string
+
+	// imageRuleFiles is shared with the copies made by Run, so that the
+	// image rule files are read once per plugin.
+	imageRuleFiles *imageRuleFiles
 }
 
 func (k *KubernetesTransformPlugin) Run(request transform.PluginRequest) (transform.PluginResponse, error) {
-	logger = logrus.New()
+	// Options are parsed onto a copy so that concurrent runs do not race.
+	k.initImageRuleFiles()
+	plugin := *k
 	resp := transform.PluginResponse{}
-	err := k.setOptionalFields(request.Extras)
+	err := plugin.setOptionalFields(request.Extras)
 	if err != nil {
 		return resp, err
 	}
 	// Set version in the future
 	resp.Version = string(transform.V1)
-	resp.IsWhiteOut = k.getWhiteOuts(request.Unstructured)
+	resp.IsWhiteOut = plugin.getWhiteOuts(request.Unstructured)
 	if resp.IsWhiteOut {
 		return resp, nil
 	}
-	resp.Patches, err = k.getKubernetesTransforms(request.Unstructured)
+	resp.Patches, err = plugin.getKubernetesTransforms(request.Unstructured)
 	return resp, err
 
 }
@@ -144,46 +150,69 @@ func (k *KubernetesTransformPlugin) Metadata() transform.PluginMetadata {
 				FlagName: AddAnnotationsFlag,
 				Help:     "Annotations to add to each resource",
 				Example:  "annotation1=value1,annotation2=value2",
+				Type:     transform.FieldTypeMap,
 			},
 			{
 				FlagName: RegistryReplacementFlag,
 				Help:     "Map of image registry paths to swap on transform, in the format original-registry1=target-registry1,original-registry2=target-registry2...",
 				Example:  "docker-registry.default.svc:5000=image-registry.openshift-image-registry.svc:5000,docker.io/foo=quay.io/bar",
+				Type:     transform.FieldTypeMap,
 			},
 			{
 				FlagName: RemoveAnnotationsFlag,
 				Help:     "Annotations to remove",
 				Example:  "annotation1,annotation2",
+				Type:     transform.FieldTypeStringList,
 			},
 			{
 				FlagName: DisableWhiteoutOwnedFlag,
 				Help:     "Disable whiting out owned pods and pod template resources",
 				Example:  "true",
+				Type:     transform.FieldTypeBool,
 			},
 			{
 				FlagName: ExtraWhiteoutsFlag,
 				Help:     "Additional resources to whiteout specified as a comma-separated list of GroupKind strings.",
 				Example:  "Deployment.apps,Service,Route.route.openshift.io",
+				Type:     transform.FieldTypeGroupKindList,
 			},
 			{
 				FlagName: IncludeOnlyFlag,
 				Help:     "If specified, every resource not listed here will be a whiteout. extra-whiteouts is ignored when include-only is specified. Specified as a comma-separated list of GroupKind strings.",
 				Example:  "Deployment.apps,Service,Route.route.openshift.io",
+				Type:     transform.FieldTypeGroupKindList,
 			},
 			{
 				FlagName: StripDefaultRBACFlag,
 				Help:     "Whether to strip default RBAC including default serviceAccount (default: true)",
 				Example:  "true",
+				Type:     transform.FieldTypeBool,
+				Default:  "true",
 			},
 			{
 				FlagName: StripDefaultCABundleFlag,
 				Help:     "Whether to strip default CA Bundle (default: true)",
 				Example:  "true",
+				Type:     transform.FieldTypeBool,
+				Default:  "true",
 			},
 			{
 				FlagName: PVCRenameMap,
 				Help:     "A comma-separated list of colon separated pvc renames.",
 				Example:  "old-pvc1-name:new-pvc1-name,old-pvc2-name:new-pvc2-name",
+				Type:     transform.FieldTypeStringList,
+			},
+			{
+				FlagName: ImageRulesFlag,
+				Help:     "Path of a YAML file of image rules rewriting images by regex, tag, mirror mapping file or dropping their digest",
+				Example:  "/path/to/image-rules.yaml",
+				Type:     transform.FieldTypeString,
+			},
+			{
+				FlagName: NamespaceMapFlag,
+				Help:     "Map of source namespaces to destination namespaces, rewriting the namespace of resources, RoleBinding subjects, ServiceAccount users and groups, service DNS names in env vars and ConfigMaps, and NetworkPolicy namespaceSelectors",
+				Example:  "source-ns1=destination-ns1,source-ns2=destination-ns2",
+				Type:     transform.FieldTypeMap,
 			},
 		},
 	}
@@ -194,23 +223,41 @@ func (k *KubernetesTransformPlugin) setOptionalFields(extras map[string]string)
 	k.StripDefaultRBAC = true
 	k.StripDefaultCABundle = true
 
+	// The lists and maps are parsed with the quoting of their declared type
+	var err error
 	if len(extras[AddAnnotationsFlag]) > 0 {
-		k.AddAnnotations = transform.ParseOptionalFieldMapVal(extras[AddAnnotationsFlag])
+		k.AddAnnotations, err = transform.ParseMap(extras[AddAnnotationsFlag])
+		if err != nil {
+			return err
+		}
 	}
 	if len(extras[RemoveAnnotationsFlag]) > 0 {
-		k.RemoveAnnotations = transform.ParseOptionalFieldSliceVal(extras[RemoveAnnotationsFlag])
+		k.RemoveAnnotations, err = transform.ParseStringList(extras[RemoveAnnotationsFlag])
+		if err != nil {
+			return err
+		}
 	}
 	if len(extras[RegistryReplacementFlag]) > 0 {
-		k.RegistryReplacement = transform.ParseOptionalFieldMapVal(extras[RegistryReplacementFlag])
+		k.RegistryReplacement, err = transform.ParseMap(extras[RegistryReplacementFlag])
+		if err != nil {
+			return err
+		}
 	}
 	if len(extras[ExtraWhiteoutsFlag]) > 0 {
-		k.ExtraWhiteouts = parseGroupKindSlice(transform.ParseOptionalFieldSliceVal(extras[ExtraWhiteoutsFlag]))
+		extraWhiteouts, err := transform.ParseStringList(extras[ExtraWhiteoutsFlag])
+		if err != nil {
+			return err
+		}
+		k.ExtraWhiteouts = parseGroupKindSlice(extraWhiteouts)
 	}
 	if len(extras[IncludeOnlyFlag]) > 0 {
-		k.IncludeOnly = parseGroupKindSlice(transform.ParseOptionalFieldSliceVal(extras[IncludeOnlyFlag]))
+		includeOnly, err := transform.ParseStringList(extras[IncludeOnlyFlag])
+		if err != nil {
+			return err
+		}
+		k.IncludeOnly = parseGroupKindSlice(includeOnly)
 	}
 	if len(extras[DisableWhiteoutOwnedFlag]) > 0 {
-		var err error
 		k.DisableWhiteoutOwned, err = strconv.ParseBool(extras[DisableWhiteoutOwnedFlag])
 		if err != nil {
 			k.DisableWhiteoutOwned = false
@@ -229,9 +276,61 @@ func (k *KubernetesTransformPlugin) setOptionalFields(extras map[string]string)
 		}
 		k.PVCRenameMap = pvcMap
 	}
+	if len(extras[ImageRulesFlag]) > 0 {
+		imageRules, err := k.imageRuleFiles.load(extras[ImageRulesFlag])
+		if err != nil {
+			return err
+		}
+		k.ImageRules = imageRules
+	}
+	if len(extras[NamespaceMapFlag]) > 0 {
+		namespaceMap, err := parseNamespaceMap(extras[NamespaceMapFlag])
+		if err != nil {
+			return err
+		}
+		k.NamespaceMap = namespaceMap
+	}
 	return nil
 }
 
+// imageRuleFiles holds the image rule files of a plugin by path, a file is
+// only read and compiled for the first object. Failures are not kept, the
+// file is read again for the next object.
+type imageRuleFiles struct {
+	sync.Mutex
+	loaded map[string]*util.ImageRewriter
+}
+
+// imageRuleFilesInit guards the creation of the imageRuleFiles of plugins.
+var imageRuleFilesInit sync.Mutex
+
+func (k *KubernetesTransformPlugin) initImageRuleFiles() {
+	imageRuleFilesInit.Lock()
+	defer imageRuleFilesInit.Unlock()
+	if k.imageRuleFiles == nil {
+		k.imageRuleFiles = &imageRuleFiles{loaded: map[string]*util.ImageRewriter{}}
+	}
+}
+
+// load returns the rules of the image rule file, it reads the file every time
+// on a nil imageRuleFiles.
+func (f *imageRuleFiles) load(path string) (*util.ImageRewriter, error) {
+	if f == nil {
+		return util.LoadImageRules(path)
+	}
+	f.Lock()
+	defer f.Unlock()
+	if rewriter, ok := f.loaded[path]; ok {
+		return rewriter, nil
+	}
+	rewriter, err := util.LoadImageRules(path)
+	if err != nil {
+		return nil, err
+	}
+	f.loaded[path] = rewriter
+	return rewriter, nil
+}
+
 var _ transform.Plugin = &KubernetesTransformPlugin{}
 
 func (k *KubernetesTransformPlugin) getWhiteOuts(obj unstructured.Unstructured) bool {
@@ -315,23 +414,6 @@ func (k *KubernetesTransformPlugin) getKubernetesTransforms(obj unstructured.Uns
 		}
 		jsonPatch = append(jsonPatch, patches...)
 	}
-	if cronJobGK == obj.GetObjectKind().GroupVersionKind().GroupKind() {
-		js, err := obj.MarshalJSON()
-		if err != nil {
-			return nil, err
-		}
-		cronJob := &batchv1.CronJob{}
-		err = json.Unmarshal(js, cronJob)
-		if err != nil {
-			return nil, err
-		}
-
-		patches, err := util.RenamePVCs(cronJob.Spec.JobTemplate.Spec.Template.Spec.Volumes, k.PVCRenameMap, util.PVCPathCronJobString)
-		if err != nil {
-			return nil, err
-		}
-		jsonPatch = append(jsonPatch, patches...)
-	}
 
 	if podGK == obj.GetObjectKind().GroupVersionKind().GroupKind() {
 		js, err := obj.MarshalJSON()
@@ -349,97 +431,6 @@ func (k *KubernetesTransformPlugin) getKubernetesTransforms(obj unstructured.Uns
 			return nil, err
 		}
 		jsonPatch = append(jsonPatch, patches...)
-
-		patches, err = util.RenamePVCs(pod.Spec.Volumes, k.PVCRenameMap, util.PVCPathPodString)
-		if err != nil {
-			return nil, err
-		}
-		jsonPatch = append(jsonPatch, patches...)
-	}
-	if daemonSetGK == obj.GetObjectKind().GroupVersionKind().GroupKind() {
-		js, err := obj.MarshalJSON()
-		if err != nil {
-			return nil, err
-		}
-		daemonSet := &appsv1.DaemonSet{}
-		err = json.Unmarshal(js, daemonSet)
-		if err != nil {
-			return nil, err
-		}
-
-		patches, err := util.RenamePVCs(daemonSet.Spec.Template.Spec.Volumes, k.PVCRenameMap, util.PVCPathGenericString)
-		if err != nil {
-			return nil, err
-		}
-		jsonPatch = append(jsonPatch, patches...)
-	}
-	if deploymentGK == obj.GetObjectKind().GroupVersionKind().GroupKind() {
-		js, err := obj.MarshalJSON()
-		if err != nil {
-			return nil, err
-		}
-		deployment := &appsv1.Deployment{}
-		err = json.Unmarshal(js, deployment)
-		if err != nil {
-			return nil, err
-		}
-
-		patches, err := util.RenamePVCs(deployment.Spec.Template.Spec.Volumes, k.PVCRenameMap, util.PVCPathGenericString)
-		if err != nil {
-			return nil, err
-		}
-		jsonPatch = append(jsonPatch, patches...)
-	}
-	if jobGK == obj.GetObjectKind().GroupVersionKind().GroupKind() {
-		js, err := obj.MarshalJSON()
-		if err != nil {
-			return nil, err
-		}
-		job := &batchv1.Job{}
-		err = json.Unmarshal(js, job)
-		if err != nil {
-			return nil, err
-		}
-
-		patches, err := util.RenamePVCs(job.Spec.Template.Spec.Volumes, k.PVCRenameMap, util.PVCPathGenericString)
-		if err != nil {
-			return nil, err
-		}
-		jsonPatch = append(jsonPatch, patches...)
-	}
-	if replicationControllerGK == obj.GetObjectKind().GroupVersionKind().GroupKind() {
-		js, err := obj.MarshalJSON()
-		if err != nil {
-			return nil, err
-		}
-		replicationController := &v1.ReplicationController{}
-		err = json.Unmarshal(js, replicationController)
-		if err != nil {
-			return nil, err
-		}
-
-		patches, err := util.RenamePVCs(replicationController.Spec.Template.Spec.Volumes, k.PVCRenameMap, util.PVCPathGenericString)
-		if err != nil {
-			return nil, err
-		}
-		jsonPatch = append(jsonPatch, patches...)
-	}
-	if replicaSetGK == obj.GetObjectKind().GroupVersionKind().GroupKind() {
-		js, err := obj.MarshalJSON()
-		if err != nil {
-			return nil, err
-		}
-		replicaSet := &appsv1.ReplicaSet{}
-		err = json.Unmarshal(js, replicaSet)
-		if err != nil {
-			return nil, err
-		}
-
-		patches, err := util.RenamePVCs(replicaSet.Spec.Template.Spec.Volumes, k.PVCRenameMap, util.PVCPathGenericString)
-		if err != nil {
-			return nil, err
-		}
-		jsonPatch = append(jsonPatch, patches...)
 	}
 	if roleBindingGK == obj.GetObjectKind().GroupVersionKind().GroupKind() {
 		js, err := obj.MarshalJSON()
@@ -453,6 +444,10 @@ func (k *KubernetesTransformPlugin) getKubernetesTransforms(obj unstructured.Uns
 			return nil, err
 		}
 		for i, subj := range rb.Subjects {
+			// Subjects in a mapped namespace are moved by the namespace map
+			if _, ok := k.mappedNamespace(subj.Namespace); ok {
+				continue
+			}
 			if subj.Kind == "ServiceAccount" && subj.Namespace == rb.Namespace {
 				subjPath := fmt.Sprintf(roleBindingSubject, i)
 				patch, err := jsonpatch.DecodePatch([]byte(fmt.Sprintf(opRemove, subjPath)))
@@ -474,76 +469,19 @@ func (k *KubernetesTransformPlugin) getKubernetesTransforms(obj unstructured.Uns
 			return nil, err
 		}
 
-		patches, err := util.RenamePVCs(statefulSet.Spec.Template.Spec.Volumes, k.PVCRenameMap, util.PVCPathGenericString)
+		patches, err := renamePVCTemplates(statefulSet.Spec.VolumeClaimTemplates, k.PVCRenameMap, util.PVCPathTemplateString)
 		if err != nil {
 			return nil, err
 		}
 		jsonPatch = append(jsonPatch, patches...)
-
-		patches, err = renamePVCTemplates(statefulSet.Spec.VolumeClaimTemplates, k.PVCRenameMap, util.PVCPathTemplateString)
+	}
+	if len(k.PVCRenameMap) > 0 || len(k.RegistryReplacement) > 0 || k.ImageRules != nil {
+		patches, err := k.getPodSpecTransforms(obj)
 		if err != nil {
 			return nil, err
 		}
 		jsonPatch = append(jsonPatch, patches...)
 	}
-	if k.RegistryReplacement != nil && len(k.RegistryReplacement) > 0 {
-		if podGK == obj.GetObjectKind().GroupVersionKind().GroupKind() {
-			js, err := obj.MarshalJSON()
-			if err != nil {
-				return nil, err
-			}
-			pod := &v1.Pod{}
-			err = json.Unmarshal(js, pod)
-			if err != nil {
-				return nil, err
-			}
-			jps := jsonpatch.Patch{}
-			for i, container := range pod.Spec.Containers {
-				updatedImage, update := util.UpdateImageRegistry(k.RegistryReplacement, container.Image)
-				if update {
-					jp, err := util.UpdateImage(fmt.Sprintf(podContainerImageUpdate, i), updatedImage)
-					if err != nil {
-						return nil, err
-					}
-					jps = append(jps, jp...)
-				}
-			}
-			for i, container := range pod.Spec.InitContainers {
-				updatedImage, update := util.UpdateImageRegistry(k.RegistryReplacement, container.Image)
-				if update {
-					jp, err := util.UpdateImage(fmt.Sprintf(podInitContainerImageUpdate, i), updatedImage)
-					if err != nil {
-						return nil, err
-					}
-					jps = append(jps, jp...)
-				}
-			}
-			jsonPatch = append(jsonPatch, jps...)
-		} else if template, ok := types.IsPodSpecable(obj); ok {
-			jps := jsonpatch.Patch{}
-			for i, container := range template.Spec.Containers {
-				updatedImage, update := util.UpdateImageRegistry(k.RegistryReplacement, container.Image)
-				if update {
-					jp, err := util.UpdateImage(fmt.Sprintf(containerImageUpdate, i), updatedImage)
-					if err != nil {
-						return nil, err
-					}
-					jps = append(jps, jp...)
-				}
-			}
-			for i, container := range template.Spec.InitContainers {
-				updatedImage, update := util.UpdateImageRegistry(k.RegistryReplacement, container.Image)
-				if update {
-					jp, err := util.UpdateImage(fmt.Sprintf(initContainerImageUpdate, i), updatedImage)
-					if err != nil {
-						return nil, err
-					}
-					jps = append(jps, jp...)
-				}
-			}
-			jsonPatch = append(jsonPatch, jps...)
-		}
-	}
 	if obj.GetObjectKind().GroupVersionKind().GroupKind() == serviceGK {
 		patches, err := removeServiceFields(obj)
 		if err != nil {
@@ -551,7 +489,55 @@ func (k *KubernetesTransformPlugin) getKubernetesTransforms(obj unstructured.Uns
 		}
 		jsonPatch = append(jsonPatch, patches...)
 	}
+	if len(k.NamespaceMap) > 0 {
+		patches, err := k.getNamespaceTransforms(obj)
+		if err != nil {
+			return nil, err
+		}
+		jsonPatch = append(jsonPatch, patches...)
+	}
+
+	return jsonPatch, nil
+}
 
+// getPodSpecTransforms renames the PVCs used by the volumes and replaces the
+// registry of the images of every PodSpec found in the object, then rewrites
+// them with the ImageRules.
+func (k *KubernetesTransformPlugin) getPodSpecTransforms(obj unstructured.Unstructured) (jsonpatch.Patch, error) {
+	jsonPatch := jsonpatch.Patch{}
+	podSpecs, err := types.PodSpecs(obj)
+	if err != nil {
+		return nil, err
+	}
+	for _, podSpec := range podSpecs {
+		patches, err := util.RenamePVCs(podSpec.Spec.Volumes, k.PVCRenameMap, podSpec.Path+pvcClaimName)
+		if err != nil {
+			return nil, err
+		}
+		jsonPatch = append(jsonPatch, patches...)
+	}
+	if len(k.RegistryReplacement) == 0 && k.ImageRules == nil {
+		return jsonPatch, nil
+	}
+	images, err := types.ImageReferences(obj)
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		updatedImage, update := util.UpdateImageRegistry(k.RegistryReplacement, image.Image)
+		if !update {
+			updatedImage = image.Image
+		}
+		updatedImage, rewritten := k.ImageRules.Rewrite(updatedImage)
+		if !update && !rewritten {
+			continue
+		}
+		patches, err := util.UpdateImage(image.Path, updatedImage)
+		if err != nil {
+			return nil, err
+		}
+		jsonPatch = append(jsonPatch, patches...)
+	}
 	return jsonPatch, nil
 }
 
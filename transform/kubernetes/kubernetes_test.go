@@ -3,6 +3,9 @@ package kubernetes_test
 import (
         "encoding/json"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	jsonpatch "github.com/evanphx/json-patch"
@@ -21,6 +24,8 @@ func TestRun(t *testing.T) {
 		Object               *unstructured.Unstructured
 		AddAnnotations       map[string]string
 		RegistryReplacement  map[string]string
+		PVCRenameMap         map[string]string
+		NamespaceMap         map[string]string
 		DisableWhiteoutOwned bool
 		RemoveAnnotations    []string
 		ExtraWhiteouts       []schema.GroupKind
@@ -310,6 +315,286 @@ func TestRun(t *testing.T) {
 				"quay.io": "dockerhub.io",
 			},
 		},
+		{
+			Name: "CronJobPodSpec",
+			Object: &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"kind":       "CronJob",
+					"apiVersion": "batch/v1",
+					"spec": map[string]interface{}{
+						"jobTemplate": map[string]interface{}{
+							"spec": map[string]interface{}{
+								"template": v1.PodTemplateSpec{
+									Spec: v1.PodSpec{
+										Containers: []v1.Container{
+											{
+												Image: "quay.io/shawn_hurley/testing-image",
+											},
+										},
+										Volumes: []v1.Volume{
+											{
+												Name: "data",
+												VolumeSource: v1.VolumeSource{
+													PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "old"},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			Response: transform.PluginResponse{
+				IsWhiteOut: false,
+				Version:    "v1",
+			},
+			PatchResponseJson: `[{"op": "replace", "path": "/spec/jobTemplate/spec/template/spec/volumes/0/persistentVolumeClaim/claimName", "value": "new"}, {"op": "replace", "path": "/spec/jobTemplate/spec/template/spec/containers/0/image", "value": "dockerhub.io/shawn_hurley/testing-image"}]`,
+			RegistryReplacement: map[string]string{
+				"quay.io": "dockerhub.io",
+			},
+			PVCRenameMap: map[string]string{
+				"old": "new",
+			},
+		},
+		{
+			Name: "PodEphemeralContainers",
+			Object: &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"kind":       "Pod",
+					"apiVersion": "v1",
+					"spec": v1.PodSpec{
+						Containers: []v1.Container{
+							{
+								Image: "dockerhub.io/shawn_hurley/testing-image",
+							},
+						},
+						EphemeralContainers: []v1.EphemeralContainer{
+							{
+								EphemeralContainerCommon: v1.EphemeralContainerCommon{
+									Image: "quay.io/shawn_hurley/debug",
+								},
+							},
+						},
+					},
+				},
+			},
+			Response: transform.PluginResponse{
+				IsWhiteOut: false,
+				Version:    "v1",
+			},
+			PatchResponseJson: `[{"op": "remove", "path": "/spec/nodeName"}, {"op": "remove", "path": "/spec/nodeSelector"}, {"op": "remove", "path": "/spec/priority"}, {"op": "replace", "path": "/spec/ephemeralContainers/0/image", "value": "dockerhub.io/shawn_hurley/debug"}]`,
+			RegistryReplacement: map[string]string{
+				"quay.io": "dockerhub.io",
+			},
+		},
+		{
+			Name: "DeploymentConfigTriggers",
+			Object: &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"kind":       "DeploymentConfig",
+					"apiVersion": "apps.openshift.io/v1",
+					"spec": map[string]interface{}{
+						"template": v1.PodTemplateSpec{
+							Spec: v1.PodSpec{
+								Containers: []v1.Container{
+									{
+										Image: "quay.io/shawn_hurley/testing-image@sha256:abc",
+									},
+								},
+							},
+						},
+						"triggers": []interface{}{
+							map[string]interface{}{"type": "ConfigChange"},
+							map[string]interface{}{
+								"type": "ImageChange",
+								"imageChangeParams": map[string]interface{}{
+									"lastTriggeredImage": "quay.io/shawn_hurley/testing-image@sha256:abc",
+								},
+							},
+						},
+					},
+				},
+			},
+			Response: transform.PluginResponse{
+				IsWhiteOut: false,
+				Version:    "v1",
+			},
+			PatchResponseJson: `[{"op": "replace", "path": "/spec/template/spec/containers/0/image", "value": "dockerhub.io/shawn_hurley/testing-image@sha256:abc"}, {"op": "replace", "path": "/spec/triggers/1/imageChangeParams/lastTriggeredImage", "value": "dockerhub.io/shawn_hurley/testing-image@sha256:abc"}]`,
+			RegistryReplacement: map[string]string{
+				"quay.io": "dockerhub.io",
+			},
+		},
+		{
+			Name: "NamespaceMapRoleBinding",
+			Object: &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"kind":       "RoleBinding",
+					"apiVersion": "rbac.authorization.k8s.io/v1",
+					"metadata": map[string]interface{}{
+						"name":      "edit",
+						"namespace": "source",
+					},
+					"subjects": []interface{}{
+						map[string]interface{}{"kind": "ServiceAccount", "name": "app", "namespace": "source"},
+						map[string]interface{}{"kind": "ServiceAccount", "name": "app", "namespace": "other"},
+						map[string]interface{}{"kind": "User", "name": "system:serviceaccount:source:builder"},
+						map[string]interface{}{"kind": "Group", "name": "system:serviceaccounts:source"},
+						map[string]interface{}{"kind": "User", "name": "developer"},
+					},
+				},
+			},
+			Response: transform.PluginResponse{
+				IsWhiteOut: false,
+				Version:    "v1",
+			},
+			PatchResponseJson: `[{"op": "replace", "path": "/metadata/namespace", "value": "destination"}, {"op": "replace", "path": "/subjects/0/namespace", "value": "destination"}, {"op": "replace", "path": "/subjects/2/name", "value": "system:serviceaccount:destination:builder"}, {"op": "replace", "path": "/subjects/3/name", "value": "system:serviceaccounts:destination"}]`,
+			NamespaceMap: map[string]string{
+				"source": "destination",
+			},
+		},
+		{
+			Name: "NamespaceMapConfigMap",
+			Object: &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"kind":       "ConfigMap",
+					"apiVersion": "v1",
+					"metadata": map[string]interface{}{
+						"name":      "config",
+						"namespace": "other",
+					},
+					"data": map[string]interface{}{
+						"db":    "postgres://db.source.svc:5432/app",
+						"hosts": "db-0.db.source.svc.cluster.local,cache.other.svc",
+						"site":  "https://source.example.com",
+					},
+				},
+			},
+			Response: transform.PluginResponse{
+				IsWhiteOut: false,
+				Version:    "v1",
+			},
+			PatchResponseJson: `[{"op": "replace", "path": "/data/db", "value": "postgres://db.destination.svc:5432/app"}, {"op": "replace", "path": "/data/hosts", "value": "db-0.db.destination.svc.cluster.local,cache.other.svc"}]`,
+			NamespaceMap: map[string]string{
+				"source": "destination",
+			},
+		},
+		{
+			Name: "NamespaceMapConfigMapNotServiceNames",
+			Object: &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"kind":       "ConfigMap",
+					"apiVersion": "v1",
+					"metadata": map[string]interface{}{
+						"name":      "config",
+						"namespace": "other",
+					},
+					"data": map[string]interface{}{
+						"site":  "https://x.source.svc-foo.example.com/app",
+						"mixed": "x.source.svc-foo a.source.svc,b.source.svc/x",
+					},
+				},
+			},
+			Response: transform.PluginResponse{
+				IsWhiteOut: false,
+				Version:    "v1",
+			},
+			PatchResponseJson: `[{"op": "replace", "path": "/data/mixed", "value": "x.source.svc-foo a.destination.svc,b.destination.svc/x"}]`,
+			NamespaceMap: map[string]string{
+				"source": "destination",
+			},
+		},
+		{
+			Name: "NamespaceMapDeploymentEnv",
+			Object: &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"kind":       "Deployment",
+					"apiVersion": "apps/v1",
+					"metadata": map[string]interface{}{
+						"name":      "web",
+						"namespace": "source",
+					},
+					"spec": map[string]interface{}{
+						"template": v1.PodTemplateSpec{
+							Spec: v1.PodSpec{
+								Containers: []v1.Container{
+									{
+										Name: "web",
+										Env: []v1.EnvVar{
+											{Name: "NAME", Value: "web"},
+											{Name: "DB_HOST", Value: "db.source.svc"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			Response: transform.PluginResponse{
+				IsWhiteOut: false,
+				Version:    "v1",
+			},
+			PatchResponseJson: `[{"op": "replace", "path": "/metadata/namespace", "value": "destination"}, {"op": "replace", "path": "/spec/template/spec/containers/0/env/1/value", "value": "db.destination.svc"}]`,
+			NamespaceMap: map[string]string{
+				"source": "destination",
+			},
+		},
+		{
+			Name: "NamespaceMapNetworkPolicy",
+			Object: &unstructured.Unstructured{
+				Object: map[string]interface{}{
+					"kind":       "NetworkPolicy",
+					"apiVersion": "networking.k8s.io/v1",
+					"metadata": map[string]interface{}{
+						"name":      "allow",
+						"namespace": "other",
+					},
+					"spec": map[string]interface{}{
+						"ingress": []interface{}{
+							map[string]interface{}{
+								"from": []interface{}{
+									map[string]interface{}{
+										"namespaceSelector": map[string]interface{}{
+											"matchLabels": map[string]interface{}{"kubernetes.io/metadata.name": "source"},
+										},
+									},
+								},
+							},
+						},
+						"egress": []interface{}{
+							map[string]interface{}{
+								"to": []interface{}{
+									map[string]interface{}{
+										"podSelector": map[string]interface{}{},
+									},
+									map[string]interface{}{
+										"namespaceSelector": map[string]interface{}{
+											"matchExpressions": []interface{}{
+												map[string]interface{}{
+													"key":      "kubernetes.io/metadata.name",
+													"operator": "In",
+													"values":   []interface{}{"other", "source"},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			Response: transform.PluginResponse{
+				IsWhiteOut: false,
+				Version:    "v1",
+			},
+			PatchResponseJson: `[{"op": "replace", "path": "/spec/ingress/0/from/0/namespaceSelector/matchLabels/kubernetes.io~1metadata.name", "value": "destination"}, {"op": "replace", "path": "/spec/egress/0/to/1/namespaceSelector/matchExpressions/0/values/1", "value": "destination"}]`,
+			NamespaceMap: map[string]string{
+				"source": "destination",
+			},
+		},
 		{
 			Name: "RemoveMetadataAndStatus",
 			Object: &unstructured.Unstructured{
@@ -587,6 +872,8 @@ func TestRun(t *testing.T) {
 			var p transform.Plugin = &kubernetes.KubernetesTransformPlugin{
 				AddAnnotations:       c.AddAnnotations,
 				RegistryReplacement:  c.RegistryReplacement,
+				PVCRenameMap:         c.PVCRenameMap,
+				NamespaceMap:         c.NamespaceMap,
 				RemoveAnnotations:    c.RemoveAnnotations,
 				DisableWhiteoutOwned: c.DisableWhiteoutOwned,
 				ExtraWhiteouts:       c.ExtraWhiteouts,
@@ -622,3 +909,150 @@ func TestRun(t *testing.T) {
 		})
 	}
 }
+
+func TestRunImageRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rules := filepath.Join(dir, "rules.yaml")
+	err = ioutil.WriteFile(rules, []byte("rules:\n- match: dockerhub.io/(.*):.*\n  replace: mirror.example.com/$1\n  tag: v2\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	object := unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"kind":       "Deployment",
+			"apiVersion": "apps/v1",
+			"spec": map[string]interface{}{
+				"template": v1.PodTemplateSpec{
+					Spec: v1.PodSpec{
+						Containers: []v1.Container{
+							{
+								Image: "quay.io/shawn_hurley/testing-image:v1",
+							},
+							{
+								Image: "registry.example.com/shawn_hurley/testing-image:v1",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	p := &kubernetes.KubernetesTransformPlugin{}
+	resp, err := p.Run(transform.PluginRequest{
+		Unstructured: object,
+		Extras: map[string]string{
+			kubernetes.RegistryReplacementFlag: "quay.io=dockerhub.io",
+			kubernetes.ImageRulesFlag:          rules,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPatch, err := jsonpatch.DecodePatch([]byte(`[{"op": "replace", "path": "/spec/template/spec/containers/0/image", "value": "mirror.example.com/shawn_hurley/testing-image:v2"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := internaljsonpatch.Equal(resp.Patches, expectPatch); !ok || err != nil {
+		actual, _ := json.Marshal(resp.Patches)
+		t.Errorf("Invalid patches. Actual: %s", actual)
+	}
+
+	// The rule file is only read for the first object of a plugin
+	if err := os.Remove(rules); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = p.Run(transform.PluginRequest{
+		Unstructured: object,
+		Extras: map[string]string{
+			kubernetes.RegistryReplacementFlag: "quay.io=dockerhub.io",
+			kubernetes.ImageRulesFlag:          rules,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := internaljsonpatch.Equal(resp.Patches, expectPatch); !ok || err != nil {
+		actual, _ := json.Marshal(resp.Patches)
+		t.Errorf("Invalid patches with the cached rules. Actual: %s", actual)
+	}
+
+	// A file that fails to load is read again for the next object
+	missing := filepath.Join(dir, "missing.yaml")
+	_, err = p.Run(transform.PluginRequest{
+		Unstructured: object,
+		Extras:       map[string]string{kubernetes.ImageRulesFlag: missing},
+	})
+	if err == nil {
+		t.Error("expected an error for a missing image rule file")
+	}
+	err = ioutil.WriteFile(missing, []byte("rules:\n- match: quay.io/(.*):.*\n  replace: mirror.example.com/$1\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = p.Run(transform.PluginRequest{
+		Unstructured: object,
+		Extras:       map[string]string{kubernetes.ImageRulesFlag: missing},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPatch, err = jsonpatch.DecodePatch([]byte(`[{"op": "replace", "path": "/spec/template/spec/containers/0/image", "value": "mirror.example.com/shawn_hurley/testing-image"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := internaljsonpatch.Equal(resp.Patches, expectPatch); !ok || err != nil {
+		actual, _ := json.Marshal(resp.Patches)
+		t.Errorf("Invalid patches once the rule file exists. Actual: %s", actual)
+	}
+
+	// Another plugin reads the file again
+	_, err = (&kubernetes.KubernetesTransformPlugin{}).Run(transform.PluginRequest{
+		Unstructured: object,
+		Extras:       map[string]string{kubernetes.ImageRulesFlag: rules},
+	})
+	if err == nil {
+		t.Error("expected an error for a removed image rule file")
+	}
+}
+
+func TestRunNamespaceMapFlag(t *testing.T) {
+	object := unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"kind":       "Service",
+			"apiVersion": "v1",
+			"metadata": map[string]interface{}{
+				"name":      "web",
+				"namespace": "source",
+			},
+		},
+	}
+	p := &kubernetes.KubernetesTransformPlugin{}
+	resp, err := p.Run(transform.PluginRequest{
+		Unstructured: object,
+		Extras:       map[string]string{kubernetes.NamespaceMapFlag: "source=destination"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPatch, err := jsonpatch.DecodePatch([]byte(`[{"op": "replace", "path": "/metadata/namespace", "value": "destination"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := internaljsonpatch.Equal(resp.Patches, expectPatch); !ok || err != nil {
+		actual, _ := json.Marshal(resp.Patches)
+		t.Errorf("Invalid patches. Actual: %s", actual)
+	}
+
+	_, err = p.Run(transform.PluginRequest{
+		Unstructured: object,
+		Extras:       map[string]string{kubernetes.NamespaceMapFlag: "source=Invalid_Namespace"},
+	})
+	if err == nil {
+		t.Error("expected an error for an invalid namespace")
+	}
+}
@@ -0,0 +1,288 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	transform "github.com/konveyor/crane-lib/transform"
+	ijsonpatch "github.com/konveyor/crane-lib/transform/internal/jsonpatch"
+	"github.com/konveyor/crane-lib/transform/types"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// namespaceNameLabel is set by Kubernetes on every namespace to its name.
+	namespaceNameLabel      = "kubernetes.io/metadata.name"
+	serviceAccountUser      = "system:serviceaccount:"
+	serviceAccountsGroup    = "system:serviceaccounts:"
+	metadataNamespace       = "/metadata/namespace"
+	subjectName             = "/subjects/%d/name"
+	containerEnvValue       = "/env/%d/value"
+	networkPolicyPeerString = "/spec/%v/%d/%v/%d/namespaceSelector"
+)
+
+// serviceDNSName matches the <namespace>.svc part of the DNS names of services
+// and of their pods, such as db.prod.svc or db-0.db.prod.svc.cluster.local.
+// The svc label must end the name or be followed by a dot, so that names such
+// as x.prod.svc-foo are left alone.
+var serviceDNSName = regexp.MustCompile(`[a-z0-9]\.([a-z0-9](?:[-a-z0-9]*[a-z0-9])?)\.svc(?:\.|$|[^-a-z0-9])`)
+
+// parseNamespaceMap parses the namespace-map option, every namespace must be a
+// valid namespace name.
+func parseNamespaceMap(value string) (map[string]string, error) {
+	namespaceMap, err := transform.ParseMap(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace map: %v", err)
+	}
+	for source, destination := range namespaceMap {
+		for _, namespace := range []string{source, destination} {
+			if errs := validation.IsDNS1123Label(namespace); len(errs) != 0 {
+				return nil, fmt.Errorf("invalid namespace map: %v=%v, %v", source, destination, strings.Join(errs, ","))
+			}
+		}
+	}
+	return namespaceMap, nil
+}
+
+// getNamespaceTransforms moves the object, and the namespaced references it
+// holds, to the namespaces of the NamespaceMap.
+func (k *KubernetesTransformPlugin) getNamespaceTransforms(obj unstructured.Unstructured) (jsonpatch.Patch, error) {
+	jsonPatch := jsonpatch.Patch{}
+	if namespace, ok := k.mappedNamespace(obj.GetNamespace()); ok {
+		patch, err := replaceOperation(metadataNamespace, namespace)
+		if err != nil {
+			return nil, err
+		}
+		jsonPatch = append(jsonPatch, patch...)
+	}
+
+	var patches jsonpatch.Patch
+	var err error
+	switch obj.GroupVersionKind().GroupKind() {
+	case roleBindingGK, clusterRoleBindingGK:
+		patches, err = k.mapSubjects(obj)
+	case configMapGK:
+		patches, err = k.mapConfigMap(obj)
+	case networkPolicyGK:
+		patches, err = k.mapNetworkPolicy(obj)
+	}
+	if err != nil {
+		return nil, err
+	}
+	jsonPatch = append(jsonPatch, patches...)
+
+	podSpecs, err := types.PodSpecs(obj)
+	if err != nil {
+		return nil, err
+	}
+	for _, podSpec := range podSpecs {
+		for _, container := range podSpec.Containers() {
+			for i, env := range container.Env {
+				value, mapped := k.mapServiceDNSNames(env.Value)
+				if !mapped {
+					continue
+				}
+				patch, err := replaceOperation(container.Path+fmt.Sprintf(containerEnvValue, i), value)
+				if err != nil {
+					return nil, err
+				}
+				jsonPatch = append(jsonPatch, patch...)
+			}
+		}
+	}
+	return jsonPatch, nil
+}
+
+// mapSubjects maps the namespaces of the ServiceAccount subjects of a
+// RoleBinding or ClusterRoleBinding, including the ones referred to by their
+// user and group names.
+func (k *KubernetesTransformPlugin) mapSubjects(obj unstructured.Unstructured) (jsonpatch.Patch, error) {
+	js, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	// RoleBindings and ClusterRoleBindings share their subjects
+	rb := &rbacv1.RoleBinding{}
+	err = json.Unmarshal(js, rb)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonPatch := jsonpatch.Patch{}
+	for i, subj := range rb.Subjects {
+		path, value := "", ""
+		switch {
+		case subj.Kind == rbacv1.ServiceAccountKind:
+			if namespace, ok := k.mappedNamespace(subj.Namespace); ok {
+				path, value = fmt.Sprintf(roleBindingSubject, i), namespace
+			}
+		case subj.Kind == rbacv1.UserKind && strings.HasPrefix(subj.Name, serviceAccountUser):
+			// system:serviceaccount:<namespace>:<name>
+			parts := strings.SplitN(strings.TrimPrefix(subj.Name, serviceAccountUser), ":", 2)
+			if namespace, ok := k.mappedNamespace(parts[0]); ok && len(parts) == 2 {
+				path, value = fmt.Sprintf(subjectName, i), serviceAccountUser+namespace+":"+parts[1]
+			}
+		case subj.Kind == rbacv1.GroupKind && strings.HasPrefix(subj.Name, serviceAccountsGroup):
+			// system:serviceaccounts:<namespace>
+			if namespace, ok := k.mappedNamespace(strings.TrimPrefix(subj.Name, serviceAccountsGroup)); ok {
+				path, value = fmt.Sprintf(subjectName, i), serviceAccountsGroup+namespace
+			}
+		}
+		if path == "" {
+			continue
+		}
+		patch, err := replaceOperation(path, value)
+		if err != nil {
+			return nil, err
+		}
+		jsonPatch = append(jsonPatch, patch...)
+	}
+	return jsonPatch, nil
+}
+
+// mapConfigMap maps the service DNS names found in the data of a ConfigMap.
+func (k *KubernetesTransformPlugin) mapConfigMap(obj unstructured.Unstructured) (jsonpatch.Patch, error) {
+	js, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	cm := &v1.ConfigMap{}
+	err = json.Unmarshal(js, cm)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for key := range cm.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	jsonPatch := jsonpatch.Patch{}
+	for _, key := range keys {
+		value, mapped := k.mapServiceDNSNames(cm.Data[key])
+		if !mapped {
+			continue
+		}
+		patch, err := replaceOperation(ijsonpatch.JoinPath([]string{"data", key}), value)
+		if err != nil {
+			return nil, err
+		}
+		jsonPatch = append(jsonPatch, patch...)
+	}
+	return jsonPatch, nil
+}
+
+// mapNetworkPolicy maps the namespaces selected by name in the peers of a
+// NetworkPolicy, through the kubernetes.io/metadata.name label.
+func (k *KubernetesTransformPlugin) mapNetworkPolicy(obj unstructured.Unstructured) (jsonpatch.Patch, error) {
+	js, err := obj.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	np := &networkingv1.NetworkPolicy{}
+	err = json.Unmarshal(js, np)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonPatch := jsonpatch.Patch{}
+	for i, rule := range np.Spec.Ingress {
+		for j, peer := range rule.From {
+			patches, err := k.mapNamespaceSelector(peer.NamespaceSelector, fmt.Sprintf(networkPolicyPeerString, "ingress", i, "from", j))
+			if err != nil {
+				return nil, err
+			}
+			jsonPatch = append(jsonPatch, patches...)
+		}
+	}
+	for i, rule := range np.Spec.Egress {
+		for j, peer := range rule.To {
+			patches, err := k.mapNamespaceSelector(peer.NamespaceSelector, fmt.Sprintf(networkPolicyPeerString, "egress", i, "to", j))
+			if err != nil {
+				return nil, err
+			}
+			jsonPatch = append(jsonPatch, patches...)
+		}
+	}
+	return jsonPatch, nil
+}
+
+func (k *KubernetesTransformPlugin) mapNamespaceSelector(selector *metav1.LabelSelector, path string) (jsonpatch.Patch, error) {
+	jsonPatch := jsonpatch.Patch{}
+	if selector == nil {
+		return jsonPatch, nil
+	}
+	if namespace, ok := k.mappedNamespace(selector.MatchLabels[namespaceNameLabel]); ok {
+		patch, err := replaceOperation(path+ijsonpatch.JoinPath([]string{"matchLabels", namespaceNameLabel}), namespace)
+		if err != nil {
+			return nil, err
+		}
+		jsonPatch = append(jsonPatch, patch...)
+	}
+	for i, expression := range selector.MatchExpressions {
+		if expression.Key != namespaceNameLabel {
+			continue
+		}
+		for j, value := range expression.Values {
+			namespace, ok := k.mappedNamespace(value)
+			if !ok {
+				continue
+			}
+			patch, err := replaceOperation(fmt.Sprintf("%v/matchExpressions/%d/values/%d", path, i, j), namespace)
+			if err != nil {
+				return nil, err
+			}
+			jsonPatch = append(jsonPatch, patch...)
+		}
+	}
+	return jsonPatch, nil
+}
+
+// mappedNamespace returns the namespace the namespace is mapped to.
+func (k *KubernetesTransformPlugin) mappedNamespace(namespace string) (string, bool) {
+	if namespace == "" {
+		return "", false
+	}
+	mapped, ok := k.NamespaceMap[namespace]
+	return mapped, ok
+}
+
+// mapServiceDNSNames maps the namespaces of the service DNS names in the
+// value, and returns whether any was mapped.
+func (k *KubernetesTransformPlugin) mapServiceDNSNames(value string) (string, bool) {
+	mapped := false
+	out := strings.Builder{}
+	last := 0
+	for _, match := range serviceDNSName.FindAllStringSubmatchIndex(value, -1) {
+		namespace, ok := k.mappedNamespace(value[match[2]:match[3]])
+		if !ok {
+			continue
+		}
+		out.WriteString(value[last:match[2]])
+		out.WriteString(namespace)
+		last = match[3]
+		mapped = true
+	}
+	if !mapped {
+		return value, false
+	}
+	out.WriteString(value[last:])
+	return out.String(), true
+}
+
+func replaceOperation(path string, value string) (jsonpatch.Patch, error) {
+	operation, err := ijsonpatch.NewOperation("replace", path, value)
+	if err != nil {
+		return nil, err
+	}
+	return jsonpatch.Patch{operation}, nil
+}
@@ -0,0 +1,340 @@
+package plugin_manager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/konveyor/crane-lib/transform"
+	binary_plugin "github.com/konveyor/crane-lib/transform/binary-plugin"
+	"github.com/sirupsen/logrus"
+	errorsutil "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// Manager finds the binary plugins in a list of directories.
+type Manager struct {
+	// Dirs are scanned, not recursively, for executable files. Hidden files
+	// are ignored.
+	Dirs []string
+	// CacheFile keeps the metadata of the plugins keyed by the SHA-256
+	// checksum of their binary, so the metadata command is only run for new
+	// or changed binaries. No cache is used when empty.
+	CacheFile string
+	// Manifest selects the plugins returned by Load and their priorities.
+	// Every plugin found is returned when nil.
+	Manifest *Manifest
+	// Options are passed to every binary plugin.
+	Options []binary_plugin.PluginOption
+	Log     *logrus.Logger
+}
+
+// cache is the content of the CacheFile.
+type cache struct {
+	Plugins map[string]transform.PluginMetadata `json:"plugins"`
+}
+
+// Load returns the plugins selected by the Manifest, in the order of the
+// manifest, along with their PluginPriorities for the transform.Runner. It
+// fails when a plugin that is not optional is missing or when a plugin does
+// not meet its version constraint. Without a Manifest every plugin found is
+// returned, sorted by name, and the priorities follow the same order.
+//
+// With a Manifest only the binaries that may provide one of its plugins are
+// run, see ManifestPlugin.Binary, and binaries that fail to load are logged
+// and skipped. Their errors are only returned when a plugin that is not
+// optional is missing.
+func (m *Manager) Load() ([]transform.Plugin, map[string]int, error) {
+	if m.Manifest == nil {
+		plugins, err := m.Discover()
+		if err != nil {
+			return nil, nil, err
+		}
+		priorities := make(map[string]int, len(plugins))
+		for i, plugin := range plugins {
+			priorities[plugin.Metadata().Name] = i
+		}
+		return plugins, priorities, nil
+	}
+
+	plugins, failures, err := m.discover(newSelection(m.Manifest))
+	if err != nil {
+		return nil, nil, err
+	}
+	byName := make(map[string]transform.Plugin, len(plugins))
+	for _, plugin := range plugins {
+		byName[plugin.Metadata().Name] = plugin
+	}
+	selected := []transform.Plugin{}
+	priorities := map[string]int{}
+	errs := []error{}
+	missing := false
+	for _, manifestPlugin := range m.Manifest.Plugins {
+		plugin, ok := byName[manifestPlugin.Name]
+		if !ok {
+			if manifestPlugin.Optional {
+				m.logger().Infof("optional plugin %v not found", manifestPlugin.Name)
+			} else {
+				errs = append(errs, fmt.Errorf("plugin %v not found", manifestPlugin.Name))
+				missing = true
+			}
+			continue
+		}
+		if err := checkVersion(manifestPlugin, plugin.Metadata().Version); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		priorities[manifestPlugin.Name] = len(selected)
+		selected = append(selected, plugin)
+	}
+	if missing {
+		// One of the binaries that failed may be the missing plugin
+		errs = append(errs, failures...)
+	}
+	if len(errs) > 0 {
+		return nil, nil, errorsutil.NewAggregate(errs)
+	}
+	return selected, priorities, nil
+}
+
+// Discover returns every plugin found in the Dirs, sorted by name. It fails
+// when two binaries have the same plugin name or when the metadata of a
+// binary cannot be read.
+func (m *Manager) Discover() ([]transform.Plugin, error) {
+	plugins, failures, err := m.discover(nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(failures) > 0 {
+		return nil, errorsutil.NewAggregate(failures)
+	}
+	return plugins, nil
+}
+
+// selection restricts the binaries run by discover to the ones that may
+// provide a plugin of the Manifest.
+type selection struct {
+	// binaryFor maps the plugin names of the manifest to their Binary.
+	binaryFor map[string]string
+	// binaries are the file names given in the manifest.
+	binaries map[string]bool
+	// unnamed is true when a plugin of the manifest has no Binary, every
+	// binary missing from the cache has to be run to find it.
+	unnamed bool
+}
+
+func newSelection(manifest *Manifest) *selection {
+	s := &selection{binaryFor: map[string]string{}, binaries: map[string]bool{}}
+	for _, plugin := range manifest.Plugins {
+		s.binaryFor[plugin.Name] = plugin.Binary
+		if plugin.Binary == "" {
+			s.unnamed = true
+		} else {
+			s.binaries[plugin.Binary] = true
+		}
+	}
+	return s
+}
+
+// mayProvide returns true when the binary at path may provide a plugin of
+// the selection, the metadata is nil when it is not known yet.
+func (s *selection) mayProvide(path string, metadata *transform.PluginMetadata) bool {
+	if s == nil {
+		return true
+	}
+	if metadata != nil {
+		binary, ok := s.binaryFor[metadata.Name]
+		return ok && (binary == "" || binary == filepath.Base(path))
+	}
+	return s.unnamed || s.binaries[filepath.Base(path)]
+}
+
+// discover returns the plugins found in the Dirs, sorted by name, along with
+// the errors of the binaries that could not be loaded. With a selection only
+// the binaries that may provide one of its plugins are run and returned.
+func (m *Manager) discover(sel *selection) ([]transform.Plugin, []error, error) {
+	log := m.logger()
+	paths, err := m.binaries()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := m.loadCache()
+	used := map[string]transform.PluginMetadata{}
+	plugins := []transform.Plugin{}
+	pathsByName := map[string][]string{}
+	failures := []error{}
+	for _, path := range paths {
+		checksum, err := Checksum(path)
+		if err != nil {
+			failures = append(failures, err)
+			continue
+		}
+		opts := append([]binary_plugin.PluginOption{}, m.Options...)
+		metadata, cached := c.Plugins[checksum]
+		if cached {
+			used[checksum] = metadata
+			if !sel.mayProvide(path, &metadata) {
+				log.Debugf("skipping plugin %v, %v is not in the manifest", path, metadata.Name)
+				continue
+			}
+			opts = append(opts, binary_plugin.KnownMetadata(metadata))
+		} else {
+			if !sel.mayProvide(path, nil) {
+				log.Debugf("skipping plugin %v, it is not in the manifest", path)
+				continue
+			}
+			log.Debugf("reading the metadata of plugin %v", path)
+		}
+		plugin, err := binary_plugin.NewBinaryPlugin(path, log, opts...)
+		if err != nil {
+			err = fmt.Errorf("unable to load plugin %v: %v", path, err)
+			if sel != nil {
+				log.Warnf("skipping %v", err)
+			}
+			failures = append(failures, err)
+			continue
+		}
+		metadata = plugin.Metadata()
+		used[checksum] = metadata
+		if !sel.mayProvide(path, &metadata) {
+			log.Debugf("skipping plugin %v, %v is not in the manifest", path, metadata.Name)
+			continue
+		}
+		pathsByName[metadata.Name] = append(pathsByName[metadata.Name], path)
+		plugins = append(plugins, plugin)
+	}
+
+	errs := []error{}
+	for name, namePaths := range pathsByName {
+		if len(namePaths) > 1 {
+			errs = append(errs, fmt.Errorf("plugin %v is provided by more than one binary: %v", name, strings.Join(namePaths, ", ")))
+		}
+	}
+	// Only the entries of the binaries found are kept, so the cache does not
+	// grow with every upgrade of a plugin.
+	if err := m.saveCache(cache{Plugins: used}); err != nil {
+		log.Warnf("unable to save the plugin cache %v: %v", m.CacheFile, err)
+	}
+	if len(errs) > 0 {
+		return nil, nil, errorsutil.NewAggregate(errs)
+	}
+
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Metadata().Name < plugins[j].Metadata().Name
+	})
+	return plugins, failures, nil
+}
+
+// binaries returns the path of the executable files in the Dirs. Missing
+// directories are skipped.
+func (m *Manager) binaries() ([]string, error) {
+	paths := []string{}
+	for _, dir := range m.Dirs {
+		files, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			m.logger().Debugf("plugin directory %v does not exist", dir)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read plugin directory %v: %v", dir, err)
+		}
+		for _, file := range files {
+			path := filepath.Join(dir, file.Name())
+			if strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+			// Follow symlinks, plugins are often linked from where they are
+			// installed.
+			info, err := os.Stat(path)
+			if err != nil {
+				m.logger().Warnf("skipping plugin %v: %v", path, err)
+				continue
+			}
+			if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+				continue
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// Checksum returns the hex encoded SHA-256 checksum of a file.
+func Checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read plugin %v: %v", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("unable to read plugin %v: %v", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadCache reads the CacheFile. A missing or invalid cache is empty.
+func (m *Manager) loadCache() cache {
+	c := cache{Plugins: map[string]transform.PluginMetadata{}}
+	if m.CacheFile == "" {
+		return c
+	}
+	data, err := ioutil.ReadFile(m.CacheFile)
+	if os.IsNotExist(err) {
+		return c
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		m.logger().Warnf("ignoring the plugin cache %v: %v", m.CacheFile, err)
+		return cache{Plugins: map[string]transform.PluginMetadata{}}
+	}
+	if c.Plugins == nil {
+		c.Plugins = map[string]transform.PluginMetadata{}
+	}
+	return c
+}
+
+// saveCache writes the CacheFile through a temporary file, so that a
+// concurrent Discover never reads a partial cache.
+func (m *Manager) saveCache(c cache) error {
+	if m.CacheFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(m.CacheFile)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(m.CacheFile))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.CacheFile)
+}
+
+func (m *Manager) logger() *logrus.Logger {
+	if m.Log == nil {
+		return logrus.New()
+	}
+	return m.Log
+}
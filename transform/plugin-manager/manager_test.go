@@ -0,0 +1,285 @@
+package plugin_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/konveyor/crane-lib/transform"
+	"github.com/sirupsen/logrus"
+)
+
+// writePlugin writes a shell script plugin to dir that records every run of
+// its metadata command in calls.
+func writePlugin(t *testing.T, dir, file, name, version, calls string) {
+	t.Helper()
+	script := fmt.Sprintf(`#!/bin/sh
+cat > /dev/null
+echo run >> %v
+echo '{"name": "%v", "version": "%v", "requestVersion": ["v1"], "responseVersion": ["v1"]}'
+`, calls, name, version)
+	if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countCalls(t *testing.T, calls string) int {
+	t.Helper()
+	data, err := ioutil.ReadFile(calls)
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "run")
+}
+
+func pluginNames(plugins []transform.Plugin) []string {
+	names := []string{}
+	for _, plugin := range plugins {
+		names = append(names, plugin.Metadata().Name)
+	}
+	return names
+}
+
+func TestManagerDiscover(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "plugin-manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir1, dir2 := filepath.Join(tmp, "dir1"), filepath.Join(tmp, "dir2")
+	for _, dir := range []string{dir1, dir2} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	calls := filepath.Join(tmp, "calls")
+	writePlugin(t, dir1, "b-plugin", "b", "v1", calls)
+	writePlugin(t, dir2, "a-plugin", "a", "v1.2.0", calls)
+	// Not plugins
+	writePlugin(t, dir1, ".hidden", "hidden", "v1", calls)
+	if err := ioutil.WriteFile(filepath.Join(dir1, "README"), []byte("not a plugin"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := &Manager{
+		Dirs:      []string{dir1, dir2, filepath.Join(tmp, "missing")},
+		CacheFile: filepath.Join(tmp, "cache", "plugins.json"),
+		Log:       logrus.New(),
+	}
+	plugins, err := manager.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := pluginNames(plugins); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("Discover() got = %v, want [a b]", names)
+	}
+	if n := countCalls(t, calls); n != 2 {
+		t.Errorf("expected 2 metadata calls, got %v", n)
+	}
+
+	// The cache is used for unchanged binaries
+	plugins, err = manager.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := pluginNames(plugins); !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("Discover() got = %v, want [a b]", names)
+	}
+	if n := countCalls(t, calls); n != 2 {
+		t.Errorf("expected the metadata to be cached, got %v calls", n)
+	}
+
+	// A changed binary is read again
+	writePlugin(t, dir1, "b-plugin", "b", "v2", calls)
+	plugins, err = manager.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countCalls(t, calls); n != 3 {
+		t.Errorf("expected 3 metadata calls, got %v", n)
+	}
+	if version := plugins[1].Metadata().Version; version != "v2" {
+		t.Errorf("expected version v2 of plugin b, got %v", version)
+	}
+
+	// Duplicate names
+	writePlugin(t, dir2, "other-b-plugin", "b", "v1", calls)
+	_, err = manager.Discover()
+	if err == nil || !strings.Contains(err.Error(), "more than one binary") {
+		t.Errorf("expected a duplicate plugin error, got: %v", err)
+	}
+}
+
+func TestManagerLoad(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "plugin-manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	calls := filepath.Join(tmp, "calls")
+	writePlugin(t, tmp, "a-plugin", "a", "v1.2.0", calls)
+	writePlugin(t, tmp, "b-plugin", "b", "v1", calls)
+	writePlugin(t, tmp, "c-plugin", "c", "0.3.1", calls)
+
+	cases := []struct {
+		Name               string
+		Manifest           string
+		ExpectedPlugins    []string
+		ExpectedPriorities map[string]int
+		ShouldErr          bool
+	}{
+		{
+			Name:               "NoManifest",
+			ExpectedPlugins:    []string{"a", "b", "c"},
+			ExpectedPriorities: map[string]int{"a": 0, "b": 1, "c": 2},
+		},
+		{
+			Name: "Manifest",
+			Manifest: `
+plugins:
+- name: c
+  version: ">=0.3.0, <1.0"
+- name: missing
+  optional: true
+- name: a
+  version: v1.2.0
+`,
+			ExpectedPlugins:    []string{"c", "a"},
+			ExpectedPriorities: map[string]int{"c": 0, "a": 1},
+		},
+		{
+			Name: "MajorVersion",
+			Manifest: `
+plugins:
+- name: b
+  version: ">=1, <2"
+`,
+			ExpectedPlugins:    []string{"b"},
+			ExpectedPriorities: map[string]int{"b": 0},
+		},
+		{
+			Name: "MissingRequired",
+			Manifest: `
+plugins:
+- name: a
+- name: missing
+`,
+			ShouldErr: true,
+		},
+		{
+			Name: "VersionMismatch",
+			Manifest: `
+plugins:
+- name: a
+  version: "> 1.2.0"
+`,
+			ShouldErr: true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			manager := &Manager{Dirs: []string{tmp}, Log: logrus.New()}
+			if c.Manifest != "" {
+				manifest, err := ParseManifest([]byte(c.Manifest))
+				if err != nil {
+					t.Fatal(err)
+				}
+				manager.Manifest = manifest
+			}
+			plugins, priorities, err := manager.Load()
+			if (err != nil) != c.ShouldErr {
+				t.Fatalf("Load() error = %v, shouldErr %v", err, c.ShouldErr)
+			}
+			if c.ShouldErr {
+				return
+			}
+			if names := pluginNames(plugins); !reflect.DeepEqual(names, c.ExpectedPlugins) {
+				t.Errorf("Load() plugins got = %v, want %v", names, c.ExpectedPlugins)
+			}
+			if !reflect.DeepEqual(priorities, c.ExpectedPriorities) {
+				t.Errorf("Load() priorities got = %v, want %v", priorities, c.ExpectedPriorities)
+			}
+		})
+	}
+}
+
+func TestManagerLoadManifestBinaries(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "plugin-manager")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	calls := filepath.Join(tmp, "calls")
+	writePlugin(t, tmp, "a-plugin", "a", "v1", calls)
+	writePlugin(t, tmp, "b-plugin", "b", "v1", calls)
+	if err := ioutil.WriteFile(filepath.Join(tmp, "broken"), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func(manifest string) ([]transform.Plugin, error) {
+		parsed, err := ParseManifest([]byte(manifest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		manager := &Manager{Dirs: []string{tmp}, Manifest: parsed, Log: logrus.New()}
+		plugins, _, err := manager.Load()
+		return plugins, err
+	}
+
+	// Only the named binary is run
+	plugins, err := load(`{"plugins": [{"name": "a", "binary": "a-plugin"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := pluginNames(plugins); !reflect.DeepEqual(names, []string{"a"}) {
+		t.Errorf("Load() plugins got = %v, want [a]", names)
+	}
+	if n := countCalls(t, calls); n != 1 {
+		t.Errorf("expected 1 metadata call, got %v", n)
+	}
+
+	// A broken binary that is not needed is skipped
+	plugins, err = load(`{"plugins": [{"name": "b"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := pluginNames(plugins); !reflect.DeepEqual(names, []string{"b"}) {
+		t.Errorf("Load() plugins got = %v, want [b]", names)
+	}
+
+	// It is reported when a required plugin is missing
+	_, err = load(`{"plugins": [{"name": "b"}, {"name": "missing"}]}`)
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected the error of the broken binary, got: %v", err)
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	cases := []struct {
+		Name      string
+		Manifest  string
+		ShouldErr bool
+	}{
+		{Name: "Valid", Manifest: `{"plugins": [{"name": "a", "version": "!=1.0.1"}]}`},
+		{Name: "UnknownField", Manifest: `{"plugins": [{"name": "a", "priority": 1}]}`, ShouldErr: true},
+		{Name: "NoName", Manifest: `{"plugins": [{"version": "1.0"}]}`, ShouldErr: true},
+		{Name: "Duplicate", Manifest: `{"plugins": [{"name": "a"}, {"name": "a"}]}`, ShouldErr: true},
+		{Name: "InvalidConstraint", Manifest: `{"plugins": [{"name": "a", "version": ">=latest"}]}`, ShouldErr: true},
+		{Name: "BinaryPath", Manifest: `{"plugins": [{"name": "a", "binary": "bin/a-plugin"}]}`, ShouldErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			_, err := ParseManifest([]byte(c.Manifest))
+			if (err != nil) != c.ShouldErr {
+				t.Errorf("ParseManifest() error = %v, shouldErr %v", err, c.ShouldErr)
+			}
+		})
+	}
+}
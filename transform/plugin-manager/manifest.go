@@ -0,0 +1,159 @@
+package plugin_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/version"
+	"sigs.k8s.io/yaml"
+)
+
+// Manifest selects the plugins to load and their priorities, in YAML or JSON:
+//
+//	plugins:
+//	- name: KubernetesPlugin
+//	  version: ">=0.0.3, <1.0.0"
+//	- name: OpenShiftPlugin
+//	  binary: openshift-plugin
+//	  optional: true
+//
+// Plugins are listed from the highest to the lowest priority.
+type Manifest struct {
+	Plugins []ManifestPlugin `json:"plugins"`
+}
+
+// ManifestPlugin is a plugin of the Manifest.
+type ManifestPlugin struct {
+	// Name is the name in the metadata of the plugin.
+	Name string `json:"name"`
+	// Version is a comma separated list of constraints the version of the
+	// plugin must meet, each one an operator (=, !=, >, >=, <, <=) followed by
+	// a version. A version alone must be matched exactly.
+	Version string `json:"version,omitempty"`
+	// Optional plugins are skipped when they are not found.
+	Optional bool `json:"optional,omitempty"`
+	// Binary is the file name of the binary providing the plugin in the
+	// plugin directories. When every plugin of the manifest has a Binary,
+	// binaries that are not named are never run.
+	Binary string `json:"binary,omitempty"`
+}
+
+// LoadManifest reads a manifest file.
+func LoadManifest(file string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read plugin manifest %v: %v", file, err)
+	}
+	manifest, err := ParseManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin manifest %v: %v", file, err)
+	}
+	return manifest, nil
+}
+
+// ParseManifest parses a manifest and validates its version constraints.
+func ParseManifest(data []byte) (*Manifest, error) {
+	manifest := &Manifest{}
+	if err := yaml.UnmarshalStrict(data, manifest); err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, plugin := range manifest.Plugins {
+		if plugin.Name == "" {
+			return nil, fmt.Errorf("plugin without a name")
+		}
+		if names[plugin.Name] {
+			return nil, fmt.Errorf("plugin %v is listed more than once", plugin.Name)
+		}
+		if strings.ContainsRune(plugin.Binary, filepath.Separator) {
+			return nil, fmt.Errorf("plugin %v: binary %v must be a file name", plugin.Name, plugin.Binary)
+		}
+		names[plugin.Name] = true
+		if _, err := parseConstraints(plugin.Version); err != nil {
+			return nil, fmt.Errorf("plugin %v: %v", plugin.Name, err)
+		}
+	}
+	return manifest, nil
+}
+
+// constraint is a single version constraint, such as >=1.2.0.
+type constraint struct {
+	operator string
+	version  *version.Version
+}
+
+// operators are ordered so that the longest operators are matched first.
+var operators = []string{">=", "<=", "!=", ">", "<", "="}
+
+func parseConstraints(s string) ([]constraint, error) {
+	constraints := []constraint{}
+	if strings.TrimSpace(s) == "" {
+		return constraints, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		operator := "="
+		for _, o := range operators {
+			if strings.HasPrefix(part, o) {
+				operator = o
+				part = strings.TrimSpace(strings.TrimPrefix(part, o))
+				break
+			}
+		}
+		v, err := parseVersion(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q: %v", s, err)
+		}
+		constraints = append(constraints, constraint{operator: operator, version: v})
+	}
+	return constraints, nil
+}
+
+// parseVersion parses a generic version such as v1.2 or 1.2.3-rc.1. A
+// single number, such as the v1 of most plugins, is a major version.
+func parseVersion(s string) (*version.Version, error) {
+	if _, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(s), "v"), 10, 0); err == nil {
+		s = strings.TrimSpace(s) + ".0"
+	}
+	return version.ParseGeneric(s)
+}
+
+// matches returns true when v meets the constraint.
+func (c constraint) matches(v *version.Version) bool {
+	switch c.operator {
+	case ">=":
+		return v.AtLeast(c.version)
+	case "<=":
+		return !c.version.LessThan(v)
+	case ">":
+		return c.version.LessThan(v)
+	case "<":
+		return v.LessThan(c.version)
+	case "!=":
+		return v.LessThan(c.version) || c.version.LessThan(v)
+	default:
+		return !v.LessThan(c.version) && !c.version.LessThan(v)
+	}
+}
+
+// checkVersion returns an error when the plugin version does not meet the
+// constraints of the manifest.
+func checkVersion(plugin ManifestPlugin, pluginVersion string) error {
+	constraints, err := parseConstraints(plugin.Version)
+	if err != nil || len(constraints) == 0 {
+		return err
+	}
+	v, err := parseVersion(pluginVersion)
+	if err != nil {
+		return fmt.Errorf("plugin %v has an invalid version %q: %v", plugin.Name, pluginVersion, err)
+	}
+	for _, c := range constraints {
+		if !c.matches(v) {
+			return fmt.Errorf("plugin %v version %v does not meet the constraint %q", plugin.Name, pluginVersion, plugin.Version)
+		}
+	}
+	return nil
+}
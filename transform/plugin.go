@@ -1,10 +1,14 @@
 package transform
 
 import (
+	"context"
+	"encoding/json"
 	"strings"
 
 	jsonpatch "github.com/evanphx/json-patch"
+	cranerrors "github.com/konveyor/crane-lib/transform/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 type PluginRun interface {
@@ -12,6 +16,12 @@ type PluginRun interface {
 	Run(PluginRequest) (PluginResponse, error)
 }
 
+// PluginRunWithContext is implemented by plugins that can stop running when
+// the context is done, the Runner uses it instead of Run when available.
+type PluginRunWithContext interface {
+	RunWithContext(context.Context, PluginRequest) (PluginResponse, error)
+}
+
 type Metadata interface {
 	Metadata() PluginMetadata
 }
@@ -24,12 +34,35 @@ type Plugin interface {
 type PluginRequest struct {
 	unstructured.Unstructured `json:",inline"`
 	Extras map[string]string  `json:"extras,omitempty"`
+	// Version is the protocol version negotiated with the plugin. Binary
+	// plugins receive it in the RequestVersionKey field when it is not V1.
+	Version Version `json:"-"`
+	// Resources is the read-only view of every object being transformed.
+	// Binary plugins only receive the objects selected by their
+	// ContextRequest.
+	Resources *ResourceIndex `json:"-"`
 }
 
 type PluginResponse struct {
 	Version    string          `json:"version,omitempty"`
 	IsWhiteOut bool            `json:"isWhiteOut,omitempty"`
 	Patches    jsonpatch.Patch `json:"patches,omitempty"`
+	// Warnings are logged and returned to the caller of the Runner. Requires V2.
+	Warnings []string `json:"warnings,omitempty"`
+	// Annotations describe the decisions the plugin made about the object,
+	// they are informational and are not added to the object. Requires V2.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// NewResources are additional objects to create alongside the object
+	// being transformed, they are kept even if the object is whited out.
+	// Requires V2.
+	NewResources []unstructured.Unstructured `json:"newResources,omitempty"`
+	// MergePatch is a JSON merge patch (RFC 7386) for the object. Requires V2.
+	MergePatch json.RawMessage `json:"mergePatch,omitempty"`
+	// StrategicMergePatch is a Kubernetes strategic merge patch for the
+	// object, lists such as containers are merged by their key instead of
+	// their index. Objects of types not known to client-go get a JSON merge
+	// patch instead. Requires V2.
+	StrategicMergePatch json.RawMessage `json:"strategicMergePatch,omitempty"`
 }
 
 type PluginMetadata struct {
@@ -38,6 +71,28 @@ type PluginMetadata struct {
 	RequestVersion  []Version        `json:"requestVersion"`
 	ResponseVersion []Version        `json:"responseVersion"`
 	OptionalFields  []OptionalFields `json:"optionalFields,omitempty"`
+	// GroupKinds limits the objects the plugin is called for, when empty the
+	// plugin is called for every object. Requires V2.
+	GroupKinds []schema.GroupKind `json:"groupKinds,omitempty"`
+	// Persistent is set when the plugin can serve many requests from a single
+	// process, see PersistentModeEnv.
+	Persistent bool `json:"persistent,omitempty"`
+	// ContextRequest selects the related objects sent to a binary plugin
+	// with each object. Requires V2.
+	ContextRequest *ContextRequest `json:"contextRequest,omitempty"`
+}
+
+// PersistentModeEnv is set in the environment of a plugin binary started in
+// persistent mode. The plugin then reads newline delimited requests from stdin
+// and answers each of them with a single StreamResponse line on stdout until
+// stdin is closed.
+const PersistentModeEnv = "CRANE_PLUGIN_PERSISTENT"
+
+// StreamResponse is the answer to a single request in persistent mode, only
+// one of Response and Error is set.
+type StreamResponse struct {
+	Response *PluginResponse         `json:"response,omitempty"`
+	Error    *cranerrors.PluginError `json:"error,omitempty"`
 }
 
 type Version string
@@ -46,10 +101,19 @@ type OptionalFields struct {
 	FlagName string `json:"flagName"`
 	Help     string `json:"help"`
 	Example  string `json:"example"`
+	// Type of the value, fields without a type are strings.
+	Type OptionalFieldType `json:"type,omitempty"`
+	// Required fields must have a value or a Default.
+	Required bool `json:"required,omitempty"`
+	// Default is passed to the plugin when the field has no value.
+	Default string `json:"default,omitempty"`
 }
 
 const (
 	V1 Version = "v1"
+	// V2 lets plugins declare the GroupKinds they handle and return warnings,
+	// annotations and new resources.
+	V2 Version = "v2"
 )
 
 const (
@@ -57,21 +121,73 @@ const (
 	ResponseVersion = V1
 )
 
+// SupportedVersions are the protocol versions understood by this library,
+// from the most to the least preferred.
+var SupportedVersions = []Version{V2, V1}
+
+// RequestVersionKey is the top level field of a binary plugin request that
+// holds the negotiated protocol version. It is omitted for V1.
+const RequestVersionKey = "requestVersion"
+
+// NegotiateVersion returns the most preferred version from SupportedVersions
+// that the plugin accepts for both requests and responses. Plugins that do
+// not declare any versions are treated as V1 plugins.
+func NegotiateVersion(metadata PluginMetadata) (Version, bool) {
+	if len(metadata.RequestVersion) == 0 && len(metadata.ResponseVersion) == 0 {
+		return V1, true
+	}
+	for _, v := range SupportedVersions {
+		if hasVersion(v, metadata.RequestVersion) && hasVersion(v, metadata.ResponseVersion) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func hasVersion(version Version, versions []Version) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// HandlesGroupKind returns true if a plugin speaking the given protocol
+// version should be called for objects of the GroupKind.
+func (m PluginMetadata) HandlesGroupKind(version Version, gk schema.GroupKind) bool {
+	if version == V1 || len(m.GroupKinds) == 0 {
+		return true
+	}
+	for _, handled := range m.GroupKinds {
+		if handled == gk {
+			return true
+		}
+	}
+	return false
+}
+
 const (
 	// Metadata string is the constant string that will be used by the binary-pluigin helper and the cli helpers
 	// To notice that
 	MetadataString string = "METADATA"
 )
 
+// ParseOptionalFieldSliceVal splits the value of a field without a type on
+// every comma. Values are not unquoted, fields declared as
+// FieldTypeStringList are parsed with ParseStringList.
 func ParseOptionalFieldSliceVal(sliceVal string) []string {
-	return strings.Split(sliceVal,",")
+	return strings.Split(sliceVal, ",")
 }
 
+// ParseOptionalFieldMapVal splits the value of a field without a type on every
+// comma and equal sign. Values are not unquoted, fields declared as
+// FieldTypeMap are parsed with ParseMap.
 func ParseOptionalFieldMapVal(sliceVal string) map[string]string {
 	mapVal := make(map[string]string)
-	kvPairs := strings.Split(sliceVal,",")
+	kvPairs := strings.Split(sliceVal, ",")
 	for _, kvPair := range kvPairs {
-		kvSlice := strings.Split(kvPair,"=")
+		kvSlice := strings.Split(kvPair, "=")
 		if len(kvSlice) == 1 {
 			mapVal[kvSlice[0]] = ""
 		} else {
@@ -0,0 +1,73 @@
+package transform
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Metadata PluginMetadata
+		Version  Version
+		Ok       bool
+	}{
+		{
+			Name:     "NoVersionsIsV1",
+			Metadata: PluginMetadata{},
+			Version:  V1,
+			Ok:       true,
+		},
+		{
+			Name:     "V1Only",
+			Metadata: PluginMetadata{RequestVersion: []Version{V1}, ResponseVersion: []Version{V1}},
+			Version:  V1,
+			Ok:       true,
+		},
+		{
+			Name:     "PrefersV2",
+			Metadata: PluginMetadata{RequestVersion: []Version{V1, V2}, ResponseVersion: []Version{V2, V1}},
+			Version:  V2,
+			Ok:       true,
+		},
+		{
+			Name:     "V2ResponsesOnly",
+			Metadata: PluginMetadata{RequestVersion: []Version{V1, V2}, ResponseVersion: []Version{V1}},
+			Version:  V1,
+			Ok:       true,
+		},
+		{
+			Name:     "Unknown",
+			Metadata: PluginMetadata{RequestVersion: []Version{"v3"}, ResponseVersion: []Version{V1}},
+			Ok:       false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			version, ok := NegotiateVersion(c.Metadata)
+			if version != c.Version || ok != c.Ok {
+				t.Errorf("incorrect version, actual: %v, %v expected: %v, %v", version, ok, c.Version, c.Ok)
+			}
+		})
+	}
+}
+
+func TestHandlesGroupKind(t *testing.T) {
+	deployment := schema.GroupKind{Group: "apps", Kind: "Deployment"}
+	service := schema.GroupKind{Kind: "Service"}
+	metadata := PluginMetadata{GroupKinds: []schema.GroupKind{deployment}}
+
+	if !metadata.HandlesGroupKind(V2, deployment) {
+		t.Errorf("expected a listed GroupKind to be handled")
+	}
+	if metadata.HandlesGroupKind(V2, service) {
+		t.Errorf("expected an unlisted GroupKind not to be handled")
+	}
+	if !metadata.HandlesGroupKind(V1, service) {
+		t.Errorf("expected V1 plugins to handle every GroupKind")
+	}
+	if !(PluginMetadata{}).HandlesGroupKind(V2, service) {
+		t.Errorf("expected plugins without GroupKinds to handle every GroupKind")
+	}
+}
@@ -0,0 +1,256 @@
+package transform
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ResourceIndex is a read-only view of every object being transformed, it
+// lets a plugin look at the objects related to the one it is called for.
+// Objects are returned as copies. The methods of a nil ResourceIndex behave
+// as if it was empty.
+type ResourceIndex struct {
+	objects     []unstructured.Unstructured
+	byName      map[objectKey]int
+	byOwner     map[objectKey][]int
+	byKind      map[schema.GroupKind][]int
+	byNamespace map[kindKey][]int
+}
+
+// ContextRequest selects the objects of the ResourceIndex sent to a binary
+// plugin along with each object, in the RequestContextKey field. Requires V2.
+type ContextRequest struct {
+	// Owners sends the owners of the object, and their owners.
+	Owners bool `json:"owners,omitempty"`
+	// Owned sends the objects owned by the object.
+	Owned bool `json:"owned,omitempty"`
+	// GroupKinds sends every object of these kinds in the namespace of the
+	// object.
+	GroupKinds []schema.GroupKind `json:"groupKinds,omitempty"`
+}
+
+// RequestContextKey is the top level field of a binary plugin request that
+// holds the objects selected by the ContextRequest of the plugin.
+const RequestContextKey = "requestContext"
+
+// objectKey identifies an object regardless of its version.
+type objectKey struct {
+	schema.GroupKind
+	namespace string
+	name      string
+}
+
+// kindKey identifies the objects of a kind in a namespace.
+type kindKey struct {
+	schema.GroupKind
+	namespace string
+}
+
+func keyFor(u unstructured.Unstructured) objectKey {
+	return objectKey{GroupKind: u.GroupVersionKind().GroupKind(), namespace: u.GetNamespace(), name: u.GetName()}
+}
+
+// ownerKey identifies the owner referenced by an object in namespace.
+func ownerKey(ref metav1.OwnerReference, namespace string) objectKey {
+	gv, _ := schema.ParseGroupVersion(ref.APIVersion)
+	return objectKey{GroupKind: schema.GroupKind{Group: gv.Group, Kind: ref.Kind}, namespace: namespace, name: ref.Name}
+}
+
+// NewResourceIndex indexes a copy of the objects. When several objects have
+// the same group, kind, namespace and name, the last one is kept.
+func NewResourceIndex(objects []unstructured.Unstructured) *ResourceIndex {
+	index := &ResourceIndex{
+		byName:      map[objectKey]int{},
+		byOwner:     map[objectKey][]int{},
+		byKind:      map[schema.GroupKind][]int{},
+		byNamespace: map[kindKey][]int{},
+	}
+	for _, object := range objects {
+		key := keyFor(object)
+		if i, ok := index.byName[key]; ok {
+			index.objects[i] = *object.DeepCopy()
+			continue
+		}
+		index.byName[key] = len(index.objects)
+		index.objects = append(index.objects, *object.DeepCopy())
+	}
+	for i, object := range index.objects {
+		gk := object.GroupVersionKind().GroupKind()
+		index.byKind[gk] = append(index.byKind[gk], i)
+		kind := kindKey{GroupKind: gk, namespace: object.GetNamespace()}
+		index.byNamespace[kind] = append(index.byNamespace[kind], i)
+		for _, ref := range object.GetOwnerReferences() {
+			// The owner is either in the same namespace or cluster scoped
+			index.byOwner[ownerKey(ref, object.GetNamespace())] = append(index.byOwner[ownerKey(ref, object.GetNamespace())], i)
+			if object.GetNamespace() != "" {
+				index.byOwner[ownerKey(ref, "")] = append(index.byOwner[ownerKey(ref, "")], i)
+			}
+		}
+	}
+	return index
+}
+
+// Len returns the number of objects in the index.
+func (i *ResourceIndex) Len() int {
+	if i == nil {
+		return 0
+	}
+	return len(i.objects)
+}
+
+// Objects returns every object in the index.
+func (i *ResourceIndex) Objects() []unstructured.Unstructured {
+	if i == nil {
+		return nil
+	}
+	return copyObjects(i.objects, nil)
+}
+
+// Get returns the object with the group, kind, namespace and name. The
+// version must match unless it is empty.
+func (i *ResourceIndex) Get(gvk schema.GroupVersionKind, namespace, name string) (unstructured.Unstructured, bool) {
+	if i == nil {
+		return unstructured.Unstructured{}, false
+	}
+	index, ok := i.byName[objectKey{GroupKind: gvk.GroupKind(), namespace: namespace, name: name}]
+	if !ok || (gvk.Version != "" && i.objects[index].GroupVersionKind().Version != gvk.Version) {
+		return unstructured.Unstructured{}, false
+	}
+	return *i.objects[index].DeepCopy(), true
+}
+
+// List returns the objects with the group and kind in the namespace, or in
+// every namespace when it is empty. The version must match unless it is empty.
+func (i *ResourceIndex) List(gvk schema.GroupVersionKind, namespace string) []unstructured.Unstructured {
+	if i == nil {
+		return nil
+	}
+	candidates := i.byKind[gvk.GroupKind()]
+	if namespace != metav1.NamespaceAll {
+		candidates = i.byNamespace[kindKey{GroupKind: gvk.GroupKind(), namespace: namespace}]
+	}
+	indexes := []int{}
+	for _, index := range candidates {
+		if gvk.Version != "" && i.objects[index].GroupVersionKind().Version != gvk.Version {
+			continue
+		}
+		indexes = append(indexes, index)
+	}
+	return copyObjects(i.objects, indexes)
+}
+
+// Owners returns the objects referenced by the owner references of the object.
+func (i *ResourceIndex) Owners(object unstructured.Unstructured) []unstructured.Unstructured {
+	return copyObjects(i.objectsOrEmpty(), i.owners(object))
+}
+
+func (i *ResourceIndex) owners(object unstructured.Unstructured) []int {
+	if i == nil {
+		return nil
+	}
+	indexes := []int{}
+	for _, ref := range object.GetOwnerReferences() {
+		for _, namespace := range []string{object.GetNamespace(), ""} {
+			index, ok := i.byName[ownerKey(ref, namespace)]
+			if !ok || !sameUID(ref, i.objects[index]) {
+				continue
+			}
+			indexes = append(indexes, index)
+			break
+		}
+	}
+	return indexes
+}
+
+// Owned returns the objects with an owner reference to the object.
+func (i *ResourceIndex) Owned(object unstructured.Unstructured) []unstructured.Unstructured {
+	return copyObjects(i.objectsOrEmpty(), i.owned(object))
+}
+
+func (i *ResourceIndex) owned(object unstructured.Unstructured) []int {
+	if i == nil {
+		return nil
+	}
+	indexes := []int{}
+	for _, index := range i.byOwner[keyFor(object)] {
+		for _, ref := range i.objects[index].GetOwnerReferences() {
+			if ownerKey(ref, object.GetNamespace()) == keyFor(object) && sameUID(ref, object) {
+				indexes = append(indexes, index)
+				break
+			}
+		}
+	}
+	return indexes
+}
+
+// Context returns the objects selected by the request for the object, each
+// one once. The object itself is never part of its context.
+func (i *ResourceIndex) Context(object unstructured.Unstructured, request ContextRequest) []unstructured.Unstructured {
+	if i == nil {
+		return nil
+	}
+	self, isIndexed := i.byName[keyFor(object)]
+	seen := map[int]bool{}
+	indexes := []int{}
+	add := func(index int) bool {
+		if seen[index] || (isIndexed && index == self) {
+			return false
+		}
+		seen[index] = true
+		indexes = append(indexes, index)
+		return true
+	}
+
+	if request.Owners {
+		// Owners of owners are followed, every object is visited once
+		pending := i.owners(object)
+		for len(pending) > 0 {
+			index := pending[0]
+			pending = pending[1:]
+			if add(index) {
+				pending = append(pending, i.owners(i.objects[index])...)
+			}
+		}
+	}
+	if request.Owned {
+		for _, index := range i.owned(object) {
+			add(index)
+		}
+	}
+	for _, gk := range request.GroupKinds {
+		for _, index := range i.byNamespace[kindKey{GroupKind: gk, namespace: object.GetNamespace()}] {
+			add(index)
+		}
+	}
+	return copyObjects(i.objects, indexes)
+}
+
+func (i *ResourceIndex) objectsOrEmpty() []unstructured.Unstructured {
+	if i == nil {
+		return nil
+	}
+	return i.objects
+}
+
+// sameUID returns false when both the reference and the object have a UID
+// and they differ.
+func sameUID(ref metav1.OwnerReference, object unstructured.Unstructured) bool {
+	return ref.UID == "" || object.GetUID() == "" || ref.UID == object.GetUID()
+}
+
+// copyObjects returns copies of the objects at indexes, or of every object
+// when indexes is nil.
+func copyObjects(objects []unstructured.Unstructured, indexes []int) []unstructured.Unstructured {
+	if indexes == nil {
+		indexes = make([]int, len(objects))
+		for index := range objects {
+			indexes[index] = index
+		}
+	}
+	copies := make([]unstructured.Unstructured, 0, len(indexes))
+	for _, index := range indexes {
+		copies = append(copies, *objects[index].DeepCopy())
+	}
+	return copies
+}
@@ -0,0 +1,165 @@
+package transform
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func testObject(apiVersion, kind, namespace, name, uid string, owners ...metav1.OwnerReference) unstructured.Unstructured {
+	u := unstructured.Unstructured{}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	u.SetUID(types.UID(uid))
+	if len(owners) > 0 {
+		u.SetOwnerReferences(owners)
+	}
+	return u
+}
+
+func names(objects []unstructured.Unstructured) []string {
+	n := []string{}
+	for _, o := range objects {
+		n = append(n, o.GetKind()+"/"+o.GetName())
+	}
+	return n
+}
+
+func TestResourceIndex(t *testing.T) {
+	deployment := testObject("apps/v1", "Deployment", "test", "app", "1")
+	replicaSet := testObject("apps/v1", "ReplicaSet", "test", "app-1", "2",
+		metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "app", UID: "1"})
+	pod := testObject("v1", "Pod", "test", "app-1-a", "3",
+		metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app-1", UID: "2"})
+	// The UID does not match, the ReplicaSet is not the owner
+	stalePod := testObject("v1", "Pod", "test", "app-1-b", "4",
+		metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app-1", UID: "old"})
+	service := testObject("v1", "Service", "test", "app", "5")
+	otherService := testObject("v1", "Service", "other", "app", "6")
+	index := NewResourceIndex([]unstructured.Unstructured{deployment, replicaSet, pod, stalePod, service, otherService})
+
+	if index.Len() != 6 {
+		t.Errorf("expected 6 objects, got: %v", index.Len())
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		got, ok := index.Get(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, "test", "app")
+		if !ok || got.GetUID() != "1" {
+			t.Errorf("expected the deployment, got: %v, %v", got, ok)
+		}
+		if _, ok := index.Get(schema.GroupVersionKind{Group: "apps", Kind: "Deployment"}, "test", "app"); !ok {
+			t.Errorf("expected the deployment for any version")
+		}
+		if _, ok := index.Get(schema.GroupVersionKind{Group: "apps", Version: "v1beta1", Kind: "Deployment"}, "test", "app"); ok {
+			t.Errorf("expected no deployment for another version")
+		}
+		// Objects are copies
+		got.SetName("changed")
+		if again, _ := index.Get(schema.GroupVersionKind{Group: "apps", Kind: "Deployment"}, "test", "app"); again.GetName() != "app" {
+			t.Errorf("expected the index to be read-only")
+		}
+	})
+
+	cases := []struct {
+		Name     string
+		Got      []unstructured.Unstructured
+		Expected []string
+	}{
+		{
+			Name:     "ListNamespace",
+			Got:      index.List(schema.GroupVersionKind{Kind: "Service"}, "test"),
+			Expected: []string{"Service/app"},
+		},
+		{
+			Name:     "ListAllNamespaces",
+			Got:      index.List(schema.GroupVersionKind{Version: "v1", Kind: "Service"}, metav1.NamespaceAll),
+			Expected: []string{"Service/app", "Service/app"},
+		},
+		{
+			Name:     "Owners",
+			Got:      index.Owners(pod),
+			Expected: []string{"ReplicaSet/app-1"},
+		},
+		{
+			Name:     "OwnersUIDMismatch",
+			Got:      index.Owners(stalePod),
+			Expected: []string{},
+		},
+		{
+			Name:     "Owned",
+			Got:      index.Owned(replicaSet),
+			Expected: []string{"Pod/app-1-a"},
+		},
+		{
+			Name:     "ContextOwners",
+			Got:      index.Context(pod, ContextRequest{Owners: true}),
+			Expected: []string{"ReplicaSet/app-1", "Deployment/app"},
+		},
+		{
+			Name:     "ContextOwnedAndGroupKinds",
+			Got:      index.Context(replicaSet, ContextRequest{Owned: true, Owners: true, GroupKinds: []schema.GroupKind{{Kind: "Service"}, {Group: "apps", Kind: "ReplicaSet"}}}),
+			Expected: []string{"Deployment/app", "Pod/app-1-a", "Service/app"},
+		},
+		{
+			Name:     "NilIndex",
+			Got:      (*ResourceIndex)(nil).Context(pod, ContextRequest{Owners: true}),
+			Expected: []string{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if got := names(c.Got); !reflect.DeepEqual(got, c.Expected) {
+				t.Errorf("expected %v, got: %v", c.Expected, got)
+			}
+		})
+	}
+}
+
+// resourcesPlugin whites out Pods owned by a Deployment.
+type resourcesPlugin struct{}
+
+func (r resourcesPlugin) Metadata() PluginMetadata {
+	return PluginMetadata{Name: "resources", RequestVersion: []Version{V2}, ResponseVersion: []Version{V2}}
+}
+
+func (r resourcesPlugin) Run(request PluginRequest) (PluginResponse, error) {
+	for _, owner := range request.Resources.Owners(request.Unstructured) {
+		if len(request.Resources.Owners(owner)) > 0 {
+			return PluginResponse{Version: string(V2), IsWhiteOut: true}, nil
+		}
+	}
+	return PluginResponse{Version: string(V2)}, nil
+}
+
+func TestRunnerResources(t *testing.T) {
+	deployment := testObject("apps/v1", "Deployment", "test", "app", "")
+	replicaSet := testObject("apps/v1", "ReplicaSet", "test", "app-1", "",
+		metav1.OwnerReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "app"})
+	pod := testObject("v1", "Pod", "test", "app-1-a", "",
+		metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "app-1"})
+
+	runner := Runner{Log: logrus.New(), Resources: NewResourceIndex([]unstructured.Unstructured{deployment, replicaSet, pod})}
+	resp, err := runner.Run(pod, []Plugin{resourcesPlugin{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.HaveWhiteOut {
+		t.Errorf("expected the pod to be whited out")
+	}
+
+	runner.Resources = nil
+	resp, err = runner.Run(pod, []Plugin{resourcesPlugin{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.HaveWhiteOut {
+		t.Errorf("expected the pod to be kept without resources")
+	}
+}
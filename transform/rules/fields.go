@@ -0,0 +1,86 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	ijsonpatch "github.com/konveyor/crane-lib/transform/internal/jsonpatch"
+)
+
+// setOperation returns the operation setting the field. When a parent of the
+// field is missing the operation adds the first missing parent with the
+// nested value, parents can only be created as objects.
+func setOperation(obj map[string]interface{}, field SetField) (jsonpatch.Operation, error) {
+	var value interface{}
+	if len(field.Value) > 0 {
+		if err := json.Unmarshal(field.Value, &value); err != nil {
+			return nil, fmt.Errorf("invalid value to set at %v: %v", field.Path, err)
+		}
+	}
+
+	tokens := ijsonpatch.SplitPath(field.Path)
+	var current interface{} = obj
+	for i, token := range tokens {
+		last := i == len(tokens)-1
+		switch c := current.(type) {
+		case map[string]interface{}:
+			next, ok := c[token]
+			if !ok {
+				return ijsonpatch.NewOperation("add", ijsonpatch.JoinPath(tokens[:i+1]), nest(tokens[i+1:], value))
+			}
+			if last {
+				return ijsonpatch.NewOperation("replace", field.Path, value)
+			}
+			current = next
+		case []interface{}:
+			if last && (token == "-" || token == strconv.Itoa(len(c))) {
+				return ijsonpatch.NewOperation("add", field.Path, value)
+			}
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(c) {
+				return nil, fmt.Errorf("unable to set %v: index %v is out of range", field.Path, token)
+			}
+			if last {
+				return ijsonpatch.NewOperation("replace", field.Path, value)
+			}
+			current = c[index]
+		default:
+			return nil, fmt.Errorf("unable to set %v: %v is not an object or an array", field.Path, ijsonpatch.JoinPath(tokens[:i]))
+		}
+	}
+	return ijsonpatch.NewOperation("replace", field.Path, value)
+}
+
+// nest wraps value in one object per token.
+func nest(tokens []string, value interface{}) interface{} {
+	for i := len(tokens) - 1; i >= 0; i-- {
+		value = map[string]interface{}{tokens[i]: value}
+	}
+	return value
+}
+
+// exists returns true when the JSON Pointer refers to a value of obj.
+func exists(obj map[string]interface{}, path string) bool {
+	var current interface{} = obj
+	for _, token := range ijsonpatch.SplitPath(path) {
+		switch c := current.(type) {
+		case map[string]interface{}:
+			next, ok := c[token]
+			if !ok {
+				return false
+			}
+			current = next
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(c) {
+				return false
+			}
+			current = c[index]
+		default:
+			return false
+		}
+	}
+	return true
+}
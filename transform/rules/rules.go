@@ -0,0 +1,351 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strings"
+	"text/template"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/konveyor/crane-lib/transform"
+	ijsonpatch "github.com/konveyor/crane-lib/transform/internal/jsonpatch"
+	"github.com/konveyor/crane-lib/version"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+const defaultPluginName = "RulesPlugin"
+
+// RuleSet is the content of a rule file, in YAML or JSON.
+type RuleSet struct {
+	// Name is the name of the plugin, defaults to RulesPlugin.
+	Name  string `json:"name,omitempty"`
+	Rules []Rule `json:"rules"`
+}
+
+// Rule applies its Action to every object matching its Match. Rules are
+// evaluated in order, the first matching rule with a whiteout action stops
+// the evaluation.
+type Rule struct {
+	Name   string `json:"name"`
+	Match  Match  `json:"match"`
+	Action Action `json:"action"`
+}
+
+// Match selects objects, every field that is set must match.
+type Match struct {
+	// GroupKinds in the Kind.group format, for example Deployment.apps or
+	// Service.
+	GroupKinds []string `json:"groupKinds,omitempty"`
+	// Namespaces are globs matched against the namespace of the object.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Name is a glob matched against the name of the object.
+	Name          string                `json:"name,omitempty"`
+	LabelSelector *metav1.LabelSelector `json:"labelSelector,omitempty"`
+	JSONPath      *JSONPathMatch        `json:"jsonPath,omitempty"`
+}
+
+// JSONPathMatch matches when the JSONPath expression finds a value. When Value
+// is set, one of the values found must be equal to it.
+type JSONPathMatch struct {
+	Path  string  `json:"path"`
+	Value *string `json:"value,omitempty"`
+}
+
+// Action is what is done to the objects matched by a rule.
+type Action struct {
+	WhiteOut bool `json:"whiteOut,omitempty"`
+	// Patch is a text/template rendering a JSON patch, the object is passed as
+	// the data of the template. The json function quotes a value for JSON.
+	Patch string `json:"patch,omitempty"`
+	// Set adds or replaces fields, missing parent objects are created.
+	Set []SetField `json:"set,omitempty"`
+	// Remove removes fields by JSON Pointer, missing fields are ignored.
+	Remove []string `json:"remove,omitempty"`
+}
+
+// SetField sets the field at the JSON Pointer Path to Value.
+type SetField struct {
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// RulesPlugin is a transform.Plugin driven by a RuleSet.
+type RulesPlugin struct {
+	name  string
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	Rule
+	groupKinds []schema.GroupKind
+	selector   labels.Selector
+	jsonPath   *jsonpath.JSONPath
+	patch      *template.Template
+}
+
+var _ transform.Plugin = &RulesPlugin{}
+
+// Load reads a rule file and creates the plugin for it.
+func Load(file string) (*RulesPlugin, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read rule file %v: %v", file, err)
+	}
+	plugin, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule file %v: %v", file, err)
+	}
+	return plugin, nil
+}
+
+// Parse creates the plugin for the YAML or JSON rule set, every rule is
+// validated.
+func Parse(data []byte) (*RulesPlugin, error) {
+	ruleSet := RuleSet{}
+	if err := yaml.UnmarshalStrict(data, &ruleSet); err != nil {
+		return nil, err
+	}
+	return New(ruleSet)
+}
+
+// New creates the plugin for the rule set, every rule is validated.
+func New(ruleSet RuleSet) (*RulesPlugin, error) {
+	plugin := &RulesPlugin{name: ruleSet.Name}
+	if plugin.name == "" {
+		plugin.name = defaultPluginName
+	}
+	for i, rule := range ruleSet.Rules {
+		compiled, err := compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %v (%v): %v", i, rule.Name, err)
+		}
+		plugin.rules = append(plugin.rules, compiled)
+	}
+	return plugin, nil
+}
+
+func compile(rule Rule) (compiledRule, error) {
+	c := compiledRule{Rule: rule}
+	for _, gk := range rule.Match.GroupKinds {
+		c.groupKinds = append(c.groupKinds, schema.ParseGroupKind(gk))
+	}
+	for _, glob := range append(rule.Match.Namespaces, rule.Match.Name) {
+		if _, err := path.Match(glob, ""); err != nil {
+			return c, fmt.Errorf("invalid glob %q: %v", glob, err)
+		}
+	}
+	if rule.Match.LabelSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(rule.Match.LabelSelector)
+		if err != nil {
+			return c, fmt.Errorf("invalid label selector: %v", err)
+		}
+		c.selector = selector
+	}
+	if rule.Match.JSONPath != nil {
+		expression := rule.Match.JSONPath.Path
+		if !strings.HasPrefix(expression, "{") {
+			expression = "{" + expression + "}"
+		}
+		c.jsonPath = jsonpath.New(rule.Name).AllowMissingKeys(true)
+		if err := c.jsonPath.Parse(expression); err != nil {
+			return c, fmt.Errorf("invalid JSONPath %q: %v", rule.Match.JSONPath.Path, err)
+		}
+	}
+	if rule.Action.Patch != "" {
+		patch, err := template.New(rule.Name).Funcs(template.FuncMap{"json": toJSON}).Option("missingkey=zero").Parse(rule.Action.Patch)
+		if err != nil {
+			return c, fmt.Errorf("invalid patch template: %v", err)
+		}
+		c.patch = patch
+	}
+	for _, field := range rule.Action.Set {
+		if !strings.HasPrefix(field.Path, "/") {
+			return c, fmt.Errorf("invalid path %q to set, it must be a JSON Pointer", field.Path)
+		}
+	}
+	for _, field := range rule.Action.Remove {
+		if !strings.HasPrefix(field, "/") {
+			return c, fmt.Errorf("invalid path %q to remove, it must be a JSON Pointer", field)
+		}
+	}
+	return c, nil
+}
+
+func toJSON(value interface{}) (string, error) {
+	b, err := json.Marshal(value)
+	return string(b), err
+}
+
+func (r *RulesPlugin) Metadata() transform.PluginMetadata {
+	return transform.PluginMetadata{
+		Name:            r.name,
+		Version:         version.Version,
+		RequestVersion:  []transform.Version{transform.V1},
+		ResponseVersion: []transform.Version{transform.V1},
+	}
+}
+
+func (r *RulesPlugin) Run(request transform.PluginRequest) (transform.PluginResponse, error) {
+	resp := transform.PluginResponse{Version: string(transform.V1)}
+	// The actions are applied one after another to a working copy, so each
+	// rule is matched and each action sees the fields set or removed before.
+	doc, err := request.Unstructured.MarshalJSON()
+	if err != nil {
+		return transform.PluginResponse{}, err
+	}
+	for _, rule := range r.rules {
+		current, err := decode(doc)
+		if err != nil {
+			return transform.PluginResponse{}, err
+		}
+		obj := unstructured.Unstructured{Object: current}
+		matched, err := rule.matches(obj)
+		if err != nil {
+			return transform.PluginResponse{}, fmt.Errorf("rule %v: %v", rule.Name, err)
+		}
+		if !matched {
+			continue
+		}
+		if rule.Action.WhiteOut {
+			return transform.PluginResponse{Version: string(transform.V1), IsWhiteOut: true}, nil
+		}
+		var patch jsonpatch.Patch
+		patch, doc, err = rule.patches(obj, doc)
+		if err != nil {
+			return transform.PluginResponse{}, fmt.Errorf("rule %v: %v", rule.Name, err)
+		}
+		resp.Patches = append(resp.Patches, patch...)
+	}
+	return resp, nil
+}
+
+func (c compiledRule) matches(obj unstructured.Unstructured) (bool, error) {
+	if len(c.groupKinds) > 0 && !groupKindInList(obj.GroupVersionKind().GroupKind(), c.groupKinds) {
+		return false, nil
+	}
+	if len(c.Match.Namespaces) > 0 && !globsMatch(c.Match.Namespaces, obj.GetNamespace()) {
+		return false, nil
+	}
+	if c.Match.Name != "" && !globsMatch([]string{c.Match.Name}, obj.GetName()) {
+		return false, nil
+	}
+	if c.selector != nil && !c.selector.Matches(labels.Set(obj.GetLabels())) {
+		return false, nil
+	}
+	if c.jsonPath != nil {
+		results, err := c.jsonPath.FindResults(obj.Object)
+		if err != nil {
+			return false, err
+		}
+		return jsonPathMatches(results, c.Match.JSONPath.Value), nil
+	}
+	return true, nil
+}
+
+func jsonPathMatches(results [][]reflect.Value, value *string) bool {
+	for _, result := range results {
+		for _, v := range result {
+			if value == nil || fmt.Sprint(v.Interface()) == *value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func groupKindInList(gk schema.GroupKind, list []schema.GroupKind) bool {
+	for _, thisGK := range list {
+		if gk == thisGK {
+			return true
+		}
+	}
+	return false
+}
+
+func globsMatch(globs []string, value string) bool {
+	for _, glob := range globs {
+		// Globs are validated when the rule is compiled
+		if ok, _ := path.Match(glob, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// patches returns the patch for the action of the rule, the template patch
+// comes first followed by the fields to set and remove. The template is
+// rendered with obj, the object as left by the previous rules, the fields are
+// set and removed against doc, the JSON of the object with the previous
+// operations applied. doc is returned with the patch applied.
+func (c compiledRule) patches(obj unstructured.Unstructured, doc []byte) (jsonpatch.Patch, []byte, error) {
+	patch := jsonpatch.Patch{}
+	if c.patch != nil {
+		rendered := bytes.Buffer{}
+		if err := c.patch.Execute(&rendered, obj.Object); err != nil {
+			return nil, nil, fmt.Errorf("unable to render patch: %v", err)
+		}
+		p, err := jsonpatch.DecodePatch(rendered.Bytes())
+		if err != nil {
+			return nil, nil, fmt.Errorf("rendered patch is invalid: %v", err)
+		}
+		doc, err = p.Apply(doc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("rendered patch does not apply: %v", err)
+		}
+		patch = append(patch, p...)
+	}
+	for _, field := range c.Action.Set {
+		current, err := decode(doc)
+		if err != nil {
+			return nil, nil, err
+		}
+		op, err := setOperation(current, field)
+		if err != nil {
+			return nil, nil, err
+		}
+		doc, err = jsonpatch.Patch{op}.Apply(doc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to set %v: %v", field.Path, err)
+		}
+		patch = append(patch, op)
+	}
+	for _, field := range c.Action.Remove {
+		current, err := decode(doc)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !exists(current, field) {
+			continue
+		}
+		op, err := ijsonpatch.NewOperation("remove", field, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		doc, err = jsonpatch.Patch{op}.Apply(doc)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to remove %v: %v", field, err)
+		}
+		patch = append(patch, op)
+	}
+	return patch, doc, nil
+}
+
+// decode returns the object in doc, with whole numbers decoded as int64 like
+// in unstructured.Unstructured.
+func decode(doc []byte) (map[string]interface{}, error) {
+	obj := map[string]interface{}{}
+	if err := utiljson.Unmarshal(doc, &obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
@@ -0,0 +1,322 @@
+package rules
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/konveyor/crane-lib/transform"
+	ijsonpatch "github.com/konveyor/crane-lib/transform/internal/jsonpatch"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const testRules = `
+name: ClusterMigration
+rules:
+- name: drop-test-namespaces
+  match:
+    namespaces: ["test-*"]
+  action:
+    whiteOut: true
+- name: drop-canary
+  match:
+    groupKinds: ["Deployment.apps"]
+    labelSelector:
+      matchLabels:
+        track: canary
+  action:
+    whiteOut: true
+- name: scale-down
+  match:
+    groupKinds: ["Deployment.apps"]
+    jsonPath:
+      path: .spec.replicas
+      value: "3"
+  action:
+    set:
+    - path: /spec/replicas
+      value: 1
+    - path: /metadata/annotations/migrated
+      value: "true"
+- name: rename-route-host
+  match:
+    groupKinds: ["Route.route.openshift.io"]
+    name: "web-*"
+  action:
+    patch: |
+      [{"op": "replace", "path": "/spec/host", "value": {{ printf "%s.apps.example.com" .metadata.name | json }}}]
+    remove:
+    - /status
+    - /spec/tls
+`
+
+func object(apiVersion, kind, namespace, name string, fields map[string]interface{}) unstructured.Unstructured {
+	u := unstructured.Unstructured{Object: map[string]interface{}{}}
+	for k, v := range fields {
+		u.Object[k] = v
+	}
+	u.SetAPIVersion(apiVersion)
+	u.SetKind(kind)
+	u.SetNamespace(namespace)
+	u.SetName(name)
+	return u
+}
+
+func TestRulesPluginRun(t *testing.T) {
+	plugin, err := Parse([]byte(testRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := plugin.Metadata().Name; name != "ClusterMigration" {
+		t.Errorf("Metadata().Name = %v, want ClusterMigration", name)
+	}
+
+	canary := object("apps/v1", "Deployment", "prod", "web", map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(3)}})
+	canary.SetLabels(map[string]string{"track": "canary"})
+
+	tests := []struct {
+		name       string
+		object     unstructured.Unstructured
+		isWhiteOut bool
+		patch      string
+	}{
+		{
+			name:       "NamespaceGlob",
+			object:     object("v1", "Service", "test-1", "web", nil),
+			isWhiteOut: true,
+		},
+		{
+			name:       "LabelSelector",
+			object:     canary,
+			isWhiteOut: true,
+		},
+		{
+			name:   "JSONPathSet",
+			object: object("apps/v1", "Deployment", "prod", "web", map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(3)}}),
+			patch: `[{"op": "replace", "path": "/spec/replicas", "value": 1},
+			{"op": "add", "path": "/metadata/annotations", "value": {"migrated": "true"}}]`,
+		},
+		{
+			name:   "JSONPathNoMatch",
+			object: object("apps/v1", "Deployment", "prod", "web", map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(2)}}),
+			patch:  `[]`,
+		},
+		{
+			name: "PatchTemplateAndRemove",
+			object: object("route.openshift.io/v1", "Route", "prod", "web-frontend", map[string]interface{}{
+				"spec":   map[string]interface{}{"host": "old.example.com"},
+				"status": map[string]interface{}{},
+			}),
+			patch: `[{"op": "replace", "path": "/spec/host", "value": "web-frontend.apps.example.com"},
+			{"op": "remove", "path": "/status"}]`,
+		},
+		{
+			name:   "NameGlobNoMatch",
+			object: object("route.openshift.io/v1", "Route", "prod", "api", map[string]interface{}{"spec": map[string]interface{}{"host": "old.example.com"}}),
+			patch:  `[]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := plugin.Run(transform.PluginRequest{Unstructured: tt.object})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.IsWhiteOut != tt.isWhiteOut {
+				t.Errorf("IsWhiteOut = %v, want %v", resp.IsWhiteOut, tt.isWhiteOut)
+			}
+			if tt.isWhiteOut {
+				return
+			}
+			want, err := jsonpatch.DecodePatch([]byte(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if equal, err := ijsonpatch.Equal(resp.Patches, want); err != nil || !equal {
+				t.Errorf("Patches = %v, want %v", resp.Patches, want)
+			}
+			if _, err := resp.Patches.Apply([]byte(mustJSON(t, tt.object))); err != nil {
+				t.Errorf("unable to apply patches: %v", err)
+			}
+		})
+	}
+}
+
+func mustJSON(t *testing.T, u unstructured.Unstructured) string {
+	b, err := u.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+	}{
+		{name: "UnknownField", rules: `rules: [{name: a, match: {kind: Pod}}]`},
+		{name: "BadGlob", rules: `rules: [{name: a, match: {name: "[a"}}]`},
+		{name: "BadSelector", rules: `rules: [{name: a, match: {labelSelector: {matchExpressions: [{key: a, operator: Bad}]}}}]`},
+		{name: "BadJSONPath", rules: `rules: [{name: a, match: {jsonPath: {path: "{.spec["}}}]`},
+		{name: "BadTemplate", rules: `rules: [{name: a, action: {patch: "{{ .metadata"}}]`},
+		{name: "BadSetPath", rules: `rules: [{name: a, action: {set: [{path: spec.replicas, value: 1}]}}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.rules)); err == nil {
+				t.Errorf("Parse() expected an error")
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "rules.json")
+	if err := ioutil.WriteFile(file, []byte(`{"rules": [{"name": "all", "match": {}, "action": {"whiteOut": true}}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plugin, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := plugin.Metadata().Name; name != defaultPluginName {
+		t.Errorf("Metadata().Name = %v, want %v", name, defaultPluginName)
+	}
+	resp, err := plugin.Run(transform.PluginRequest{Unstructured: object("v1", "Pod", "a", "b", nil)})
+	if err != nil || !resp.IsWhiteOut {
+		t.Errorf("Run() = %v, %v, want a whiteout", resp, err)
+	}
+}
+
+func TestRulesPluginSetsShareMissingParent(t *testing.T) {
+	plugin, err := Parse([]byte(`
+rules:
+- name: annotate
+  match:
+    groupKinds: ["Service"]
+  action:
+    set:
+    - path: /metadata/annotations/a
+      value: "1"
+    - path: /metadata/annotations/b
+      value: "2"
+- name: annotate-again
+  match:
+    groupKinds: ["Service"]
+  action:
+    set:
+    - path: /metadata/annotations/c
+      value: "3"
+    remove:
+    - /metadata/annotations/a
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := object("v1", "Service", "prod", "web", nil)
+	resp, err := plugin.Run(transform.PluginRequest{Unstructured: obj})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := jsonpatch.DecodePatch([]byte(`[{"op": "add", "path": "/metadata/annotations", "value": {"a": "1"}},
+	{"op": "add", "path": "/metadata/annotations/b", "value": "2"},
+	{"op": "add", "path": "/metadata/annotations/c", "value": "3"},
+	{"op": "remove", "path": "/metadata/annotations/a"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if equal, err := ijsonpatch.Equal(resp.Patches, want); err != nil || !equal {
+		t.Errorf("Patches = %v, want %v", resp.Patches, want)
+	}
+
+	// Every annotation is set once the transform file is applied
+	runner := transform.Runner{Log: logrus.New()}
+	response, err := runner.Run(obj, []transform.Plugin{plugin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	patch, err := jsonpatch.DecodePatch(response.TransformFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patched, err := patch.Apply([]byte(mustJSON(t, obj)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := unstructured.Unstructured{}
+	if err := after.UnmarshalJSON(patched); err != nil {
+		t.Fatal(err)
+	}
+	if annotations := after.GetAnnotations(); !reflect.DeepEqual(annotations, map[string]string{"b": "2", "c": "3"}) {
+		t.Errorf("annotations = %v, want b and c", annotations)
+	}
+}
+
+func TestRulesPluginSeesPreviousRules(t *testing.T) {
+	plugin, err := Parse([]byte(`
+rules:
+- name: label
+  match:
+    groupKinds: ["Service"]
+  action:
+    set:
+    - path: /metadata/labels
+      value: {tier: web}
+    - path: /spec/port
+      value: 8080
+- name: annotate-labelled
+  match:
+    labelSelector:
+      matchLabels: {tier: web}
+  action:
+    patch: |
+      [{"op": "add", "path": "/metadata/annotations", "value": {"port": {{json .spec.port}}}}]
+- name: drop-port
+  match:
+    jsonPath:
+      path: "{.spec.port}"
+      value: "8080"
+  action:
+    remove:
+    - /spec/port
+- name: unreachable
+  match:
+    jsonPath:
+      path: "{.spec.port}"
+  action:
+    whiteOut: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := object("v1", "Service", "prod", "web", nil)
+	resp, err := plugin.Run(transform.PluginRequest{Unstructured: obj})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.IsWhiteOut {
+		t.Fatalf("expected the removed field not to match")
+	}
+	want, err := jsonpatch.DecodePatch([]byte(`[{"op": "add", "path": "/metadata/labels", "value": {"tier": "web"}},
+	{"op": "add", "path": "/spec", "value": {"port": 8080}},
+	{"op": "add", "path": "/metadata/annotations", "value": {"port": 8080}},
+	{"op": "remove", "path": "/spec/port"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if equal, err := ijsonpatch.Equal(resp.Patches, want); err != nil || !equal {
+		actual, _ := json.Marshal(resp.Patches)
+		t.Errorf("Patches = %s, want %v", actual, want)
+	}
+}
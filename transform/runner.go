@@ -1,13 +1,23 @@
 package transform
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/konveyor/crane-lib/apply"
+	cranerrors "github.com/konveyor/crane-lib/transform/errors"
 	ijsonpatch "github.com/konveyor/crane-lib/transform/internal/jsonpatch"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 type Runner struct {
@@ -16,24 +26,127 @@ type Runner struct {
 	// This also needs to handle the options that it will need.
 	// TODO: Figure out options that the runner will need and implement here.
 	PluginPriorities map[string]int
-	OptionalFlags    map[string]string
-	Log              *logrus.Logger
+	// OptionalFlags are the flags shared by every plugin, PluginFlags are
+	// keyed by plugin name and take precedence. A plugin only receives the
+	// flags it declares in its OptionalFields.
+	OptionalFlags map[string]string
+	PluginFlags   map[string]map[string]string
+	Log           *logrus.Logger
+	// Workers bounds the number of plugin invocations that may be in flight
+	// at the same time. A value of zero or one runs every plugin serially.
+	// Plugins must be safe for concurrent use when this is greater than one.
+	Workers int
+	// ErrorPolicy decides how the Runner reacts to a plugin returning an
+	// error. The zero value is ErrorPolicyAbort.
+	ErrorPolicy ErrorPolicy
+	// OptionalPlugins is the set of plugin names whose failures are logged
+	// and otherwise ignored, regardless of the ErrorPolicy.
+	OptionalPlugins map[string]bool
+	// WhiteOutPolicy decides whether an object is whited out when some
+	// plugins ask for a whiteout and others return patches. The zero value
+	// is WhiteOutPolicyAny.
+	WhiteOutPolicy WhiteOutPolicy
+	// Resources, when set, is passed to every plugin in
+	// PluginRequest.Resources.
+	Resources *ResourceIndex
+	// Explain sets RunnerResponse.Trace, which records what every plugin did
+	// and why each operation was kept or dropped. It applies the transform
+	// file to the object to produce a diff, which takes time.
+	Explain bool
 }
 
+// ErrorPolicy defines what the Runner does when a plugin fails. Whenever any
+// plugin that is not optional fails, the error returned by the Runner is a
+// *errors.RunnerError describing every failure.
+type ErrorPolicy string
+
+const (
+	// ErrorPolicyAbort runs every plugin and returns no patches if any of
+	// them failed.
+	ErrorPolicyAbort ErrorPolicy = ""
+	// ErrorPolicyFailFast stops calling plugins after the first failure and
+	// returns no patches.
+	ErrorPolicyFailFast ErrorPolicy = "FailFast"
+	// ErrorPolicyContinue runs every plugin and returns the result built
+	// from the plugins that succeeded along with the error.
+	ErrorPolicyContinue ErrorPolicy = "Continue"
+)
+
+// WhiteOutPolicy defines how the Runner settles plugins that disagree on
+// whether an object should be whited out. Only plugins that asked for a
+// whiteout or returned patches take part in the decision.
+type WhiteOutPolicy string
+
+const (
+	// WhiteOutPolicyAny whites out the object if any plugin asks for it.
+	WhiteOutPolicyAny WhiteOutPolicy = ""
+	// WhiteOutPolicyPriority follows the highest priority plugin in
+	// PluginPriorities, the first plugin wins a tie.
+	WhiteOutPolicyPriority WhiteOutPolicy = "Priority"
+	// WhiteOutPolicyMajority whites out the object when more plugins ask
+	// for a whiteout than return patches.
+	WhiteOutPolicyMajority WhiteOutPolicy = "Majority"
+)
+
 // RunnerResponse will be responsble for
 // TransformFile is a marshaled jsonpatch.Patch
 // IgnoredPatches is a marshaled []PluginOperation
+// WhiteOutPlugins are the names of the plugins that caused the whiteout
+// Warnings are the warnings returned by V2 plugins, in plugin order
+// Annotations are the annotations returned by V2 plugins, keyed by plugin name
+// NewResources are the objects V2 plugins asked to create, in plugin order
+// MergePatches are the merge patches returned by V2 plugins, they are applied
+// in order after the TransformFile
+// Trace is only set when Runner.Explain is true
 type RunnerResponse struct {
-	TransformFile  []byte
-	HaveWhiteOut   bool
-	IgnoredPatches []byte
+	TransformFile   []byte
+	MergePatches    []MergePatch
+	HaveWhiteOut    bool
+	IgnoredPatches  []byte
+	WhiteOutPlugins []string
+	Warnings        []PluginWarning
+	Annotations     map[string]map[string]string
+	NewResources    []unstructured.Unstructured
+	Trace           *Trace
+}
+
+// PluginWarning is a warning returned by a plugin for an object.
+type PluginWarning struct {
+	PluginName string
+	Message    string
+}
+
+// MergePatch is a JSON merge patch or a strategic merge patch returned by a
+// plugin. Merge patches are kept apart from the JSON patch operations so that
+// strategic merge patches merge lists by their key when they are applied with
+// apply.Applier.ApplyPatch. They are applied after the transform file, from
+// the lowest to the highest priority plugin.
+type MergePatch struct {
+	PluginName string          `json:"pluginName"`
+	Type       types.PatchType `json:"type"`
+	Patch      json.RawMessage `json:"patch"`
 }
 
 type PluginOperation struct {
 	PluginName string
 	Operation  jsonpatch.Operation
+	// Reason is set on ignored operations to explain why they were dropped.
+	Reason IgnoredReason `json:",omitempty"`
 }
 
+// IgnoredReason is a code describing why an operation was not added to the
+// transform file.
+type IgnoredReason string
+
+const (
+	// ReasonPathConflict is used when an operation from a higher priority
+	// plugin targets the same path.
+	ReasonPathConflict IgnoredReason = "PathConflict"
+	// ReasonPathPrefixConflict is used when an operation from a higher
+	// priority plugin removes or replaces a parent or child of the path.
+	ReasonPathPrefixConflict IgnoredReason = "PathPrefixConflict"
+)
+
 func PluginOperationsFromPatch(pluginName string, patches jsonpatch.Patch) []PluginOperation {
 	pluginOpList := []PluginOperation{}
 	for _, op := range patches {
@@ -58,57 +171,491 @@ func EqualPluginOperation(pluginOp1, pluginOp2 PluginOperation) bool {
 	return pluginOp1.PluginName == pluginOp2.PluginName && ijsonpatch.EqualOperation(pluginOp1.Operation, pluginOp2.Operation)
 }
 
+// ObjectResult is the outcome of running the plugins against one of the
+// objects handed to RunAll.
+type ObjectResult struct {
+	Response RunnerResponse
+	Err      error
+}
+
+// pluginResult holds the response of a single plugin so that responses can be
+// merged in plugin order regardless of the order the plugins finished in.
+type pluginResult struct {
+	response PluginResponse
+	version  Version
+	err      error
+	ran      bool
+	duration time.Duration
+}
+
 func (r *Runner) Run(object unstructured.Unstructured, plugins []Plugin) (RunnerResponse, error) {
-	haveWhiteOut := false
+	return r.RunWithContext(context.Background(), object, plugins)
+}
+
+// RunWithContext runs every plugin against the object, stopping early when
+// the context is cancelled. When Workers is greater than one the plugins are
+// called concurrently.
+func (r *Runner) RunWithContext(ctx context.Context, object unstructured.Unstructured, plugins []Plugin) (RunnerResponse, error) {
+	return r.run(ctx, object, plugins, r.newSemaphore())
+}
+
+// RunAll runs the plugins against every object, processing up to Workers
+// objects in parallel. The results are returned in the same order as the
+// objects. Objects not yet processed when the context is cancelled have the
+// context error set.
+func (r *Runner) RunAll(ctx context.Context, objects []unstructured.Unstructured, plugins []Plugin) []ObjectResult {
+	results := make([]ObjectResult, len(objects))
+	sem := r.newSemaphore()
+
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < r.workers(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := ctx.Err(); err != nil {
+					results[i].Err = err
+					continue
+				}
+				results[i].Response, results[i].Err = r.run(ctx, objects[i], plugins, sem)
+			}
+		}()
+	}
+	for i := range objects {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// log returns the Log of the Runner, or the standard logger when it is unset.
+func (r *Runner) log() logrus.FieldLogger {
+	if r.Log == nil {
+		return logrus.StandardLogger()
+	}
+	return r.Log
+}
+
+func (r *Runner) workers() int {
+	if r.Workers < 1 {
+		return 1
+	}
+	return r.Workers
+}
+
+// newSemaphore returns the channel used to bound concurrent plugin calls, it
+// is shared by every object processed within a single RunAll.
+func (r *Runner) newSemaphore() chan struct{} {
+	return make(chan struct{}, r.workers())
+}
+
+// runPlugins calls each plugin with its own copy of the object. The results
+// are indexed the same as plugins.
+func (r *Runner) runPlugins(ctx context.Context, object unstructured.Unstructured, plugins []Plugin, extras []map[string]string, sem chan struct{}) ([]pluginResult, error) {
+	results := make([]pluginResult, len(plugins))
+	var failed int32
+	run := func(i int) {
+		metadata := plugins[i].Metadata()
+		version, ok := NegotiateVersion(metadata)
+		if ok && !metadata.HandlesGroupKind(version, object.GroupVersionKind().GroupKind()) {
+			return
+		}
+		results[i].ran = true
+		results[i].version = version
+		if ok {
+			// We want to keep the original while we run each plugin.
+			c := object.DeepCopy()
+			start := time.Now()
+			defer func() { results[i].duration = time.Since(start) }()
+			results[i].response, results[i].err = runPlugin(ctx, plugins[i], PluginRequest{Unstructured: *c, Extras: extras[i], Version: version, Resources: r.Resources})
+		} else {
+			results[i].err = &cranerrors.PluginError{
+				Type:         cranerrors.PluginInvalidIOError,
+				Message:      "plugin does not support any known protocol version",
+				ErrorMessage: fmt.Sprintf("requestVersions: %v, responseVersions: %v, supported: %v", metadata.RequestVersion, metadata.ResponseVersion, SupportedVersions),
+			}
+		}
+		if results[i].err != nil && !r.OptionalPlugins[metadata.Name] {
+			atomic.StoreInt32(&failed, 1)
+		}
+	}
+	// With ErrorPolicyFailFast no further plugins are started once one fails.
+	stop := func() bool {
+		return r.ErrorPolicy == ErrorPolicyFailFast && atomic.LoadInt32(&failed) == 1
+	}
+
+	if r.workers() == 1 {
+		for i := range plugins {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if stop() {
+				break
+			}
+			run(i)
+		}
+		return results, nil
+	}
+
+	wg := sync.WaitGroup{}
+	for i := range plugins {
+		if stop() {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return nil, ctx.Err()
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			run(i)
+		}(i)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// ValidateOptionalFlags checks the OptionalFlags and PluginFlags against the
+// OptionalFields declared by each plugin. The Runner does the same before
+// running plugins against an object and returns the same error.
+func (r *Runner) ValidateOptionalFlags(plugins []Plugin) error {
+	_, err := r.pluginExtras(plugins)
+	return err
+}
+
+// pluginExtras returns the extras of each plugin, the flags of the fields it
+// declares along with the defaults of the missing ones. The error is a
+// *errors.RunnerError with a failure for every plugin with invalid, missing
+// or undeclared values.
+func (r *Runner) pluginExtras(plugins []Plugin) ([]map[string]string, error) {
+	extras := make([]map[string]string, len(plugins))
+	failures := []cranerrors.PluginFailure{}
+	for i, plugin := range plugins {
+		metadata := plugin.Metadata()
+		var err error
+		extras[i], err = ValidateOptionalFields(metadata.OptionalFields, r.declaredFlags(metadata))
+		if err == nil {
+			err = undeclaredFlags(metadata, r.PluginFlags[metadata.Name])
+		}
+		if err != nil {
+			failures = append(failures, cranerrors.NewPluginFailure(metadata.Name, metadata.Version, &cranerrors.PluginError{
+				Type:         cranerrors.PluginInvalidInputError,
+				Message:      "invalid optional flags",
+				ErrorMessage: err.Error(),
+			}))
+		}
+	}
+	if len(failures) > 0 {
+		return nil, &cranerrors.RunnerError{Failures: failures}
+	}
+	return extras, nil
+}
+
+// declaredFlags returns the flags for the fields declared by the plugin, the
+// flags of the plugin take precedence over the shared ones.
+func (r *Runner) declaredFlags(metadata PluginMetadata) map[string]string {
+	flags := map[string]string{}
+	for _, field := range metadata.OptionalFields {
+		if value, ok := r.PluginFlags[metadata.Name][field.FlagName]; ok {
+			flags[field.FlagName] = value
+		} else if value, ok := r.OptionalFlags[field.FlagName]; ok {
+			flags[field.FlagName] = value
+		}
+	}
+	return flags
+}
+
+// undeclaredFlags returns an error listing the flags set for the plugin that
+// it does not declare, they are most likely a typo.
+func undeclaredFlags(metadata PluginMetadata, flags map[string]string) error {
+	undeclared := []string{}
+	for flag := range flags {
+		if !hasField(metadata.OptionalFields, flag) {
+			undeclared = append(undeclared, flag)
+		}
+	}
+	if len(undeclared) == 0 {
+		return nil
+	}
+	sort.Strings(undeclared)
+	return fmt.Errorf("the plugin does not declare the fields %v", strings.Join(undeclared, ", "))
+}
+
+func hasField(fields []OptionalFields, name string) bool {
+	for _, field := range fields {
+		if field.FlagName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// runPlugin passes the context on to plugins implementing PluginRunWithContext.
+func runPlugin(ctx context.Context, plugin Plugin, request PluginRequest) (PluginResponse, error) {
+	if p, ok := plugin.(PluginRunWithContext); ok {
+		return p.RunWithContext(ctx, request)
+	}
+	return plugin.Run(request)
+}
+
+func hasMergePatches(resp PluginResponse) bool {
+	return len(resp.MergePatch) > 0 || len(resp.StrategicMergePatch) > 0
+}
+
+// pluginMergePatches returns the merge patches of the response, making sure
+// each of them applies to the object.
+func pluginMergePatches(object unstructured.Unstructured, pluginName string, resp PluginResponse) ([]MergePatch, error) {
+	mergePatches := []MergePatch{}
+	for _, mergePatch := range []MergePatch{
+		{PluginName: pluginName, Type: types.MergePatchType, Patch: resp.MergePatch},
+		{PluginName: pluginName, Type: types.StrategicMergePatchType, Patch: resp.StrategicMergePatch},
+	} {
+		if len(mergePatch.Patch) == 0 {
+			continue
+		}
+		if _, err := (apply.Applier{}).ApplyPatch(*object.DeepCopy(), mergePatch.Patch, mergePatch.Type); err != nil {
+			return nil, &cranerrors.PluginError{
+				Type:         cranerrors.PluginInvalidIOError,
+				Message:      fmt.Sprintf("invalid %v from plugin", mergePatch.Type),
+				ErrorMessage: err.Error(),
+			}
+		}
+		mergePatches = append(mergePatches, mergePatch)
+	}
+	return mergePatches, nil
+}
+
+// whiteOutVote is the opinion of a single plugin on whiteing out an object.
+type whiteOutVote struct {
+	pluginName string
+	whiteOut   bool
+}
+
+// higherPriority returns true when plugin1 has a higher (lower int) priority
+// than plugin2. Plugins missing from PluginPriorities have the lowest priority.
+func (r *Runner) higherPriority(plugin1, plugin2 string) bool {
+	prio1, ok1 := r.PluginPriorities[plugin1]
+	prio2, ok2 := r.PluginPriorities[plugin2]
+	return ok1 && (!ok2 || prio1 < prio2)
+}
+
+// whiteOutPlugins applies the WhiteOutPolicy to the votes and returns the
+// plugins responsible for the whiteout, or nil if the object is kept.
+func (r *Runner) whiteOutPlugins(votes []whiteOutVote) []string {
+	var whiteOuts []string
+	for _, v := range votes {
+		if v.whiteOut {
+			whiteOuts = append(whiteOuts, v.pluginName)
+		}
+	}
+	if len(whiteOuts) == 0 {
+		return nil
+	}
+
+	switch r.WhiteOutPolicy {
+	case WhiteOutPolicyPriority:
+		winner := votes[0]
+		for _, v := range votes[1:] {
+			if r.higherPriority(v.pluginName, winner.pluginName) {
+				winner = v
+			}
+		}
+		if winner.whiteOut {
+			return []string{winner.pluginName}
+		}
+		return nil
+	case WhiteOutPolicyMajority:
+		if len(whiteOuts) > len(votes)-len(whiteOuts) {
+			return whiteOuts
+		}
+		return nil
+	default:
+		return whiteOuts
+	}
+}
+
+// pluginResource is a new resource along with the plugin that created it.
+type pluginResource struct {
+	pluginName string
+	resource   unstructured.Unstructured
+}
+
+// validateNewResources makes sure every new resource can be identified.
+func validateNewResources(resources []unstructured.Unstructured) error {
+	for _, resource := range resources {
+		if resource.GetAPIVersion() == "" || resource.GetKind() == "" || resource.GetName() == "" {
+			return &cranerrors.PluginError{
+				Type:         cranerrors.PluginRunError,
+				Message:      "new resources must have an apiVersion, kind and name",
+				ErrorMessage: fmt.Sprintf("invalid new resource: %v", resource.Object),
+			}
+		}
+	}
+	return nil
+}
+
+// addNewResources adds the resources created by a plugin. When two plugins
+// create the same resource the one from the higher priority plugin is kept,
+// the first plugin wins a tie.
+func (r *Runner) addNewResources(existing []pluginResource, pluginName string, resources []unstructured.Unstructured) []pluginResource {
+	for _, resource := range resources {
+		found := false
+		for i, e := range existing {
+			if e.resource.GroupVersionKind() != resource.GroupVersionKind() ||
+				e.resource.GetNamespace() != resource.GetNamespace() ||
+				e.resource.GetName() != resource.GetName() {
+				continue
+			}
+			found = true
+			if r.higherPriority(pluginName, e.pluginName) {
+				r.log().Debugf("New resource %v %v/%v from plugin %v replaces the one from plugin %v", resource.GroupVersionKind(), resource.GetNamespace(), resource.GetName(), pluginName, e.pluginName)
+				existing[i] = pluginResource{pluginName: pluginName, resource: resource}
+			} else {
+				r.log().Debugf("Ignoring new resource %v %v/%v from plugin %v, already created by plugin %v", resource.GroupVersionKind(), resource.GetNamespace(), resource.GetName(), pluginName, e.pluginName)
+			}
+			break
+		}
+		if !found {
+			existing = append(existing, pluginResource{pluginName: pluginName, resource: resource})
+		}
+	}
+	return existing
+}
+
+func (r *Runner) run(ctx context.Context, object unstructured.Unstructured, plugins []Plugin, sem chan struct{}) (RunnerResponse, error) {
 	havePatches := false
+	votes := []whiteOutVote{}
+	warnings := []PluginWarning{}
+	annotations := map[string]map[string]string{}
+	newResources := []pluginResource{}
 	patches := []PluginOperation{}
-	errs := []error{}
+	mergePatches := []MergePatch{}
+	failures := []cranerrors.PluginFailure{}
+
+	extras, err := r.pluginExtras(plugins)
+	if err != nil {
+		return RunnerResponse{TransformFile: []byte(`[]`), IgnoredPatches: []byte(`[]`)}, err
+	}
+	results, err := r.runPlugins(ctx, object, plugins, extras, sem)
+	if err != nil {
+		return RunnerResponse{TransformFile: []byte(`[]`), IgnoredPatches: []byte(`[]`)}, err
+	}
+	var trace *Trace
+	if r.Explain {
+		trace = newTrace(plugins, results)
+	}
 
-	for _, plugin := range plugins {
-		// We want to keep the original while we run each plugin.
-		c := object.DeepCopy()
-		// TODO: Handle Version things here
-		resp, err := plugin.Run(PluginRequest{Unstructured:*c, Extras:r.OptionalFlags})
+	for i, plugin := range plugins {
+		if !results[i].ran {
+			continue
+		}
+		resp, err := results[i].response, results[i].err
+		if err == nil {
+			err = validateNewResources(resp.NewResources)
+		}
+		patch := resp.Patches
+		var pluginMerges []MergePatch
+		if err == nil && hasMergePatches(resp) {
+			if results[i].version == V1 {
+				r.log().Warnf("Ignoring merge patches from plugin %v, they require protocol version %v", plugin.Metadata().Name, V2)
+			} else {
+				pluginMerges, err = pluginMergePatches(object, plugin.Metadata().Name, resp)
+			}
+		}
 		if err != nil {
-			//TODO: add debug level logging here
-			errs = append(errs, err)
+			metadata := plugin.Metadata()
+			if r.OptionalPlugins[metadata.Name] {
+				r.log().Warnf("Ignoring error from optional plugin %v: %v", metadata.Name, err)
+				continue
+			}
+			r.log().Debugf("Plugin %v failed: %v", metadata.Name, err)
+			failures = append(failures, cranerrors.NewPluginFailure(metadata.Name, metadata.Version, err))
 			continue
 		}
-		if resp.IsWhiteOut {
-			haveWhiteOut = true
+		pluginName := plugin.Metadata().Name
+		if trace != nil {
+			trace.Plugins[i].Operations = patch
+			trace.Plugins[i].MergePatches = pluginMerges
 		}
-		if len(resp.Patches) > 0 {
+		if len(resp.Warnings) > 0 || len(resp.Annotations) > 0 {
+			if results[i].version == V1 {
+				r.log().Warnf("Ignoring warnings and annotations from plugin %v, they require protocol version %v", pluginName, V2)
+			} else {
+				for _, w := range resp.Warnings {
+					r.log().Warnf("Plugin %v: %v", pluginName, w)
+					warnings = append(warnings, PluginWarning{PluginName: pluginName, Message: w})
+				}
+				if len(resp.Annotations) > 0 {
+					annotations[pluginName] = resp.Annotations
+				}
+			}
+		}
+		if len(resp.NewResources) > 0 {
+			if results[i].version == V1 {
+				r.log().Warnf("Ignoring new resources from plugin %v, they require protocol version %v", pluginName, V2)
+			} else {
+				newResources = r.addNewResources(newResources, pluginName, resp.NewResources)
+			}
+		}
+		if resp.IsWhiteOut || len(patch) > 0 || len(pluginMerges) > 0 {
+			votes = append(votes, whiteOutVote{pluginName: pluginName, whiteOut: resp.IsWhiteOut})
+		}
+		if len(patch) > 0 {
 			havePatches = true
-			patches = append(patches, PluginOperationsFromPatch(plugin.Metadata().Name, resp.Patches)...)
+			patches = append(patches, PluginOperationsFromPatch(pluginName, patch)...)
 		}
+		mergePatches = append(mergePatches, pluginMerges...)
 	}
+	whiteOutPlugins := r.whiteOutPlugins(votes)
 	response := RunnerResponse{
-		TransformFile:  []byte(`[]`),
-		HaveWhiteOut:   haveWhiteOut,
-		IgnoredPatches: []byte(`[]`),
+		TransformFile:   []byte(`[]`),
+		HaveWhiteOut:    len(whiteOutPlugins) > 0,
+		IgnoredPatches:  []byte(`[]`),
+		WhiteOutPlugins: whiteOutPlugins,
+		Warnings:        warnings,
+		Annotations:     annotations,
+		Trace:           trace,
 	}
 
-	// TODO: in the future we should consider a way to speed this up with go routines.
-	if len(errs) > 0 {
-		// TODO: handle error in a reasonable way. Probably needs an enhancement
-		// Should Consider option to ignore errors
-		return response, errs[0]
+	var runErr error
+	if len(failures) > 0 {
+		runErr = &cranerrors.RunnerError{Failures: failures}
+		if r.ErrorPolicy != ErrorPolicyContinue {
+			trace.dropAll(patches, ReasonPluginFailure)
+			return response, runErr
+		}
 	}
-	if haveWhiteOut {
-		// TODO: handle if we should skip whiteOut if there is a transform
-		response.HaveWhiteOut = haveWhiteOut
-		return response, nil
+	for _, resource := range newResources {
+		response.NewResources = append(response.NewResources, resource.resource)
+	}
+	if response.HaveWhiteOut {
+		r.log().Debugf("Object whited out by plugins: %v", whiteOutPlugins)
+		trace.dropAll(patches, ReasonWhiteOut)
+		return response, runErr
 	}
 
+	if len(mergePatches) > 0 {
+		response.MergePatches = r.orderMergePatches(mergePatches)
+	}
 	if havePatches {
-		patches, ignoredPatches, err := r.sanitizePatches(patches)
+		patch, ignoredPatches, duplicates, err := r.sanitizePatches(patches)
 		if err != nil {
 			return response, err
 		}
+		trace.decide(patches, ignoredPatches, duplicates)
 
 		// for each patch, we should make sure the patch can be applied
 		// We may need to break the transform file into two parts to handle this correctly
-		response.TransformFile, err = json.Marshal(patches)
+		response.TransformFile, err = json.Marshal(patch)
 		if err != nil {
 			return response, err
 		}
@@ -116,45 +663,77 @@ func (r *Runner) Run(object unstructured.Unstructured, plugins []Plugin) (Runner
 		if err != nil {
 			return response, err
 		}
-
-		return response, err
 	}
-	return response, nil
+	if havePatches || len(mergePatches) > 0 {
+		trace.applyTransform(object, response.TransformFile, response.MergePatches)
+	}
+	return response, runErr
 }
 
+// orderMergePatches orders the merge patches from the lowest to the highest
+// priority plugin, so that the higher priority plugins win when they are
+// applied in order. The first plugin is applied last on a tie.
+func (r *Runner) orderMergePatches(mergePatches []MergePatch) []MergePatch {
+	pluginIndex := map[string]int{}
+	for _, mergePatch := range mergePatches {
+		if _, ok := pluginIndex[mergePatch.PluginName]; !ok {
+			pluginIndex[mergePatch.PluginName] = len(pluginIndex)
+		}
+	}
+	ordered := append([]MergePatch{}, mergePatches...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		plugin1, plugin2 := ordered[i].PluginName, ordered[j].PluginName
+		if r.higherPriority(plugin2, plugin1) {
+			return true
+		}
+		return !r.higherPriority(plugin1, plugin2) && pluginIndex[plugin1] > pluginIndex[plugin2]
+	})
+	return ordered
+}
 
 // sanitizePatches removes duplicate patch operations as well as find
-// conflicting operations where path is the same, but different kind or values.
+// conflicting operations where path is the same, but different kind or values,
+// or where one plugin removes or replaces a parent of a path another plugin
+// changes. The returned patch is ordered with ijsonpatch.Merge, keeping the
+// order of the operations of each plugin. Operations equal
+// to a kept one are returned separately, with the ReasonDuplicate.
 // TODO: Handle where paths are the same, but operations are different.
-func (r *Runner) sanitizePatches(pluginOps []PluginOperation) (jsonpatch.Patch, []PluginOperation, error) {
+func (r *Runner) sanitizePatches(pluginOps []PluginOperation) (jsonpatch.Patch, []PluginOperation, []PluginOperation, error) {
 	patchMap := map[string]PluginOperation{}
+	keys := []string{}
 	ignoredPatches := []PluginOperation{}
+	duplicates := []PluginOperation{}
 	for _, o := range pluginOps {
 		key, err := o.Operation.Path()
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, err
 		}
 		if foundOp, ok := patchMap[key]; ok {
-			currentPrio, currentOk := r.PluginPriorities[o.PluginName]
-			previousPrio, previousOk := r.PluginPriorities[foundOp.PluginName]
 			// replace value if current plugin is higher (lower int) priority than prior
-			replaceVal := currentOk && (!previousOk || currentPrio < previousPrio)
+			replaceVal := r.higherPriority(o.PluginName, foundOp.PluginName)
 			equalOp := ijsonpatch.EqualOperation(foundOp.Operation, o.Operation)
 			// Handle Collision
 			val, err := o.Operation.ValueInterface()
 			err1 := errors.Cause(err)
 			if err1 != nil && err1 != jsonpatch.ErrMissing {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
 			previousVal, err := foundOp.Operation.ValueInterface()
 			err1 = errors.Cause(err)
 			if err1 != nil && err1 != jsonpatch.ErrMissing {
-				return nil, nil, err
+				return nil, nil, nil, err
 			}
 			if replaceVal {
 				patchMap[key] = o
 			}
-			if !equalOp {
+			if equalOp {
+				duplicate := o
+				if replaceVal {
+					duplicate = foundOp
+				}
+				duplicate.Reason = ReasonDuplicate
+				duplicates = append(duplicates, duplicate)
+			} else {
 				var selectedVal, rejectedVal interface{}
 				var selectedPluginOp, rejectedPluginOp PluginOperation
 				if replaceVal {
@@ -162,15 +741,15 @@ func (r *Runner) sanitizePatches(pluginOps []PluginOperation) (jsonpatch.Patch,
 					rejectedVal = previousVal
 					selectedPluginOp = o
 					rejectedPluginOp = foundOp
-					ignoredPatches = append(ignoredPatches, foundOp)
 				} else {
 					selectedVal = previousVal
 					rejectedVal = val
 					selectedPluginOp = foundOp
 					rejectedPluginOp = o
-					ignoredPatches = append(ignoredPatches, o)
 				}
-				r.Log.Debugf("Operation on same path: %v with different kind or values selected kind, value: %v, %v (from plugin %v) kind, value that will be ignored: %v, %v (from plugin %v)",
+				rejectedPluginOp.Reason = ReasonPathConflict
+				ignoredPatches = append(ignoredPatches, rejectedPluginOp)
+				r.log().Debugf("Operation on same path: %v with different kind or values selected kind, value: %v, %v (from plugin %v) kind, value that will be ignored: %v, %v (from plugin %v)",
 					key,
 					selectedPluginOp.Operation.Kind(),
 					selectedVal,
@@ -183,12 +762,95 @@ func (r *Runner) sanitizePatches(pluginOps []PluginOperation) (jsonpatch.Patch,
 			continue
 		}
 		patchMap[key] = o
+		keys = append(keys, key)
 	}
 
-	dedupedPatch := jsonpatch.Patch{}
+	keptOps := []PluginOperation{}
+	for _, key := range keys {
+		keptOps = append(keptOps, patchMap[key])
+	}
+	keptOps, prefixIgnored := r.resolvePrefixConflicts(keptOps)
+	ignoredPatches = append(ignoredPatches, prefixIgnored...)
+
+	// Order the patch so the transform file is reproducible and parents are
+	// created before their children.
+	pluginPatches := []jsonpatch.Patch{}
+	pluginIndex := map[string]int{}
+	for _, o := range keptOps {
+		i, ok := pluginIndex[o.PluginName]
+		if !ok {
+			i = len(pluginPatches)
+			pluginIndex[o.PluginName] = i
+			pluginPatches = append(pluginPatches, jsonpatch.Patch{})
+		}
+		pluginPatches[i] = append(pluginPatches[i], o.Operation)
+	}
+	return ijsonpatch.Merge(pluginPatches), ignoredPatches, duplicates, nil
+}
+
+// overwritesChildren returns true for operation kinds that discard whatever
+// was previously under their path. An add is not included as it is how a
+// parent is created before its children are added.
+func overwritesChildren(kind string) bool {
+	switch kind {
+	case "remove", "replace", "move", "copy":
+		return true
+	}
+	return false
+}
+
+// resolvePrefixConflicts finds operations from different plugins where one
+// removes or replaces a parent of the path changed by the other. The higher
+// priority plugin wins, with ties going to the operation seen first.
+func (r *Runner) resolvePrefixConflicts(pluginOps []PluginOperation) ([]PluginOperation, []PluginOperation) {
+	rawPaths := make([]string, len(pluginOps))
+	paths := make([][]string, len(pluginOps))
+	for i, o := range pluginOps {
+		rawPaths[i], _ = o.Operation.Path()
+		paths[i] = ijsonpatch.SplitPath(rawPaths[i])
+	}
 
-	for _, p := range patchMap {
-		dedupedPatch = append(dedupedPatch, p.Operation)
+	ignored := make([]bool, len(pluginOps))
+	ignoredPatches := []PluginOperation{}
+	for i := range pluginOps {
+		for j := i + 1; j < len(pluginOps) && !ignored[i]; j++ {
+			if ignored[j] || pluginOps[i].PluginName == pluginOps[j].PluginName {
+				continue
+			}
+			parent := -1
+			if ijsonpatch.IsParentPath(paths[i], paths[j]) {
+				parent = i
+			} else if ijsonpatch.IsParentPath(paths[j], paths[i]) {
+				parent = j
+			}
+			if parent == -1 || !overwritesChildren(pluginOps[parent].Operation.Kind()) {
+				continue
+			}
+
+			selected, rejected := i, j
+			if r.higherPriority(pluginOps[j].PluginName, pluginOps[i].PluginName) {
+				selected, rejected = j, i
+			}
+			ignored[rejected] = true
+			rejectedOp := pluginOps[rejected]
+			rejectedOp.Reason = ReasonPathPrefixConflict
+			ignoredPatches = append(ignoredPatches, rejectedOp)
+			r.log().Debugf("Operation %v on %v (from plugin %v) conflicts with operation %v on %v (from plugin %v) which will be ignored",
+				pluginOps[selected].Operation.Kind(),
+				rawPaths[selected],
+				pluginOps[selected].PluginName,
+				rejectedOp.Operation.Kind(),
+				rawPaths[rejected],
+				rejectedOp.PluginName,
+			)
+		}
+	}
+
+	kept := []PluginOperation{}
+	for i, o := range pluginOps {
+		if !ignored[i] {
+			kept = append(kept, o)
+		}
 	}
-	return dedupedPatch, ignoredPatches, nil
+	return kept, ignoredPatches
 }
@@ -1,11 +1,17 @@
 package transform
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/konveyor/crane-lib/apply"
+	"github.com/konveyor/crane-lib/transform/errors"
 	internaljsonpatch "github.com/konveyor/crane-lib/transform/internal/jsonpatch"
 	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -13,8 +19,10 @@ import (
 )
 
 type fakePlugin struct {
-	Func func(request PluginRequest) (PluginResponse, error)
-	name string
+	Func     func(request PluginRequest) (PluginResponse, error)
+	name     string
+	version  string
+	metadata *PluginMetadata
 }
 
 func (fp fakePlugin) Run(request PluginRequest) (PluginResponse, error) {
@@ -22,7 +30,10 @@ func (fp fakePlugin) Run(request PluginRequest) (PluginResponse, error) {
 }
 
 func (fp fakePlugin) Metadata() PluginMetadata {
-	return PluginMetadata{Name: fp.name}
+	if fp.metadata != nil {
+		return *fp.metadata
+	}
+	return PluginMetadata{Name: fp.name, Version: fp.version}
 }
 
 func TestRunnerRun(t *testing.T) {
@@ -277,26 +288,26 @@ func TestRunnerRun(t *testing.T) {
 			Plugins: []Plugin{
 				fakePlugin{
 					Func: func(request PluginRequest) (PluginResponse, error) {
-					p, err := jsonpatch.DecodePatch([]byte(`[{"op": "replace", "path": "/spec/testing", "value": "test"}]`))
-					if err != nil {
-						return PluginResponse{}, err
-					}
-					return PluginResponse{
-						Patches: p,
-					}, nil
-				},
+						p, err := jsonpatch.DecodePatch([]byte(`[{"op": "replace", "path": "/spec/testing", "value": "test"}]`))
+						if err != nil {
+							return PluginResponse{}, err
+						}
+						return PluginResponse{
+							Patches: p,
+						}, nil
+					},
 					name: "pluginreplace",
 				},
 				fakePlugin{
 					Func: func(request PluginRequest) (PluginResponse, error) {
-					p, err := jsonpatch.DecodePatch([]byte(`[{"op": "remove", "path": "/spec/testing"}]`))
-					if err != nil {
-						return PluginResponse{}, err
-					}
-					return PluginResponse{
-						Patches: p,
-					}, nil
-				},
+						p, err := jsonpatch.DecodePatch([]byte(`[{"op": "remove", "path": "/spec/testing"}]`))
+						if err != nil {
+							return PluginResponse{}, err
+						}
+						return PluginResponse{
+							Patches: p,
+						}, nil
+					},
 					name: "pluginremove",
 				},
 			},
@@ -311,14 +322,16 @@ func TestRunnerRun(t *testing.T) {
 					Func: func(request PluginRequest) (PluginResponse, error) {
 						extraVal := request.Extras["testFlag"]
 						p, err := jsonpatch.DecodePatch([]byte(`[{"op": "add", "path": "/spec/testing", "value": "` + extraVal + `"}]`))
-					if err != nil {
-						return PluginResponse{}, err
-					}
-					return PluginResponse{
-						Patches: p,
-					}, nil
-				},
-					name: "",
+						if err != nil {
+							return PluginResponse{}, err
+						}
+						return PluginResponse{
+							Patches: p,
+						}, nil
+					},
+					metadata: &PluginMetadata{
+						OptionalFields: []OptionalFields{{FlagName: "testFlag"}},
+					},
 				},
 			},
 			OptionalFlags: map[string]string{
@@ -328,54 +341,884 @@ func TestRunnerRun(t *testing.T) {
 		},
 	}
 
+	for _, workers := range []int{0, 4} {
+		for _, c := range cases {
+			t.Run(fmt.Sprintf("%v/Workers%v", c.Name, workers), func(t *testing.T) {
+				runner := Runner{
+					Log:              logrus.New(),
+					PluginPriorities: c.PluginPriorities,
+					OptionalFlags:    c.OptionalFlags,
+					Workers:          workers,
+				}
+				response, err := runner.Run(c.Object, c.Plugins)
+				if err != nil && !c.ShouldError {
+					t.Error(err)
+				}
+				if response.HaveWhiteOut != c.IsWhiteOut {
+					t.Errorf("incorrect white out determination, actual: %v expected: %v", response.HaveWhiteOut, c.IsWhiteOut)
+				}
+
+				// Two Bytes tells us that it is an empty list
+				if len(c.PatchesString) != 0 || len(response.TransformFile) > 2 {
+					p, err := jsonpatch.DecodePatch([]byte(c.PatchesString))
+					if err != nil {
+						t.Error(err)
+					}
+					p2, err := jsonpatch.DecodePatch(response.TransformFile)
+					if err != nil {
+						fmt.Printf("\n\n%v", string(response.TransformFile))
+						t.Error(err)
+					}
+
+					if ok, err := internaljsonpatch.Equal(p2, p); !ok || err != nil {
+						t.Errorf("incorrect jsonpathc, actual: %v expected: %v\nerror: %v", string(response.TransformFile), c.PatchesString, err)
+					}
+				}
+				// Two Bytes tells us that it is an empty list
+				if len(c.IgnoredPatchesString) != 0 || len(response.IgnoredPatches) > 2 {
+					ignoredPluginOperations := []PluginOperation{}
+					err := json.Unmarshal(response.IgnoredPatches, &ignoredPluginOperations)
+					if err != nil {
+						t.Error(err)
+					}
+					expectedIgnoredPluginOperations := []PluginOperation{}
+					err = json.Unmarshal([]byte(c.IgnoredPatchesString), &expectedIgnoredPluginOperations)
+					if err != nil {
+						t.Error(err)
+					}
+					if ok := EqualPluginOperationList(ignoredPluginOperations, expectedIgnoredPluginOperations); !ok || err != nil {
+						t.Errorf("incorrect plugin operations, actual: %v expected: %v", string(response.IgnoredPatches), c.IgnoredPatchesString)
+					}
+				}
+			})
+		}
+	}
+
+}
+
+func patchPlugin(name, patch string, delay time.Duration) fakePlugin {
+	return fakePlugin{
+		Func: func(request PluginRequest) (PluginResponse, error) {
+			time.Sleep(delay)
+			p, err := jsonpatch.DecodePatch([]byte(patch))
+			if err != nil {
+				return PluginResponse{}, err
+			}
+			return PluginResponse{Patches: p}, nil
+		},
+		name: name,
+	}
+}
+
+func TestRunnerRunConcurrentDeterministic(t *testing.T) {
+	// The first plugin finishes last, the collision must still be resolved
+	// in favor of it just like a serial run.
+	plugins := []Plugin{
+		patchPlugin("plugin1", `[{"op": "add", "path": "/spec/testing", "value": "test"}]`, 20*time.Millisecond),
+		patchPlugin("plugin2", `[{"op": "add", "path": "/spec/testing", "value": "test1"}]`, 0),
+		patchPlugin("plugin3", `[{"op": "add", "path": "/spec/other", "value": "test"}]`, 10*time.Millisecond),
+	}
+	expected, err := jsonpatch.DecodePatch([]byte(`[{"op": "add", "path": "/spec/testing", "value": "test"},{"op": "add", "path": "/spec/other", "value": "test"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := Runner{Log: logrus.New(), Workers: 3}
+	for i := 0; i < 5; i++ {
+		response, err := runner.Run(unstructured.Unstructured{}, plugins)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := jsonpatch.DecodePatch(response.TransformFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := internaljsonpatch.Equal(p, expected); !ok {
+			t.Errorf("incorrect jsonpatch, actual: %v", string(response.TransformFile))
+		}
+	}
+}
+
+func TestRunnerRunAll(t *testing.T) {
+	var inFlight, maxInFlight int32
+	plugin := fakePlugin{
+		Func: func(request PluginRequest) (PluginResponse, error) {
+			current := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				seen := atomic.LoadInt32(&maxInFlight)
+				if current <= seen || atomic.CompareAndSwapInt32(&maxInFlight, seen, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			p, err := jsonpatch.DecodePatch([]byte(`[{"op": "add", "path": "/metadata/name", "value": "` + request.GetName() + `-new"}]`))
+			if err != nil {
+				return PluginResponse{}, err
+			}
+			return PluginResponse{Patches: p}, nil
+		},
+		name: "rename",
+	}
+
+	objects := []unstructured.Unstructured{}
+	for i := 0; i < 10; i++ {
+		u := unstructured.Unstructured{Object: map[string]interface{}{}}
+		u.SetName(fmt.Sprintf("obj%v", i))
+		objects = append(objects, u)
+	}
+
+	runner := Runner{Log: logrus.New(), Workers: 3}
+	results := runner.RunAll(context.Background(), objects, []Plugin{plugin, plugin})
+	if len(results) != len(objects) {
+		t.Fatalf("incorrect number of results, actual: %v expected: %v", len(results), len(objects))
+	}
+	for i, result := range results {
+		if result.Err != nil {
+			t.Errorf("unexpected error for object %v: %v", i, result.Err)
+			continue
+		}
+		expected := fmt.Sprintf(`[{"op":"add","path":"/metadata/name","value":"obj%v-new"}]`, i)
+		if string(result.Response.TransformFile) != expected {
+			t.Errorf("incorrect transform for object %v, actual: %v expected: %v", i, string(result.Response.TransformFile), expected)
+		}
+	}
+	if maxInFlight > 3 {
+		t.Errorf("too many concurrent plugin calls, actual: %v expected at most: %v", maxInFlight, 3)
+	}
+}
+
+func TestRunnerRunCancelled(t *testing.T) {
+	cancelPlugin := func(cancel context.CancelFunc, called *int32) Plugin {
+		return fakePlugin{
+			Func: func(request PluginRequest) (PluginResponse, error) {
+				atomic.AddInt32(called, 1)
+				cancel()
+				return PluginResponse{}, nil
+			},
+			name: "cancel",
+		}
+	}
+
+	for _, workers := range []int{0, 1} {
+		called := int32(0)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		plugin := cancelPlugin(cancel, &called)
+		runner := Runner{Log: logrus.New(), Workers: workers}
+		_, err := runner.RunWithContext(ctx, unstructured.Unstructured{}, []Plugin{plugin, plugin, plugin})
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, actual: %v", err)
+		}
+		if called != 1 {
+			t.Errorf("plugins were called after the context was cancelled, calls: %v", called)
+		}
+
+		results := runner.RunAll(ctx, []unstructured.Unstructured{{}, {}}, []Plugin{plugin})
+		for _, result := range results {
+			if result.Err != context.Canceled {
+				t.Errorf("expected context.Canceled, actual: %v", result.Err)
+			}
+		}
+	}
+}
+
+func errorPlugin(name, version string, err error) fakePlugin {
+	return fakePlugin{
+		Func: func(request PluginRequest) (PluginResponse, error) {
+			return PluginResponse{}, err
+		},
+		name:    name,
+		version: version,
+	}
+}
+
+func TestRunnerRunErrorPolicy(t *testing.T) {
+	patchOK := patchPlugin("ok", `[{"op": "add", "path": "/spec/testing", "value": "test"}]`, 0)
+	failing := errorPlugin("failing", "v1.2.0", &errors.PluginError{Type: errors.PluginInvalidInputError, Message: "bad input"})
+	other := errorPlugin("other", "v0.1.0", fmt.Errorf("plain error"))
+
+	cases := []struct {
+		Name            string
+		Plugins         []Plugin
+		ErrorPolicy     ErrorPolicy
+		OptionalPlugins map[string]bool
+		PatchesString   string
+		Failures        []errors.PluginFailure
+	}{
+		{
+			Name:        "AbortReportsEveryFailure",
+			Plugins:     []Plugin{failing, patchOK, other},
+			ErrorPolicy: ErrorPolicyAbort,
+			Failures: []errors.PluginFailure{
+				{PluginName: "failing", PluginVersion: "v1.2.0", Type: errors.PluginInvalidInputError},
+				{PluginName: "other", PluginVersion: "v0.1.0", Type: errors.PluginRunError},
+			},
+		},
+		{
+			Name:        "FailFastStopsAtFirstFailure",
+			Plugins:     []Plugin{failing, patchOK, other},
+			ErrorPolicy: ErrorPolicyFailFast,
+			Failures: []errors.PluginFailure{
+				{PluginName: "failing", PluginVersion: "v1.2.0", Type: errors.PluginInvalidInputError},
+			},
+		},
+		{
+			Name:          "ContinueReturnsPartialPatches",
+			Plugins:       []Plugin{failing, patchOK, other},
+			ErrorPolicy:   ErrorPolicyContinue,
+			PatchesString: `[{"op": "add", "path": "/spec/testing", "value": "test"}]`,
+			Failures: []errors.PluginFailure{
+				{PluginName: "failing", PluginVersion: "v1.2.0", Type: errors.PluginInvalidInputError},
+				{PluginName: "other", PluginVersion: "v0.1.0", Type: errors.PluginRunError},
+			},
+		},
+		{
+			Name:            "OptionalPluginFailuresIgnored",
+			Plugins:         []Plugin{failing, patchOK, other},
+			OptionalPlugins: map[string]bool{"failing": true, "other": true},
+			PatchesString:   `[{"op": "add", "path": "/spec/testing", "value": "test"}]`,
+		},
+		{
+			Name:            "OptionalPluginDoesNotStopFailFast",
+			Plugins:         []Plugin{failing, patchOK, other},
+			ErrorPolicy:     ErrorPolicyFailFast,
+			OptionalPlugins: map[string]bool{"failing": true},
+			Failures: []errors.PluginFailure{
+				{PluginName: "other", PluginVersion: "v0.1.0", Type: errors.PluginRunError},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			runner := Runner{
+				Log:             logrus.New(),
+				ErrorPolicy:     c.ErrorPolicy,
+				OptionalPlugins: c.OptionalPlugins,
+			}
+			response, err := runner.Run(unstructured.Unstructured{}, c.Plugins)
+			if len(c.Failures) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else {
+				runnerErr, ok := err.(*errors.RunnerError)
+				if !ok {
+					t.Fatalf("expected a *errors.RunnerError, actual: %#v", err)
+				}
+				if len(runnerErr.Failures) != len(c.Failures) {
+					t.Fatalf("incorrect failures, actual: %v expected: %v", runnerErr.Failures, c.Failures)
+				}
+				for i, f := range runnerErr.Failures {
+					if f.PluginName != c.Failures[i].PluginName || f.PluginVersion != c.Failures[i].PluginVersion || f.Type != c.Failures[i].Type || f.Err == nil {
+						t.Errorf("incorrect failure, actual: %#v expected: %#v", f, c.Failures[i])
+					}
+				}
+			}
+
+			if len(c.PatchesString) == 0 {
+				if string(response.TransformFile) != `[]` {
+					t.Errorf("expected no patches, actual: %v", string(response.TransformFile))
+				}
+				return
+			}
+			p, err := jsonpatch.DecodePatch([]byte(c.PatchesString))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p2, err := jsonpatch.DecodePatch(response.TransformFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, _ := internaljsonpatch.Equal(p2, p); !ok {
+				t.Errorf("incorrect jsonpatch, actual: %v expected: %v", string(response.TransformFile), c.PatchesString)
+			}
+		})
+	}
+}
+
+func TestRunnerRunWithoutLog(t *testing.T) {
+	failing := errorPlugin("failing", "v1.2.0", fmt.Errorf("plain error"))
+	optional := errorPlugin("optional", "v0.1.0", fmt.Errorf("plain error"))
+
+	runner := Runner{OptionalPlugins: map[string]bool{"optional": true}}
+	_, err := runner.Run(unstructured.Unstructured{}, []Plugin{failing, optional})
+	runnerErr, ok := err.(*errors.RunnerError)
+	if !ok || len(runnerErr.Failures) != 1 || runnerErr.Failures[0].PluginName != "failing" {
+		t.Errorf("expected the failure of the plugin, actual: %#v", err)
+	}
+}
+
+func whiteOutPlugin(name string) fakePlugin {
+	return fakePlugin{
+		Func: func(request PluginRequest) (PluginResponse, error) {
+			return PluginResponse{IsWhiteOut: true}, nil
+		},
+		name: name,
+	}
+}
+
+func TestRunnerRunWhiteOutPolicy(t *testing.T) {
+	patch := `[{"op": "add", "path": "/spec/testing", "value": "test"}]`
+	noOpinion := fakePlugin{
+		Func: func(request PluginRequest) (PluginResponse, error) {
+			return PluginResponse{}, nil
+		},
+		name: "noop",
+	}
+
+	cases := []struct {
+		Name             string
+		Plugins          []Plugin
+		WhiteOutPolicy   WhiteOutPolicy
+		PluginPriorities map[string]int
+		IsWhiteOut       bool
+		WhiteOutPlugins  []string
+	}{
+		{
+			Name:            "AnyWhiteOutWins",
+			Plugins:         []Plugin{patchPlugin("patch", patch, 0), whiteOutPlugin("whiteout1"), whiteOutPlugin("whiteout2")},
+			IsWhiteOut:      true,
+			WhiteOutPlugins: []string{"whiteout1", "whiteout2"},
+		},
+		{
+			Name:             "PriorityPatchWins",
+			Plugins:          []Plugin{whiteOutPlugin("whiteout"), patchPlugin("patch", patch, 0)},
+			WhiteOutPolicy:   WhiteOutPolicyPriority,
+			PluginPriorities: map[string]int{"patch": 0, "whiteout": 1},
+		},
+		{
+			Name:             "PriorityWhiteOutWins",
+			Plugins:          []Plugin{patchPlugin("patch", patch, 0), whiteOutPlugin("whiteout")},
+			WhiteOutPolicy:   WhiteOutPolicyPriority,
+			PluginPriorities: map[string]int{"whiteout": 0},
+			IsWhiteOut:       true,
+			WhiteOutPlugins:  []string{"whiteout"},
+		},
+		{
+			Name:           "PriorityTieFirstPluginWins",
+			Plugins:        []Plugin{noOpinion, patchPlugin("patch", patch, 0), whiteOutPlugin("whiteout")},
+			WhiteOutPolicy: WhiteOutPolicyPriority,
+		},
+		{
+			Name:            "MajorityWhiteOut",
+			Plugins:         []Plugin{whiteOutPlugin("whiteout1"), patchPlugin("patch", patch, 0), whiteOutPlugin("whiteout2"), noOpinion},
+			WhiteOutPolicy:  WhiteOutPolicyMajority,
+			IsWhiteOut:      true,
+			WhiteOutPlugins: []string{"whiteout1", "whiteout2"},
+		},
+		{
+			Name:           "MajorityTieKeepsObject",
+			Plugins:        []Plugin{whiteOutPlugin("whiteout"), patchPlugin("patch", patch, 0), noOpinion},
+			WhiteOutPolicy: WhiteOutPolicyMajority,
+		},
+	}
+
 	for _, c := range cases {
 		t.Run(c.Name, func(t *testing.T) {
 			runner := Runner{
 				Log:              logrus.New(),
 				PluginPriorities: c.PluginPriorities,
-				OptionalFlags:    c.OptionalFlags,
+				WhiteOutPolicy:   c.WhiteOutPolicy,
 			}
-			response, err := runner.Run(c.Object, c.Plugins)
-			if err != nil && !c.ShouldError {
-				t.Error(err)
+			response, err := runner.Run(unstructured.Unstructured{}, c.Plugins)
+			if err != nil {
+				t.Fatal(err)
 			}
 			if response.HaveWhiteOut != c.IsWhiteOut {
 				t.Errorf("incorrect white out determination, actual: %v expected: %v", response.HaveWhiteOut, c.IsWhiteOut)
 			}
+			if !reflect.DeepEqual(response.WhiteOutPlugins, c.WhiteOutPlugins) {
+				t.Errorf("incorrect white out plugins, actual: %v expected: %v", response.WhiteOutPlugins, c.WhiteOutPlugins)
+			}
+			if !c.IsWhiteOut && string(response.TransformFile) == `[]` {
+				t.Errorf("expected the patches to be kept")
+			}
+		})
+	}
+}
 
-			// Two Bytes tells us that it is an empty list
-			if len(c.PatchesString) != 0 || len(response.TransformFile) > 2 {
-				p, err := jsonpatch.DecodePatch([]byte(c.PatchesString))
-				if err != nil {
-					t.Error(err)
+func TestRunnerRunPatchOrder(t *testing.T) {
+	plugins := []Plugin{
+		patchPlugin("annotations", `[{"op": "add", "path": "/metadata/annotations/b", "value": "b"}, {"op": "add", "path": "/metadata/annotations/a", "value": "a"}]`, 0),
+		patchPlugin("strip", `[{"op": "remove", "path": "/status"}, {"op": "remove", "path": "/metadata/uid"}]`, 0),
+		patchPlugin("create", `[{"op": "add", "path": "/metadata/annotations", "value": {}}]`, 0),
+	}
+	// The operations of each plugin keep their order
+	expected := `[{"op":"add","path":"/metadata/annotations","value":{}},{"op":"add","path":"/metadata/annotations/b","value":"b"},{"op":"add","path":"/metadata/annotations/a","value":"a"},{"op":"remove","path":"/status"},{"op":"remove","path":"/metadata/uid"}]`
+
+	runner := Runner{Log: logrus.New()}
+	for i := 0; i < 10; i++ {
+		response, err := runner.Run(unstructured.Unstructured{}, plugins)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(response.TransformFile) != expected {
+			t.Fatalf("incorrect transform file, actual: %s expected: %s", response.TransformFile, expected)
+		}
+	}
+}
+
+func TestRunnerRunPathPrefixConflicts(t *testing.T) {
+	cases := []struct {
+		Name                 string
+		Plugins              []Plugin
+		PluginPriorities     map[string]int
+		PatchesString        string
+		IgnoredPatchesString string
+	}{
+		{
+			Name: "RemoveParentWinsByOrder",
+			Plugins: []Plugin{
+				patchPlugin("remover", `[{"op": "remove", "path": "/spec/template"}]`, 0),
+				patchPlugin("image", `[{"op": "replace", "path": "/spec/template/spec/containers/0/image", "value": "new"}]`, 0),
+			},
+			PatchesString:        `[{"op": "remove", "path": "/spec/template"}]`,
+			IgnoredPatchesString: `[{"PluginName": "image", "Operation": {"op": "replace", "path": "/spec/template/spec/containers/0/image", "value": "new"}, "Reason": "PathPrefixConflict"}]`,
+		},
+		{
+			Name: "ChildWinsByPriority",
+			Plugins: []Plugin{
+				patchPlugin("remover", `[{"op": "remove", "path": "/spec/template"}]`, 0),
+				patchPlugin("image", `[{"op": "replace", "path": "/spec/template/spec/containers/0/image", "value": "new"}]`, 0),
+			},
+			PluginPriorities:     map[string]int{"image": 0, "remover": 1},
+			PatchesString:        `[{"op": "replace", "path": "/spec/template/spec/containers/0/image", "value": "new"}]`,
+			IgnoredPatchesString: `[{"PluginName": "remover", "Operation": {"op": "remove", "path": "/spec/template"}, "Reason": "PathPrefixConflict"}]`,
+		},
+		{
+			Name: "ReplaceParentWinsByPriority",
+			Plugins: []Plugin{
+				patchPlugin("image", `[{"op": "replace", "path": "/spec/template/spec/containers/0/image", "value": "new"}, {"op": "add", "path": "/metadata/labels/a", "value": "b"}]`, 0),
+				patchPlugin("replacer", `[{"op": "replace", "path": "/spec/template", "value": {}}]`, 0),
+			},
+			PluginPriorities:     map[string]int{"replacer": 0},
+			PatchesString:        `[{"op": "add", "path": "/metadata/labels/a", "value": "b"}, {"op": "replace", "path": "/spec/template", "value": {}}]`,
+			IgnoredPatchesString: `[{"PluginName": "image", "Operation": {"op": "replace", "path": "/spec/template/spec/containers/0/image", "value": "new"}, "Reason": "PathPrefixConflict"}]`,
+		},
+		{
+			Name: "AddParentIsNotAConflict",
+			Plugins: []Plugin{
+				patchPlugin("parent", `[{"op": "add", "path": "/metadata/annotations", "value": {}}]`, 0),
+				patchPlugin("child", `[{"op": "add", "path": "/metadata/annotations/a", "value": "b"}]`, 0),
+			},
+			PatchesString: `[{"op": "add", "path": "/metadata/annotations", "value": {}}, {"op": "add", "path": "/metadata/annotations/a", "value": "b"}]`,
+		},
+		{
+			Name: "SamePluginIsNotAConflict",
+			Plugins: []Plugin{
+				patchPlugin("plugin", `[{"op": "remove", "path": "/spec/template/spec/nodeName"}, {"op": "replace", "path": "/spec/template", "value": {}}]`, 0),
+			},
+			PatchesString: `[{"op": "replace", "path": "/spec/template", "value": {}}, {"op": "remove", "path": "/spec/template/spec/nodeName"}]`,
+		},
+		{
+			Name: "SamePathConflictReason",
+			Plugins: []Plugin{
+				patchPlugin("plugin1", `[{"op": "add", "path": "/spec/testing", "value": "test"}]`, 0),
+				patchPlugin("plugin2", `[{"op": "remove", "path": "/spec/testing"}]`, 0),
+			},
+			PatchesString:        `[{"op": "add", "path": "/spec/testing", "value": "test"}]`,
+			IgnoredPatchesString: `[{"PluginName": "plugin2", "Operation": {"op": "remove", "path": "/spec/testing"}, "Reason": "PathConflict"}]`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			runner := Runner{Log: logrus.New(), PluginPriorities: c.PluginPriorities}
+			response, err := runner.Run(unstructured.Unstructured{}, c.Plugins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, err := jsonpatch.DecodePatch([]byte(c.PatchesString))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p2, err := jsonpatch.DecodePatch(response.TransformFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, _ := internaljsonpatch.Equal(p2, p); !ok {
+				t.Errorf("incorrect jsonpatch, actual: %v expected: %v", string(response.TransformFile), c.PatchesString)
+			}
+
+			ignored := []PluginOperation{}
+			if err := json.Unmarshal(response.IgnoredPatches, &ignored); err != nil {
+				t.Fatal(err)
+			}
+			expectedIgnored := []PluginOperation{}
+			if len(c.IgnoredPatchesString) > 0 {
+				if err := json.Unmarshal([]byte(c.IgnoredPatchesString), &expectedIgnored); err != nil {
+					t.Fatal(err)
 				}
-				p2, err := jsonpatch.DecodePatch(response.TransformFile)
-				if err != nil {
-					fmt.Printf("\n\n%v", string(response.TransformFile))
-					t.Error(err)
+			}
+			if !EqualPluginOperationList(ignored, expectedIgnored) {
+				t.Fatalf("incorrect ignored operations, actual: %v expected: %v", string(response.IgnoredPatches), c.IgnoredPatchesString)
+			}
+			for i := range ignored {
+				if ignored[i].Reason != expectedIgnored[i].Reason {
+					t.Errorf("incorrect ignored reason, actual: %v expected: %v", ignored[i].Reason, expectedIgnored[i].Reason)
 				}
+			}
+		})
+	}
+}
 
-				if ok, err := internaljsonpatch.Equal(p2, p); !ok || err != nil {
-					t.Errorf("incorrect jsonpathc, actual: %v expected: %v\nerror: %v", string(response.TransformFile), c.PatchesString, err)
-				}
+func TestRunnerRunWarnings(t *testing.T) {
+	warningPlugin := func(name string, version Version) fakePlugin {
+		return fakePlugin{
+			Func: func(request PluginRequest) (PluginResponse, error) {
+				return PluginResponse{
+					Version:     string(version),
+					Warnings:    []string{"careful"},
+					Annotations: map[string]string{"reason": "checked"},
+				}, nil
+			},
+			metadata: &PluginMetadata{
+				Name:            name,
+				RequestVersion:  []Version{version},
+				ResponseVersion: []Version{version},
+			},
+		}
+	}
+
+	// Log is left unset, the warnings go to the standard logger
+	runner := Runner{}
+	response, err := runner.Run(unstructured.Unstructured{}, []Plugin{warningPlugin("v1", V1), warningPlugin("v2", V2)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(response.Warnings, []PluginWarning{{PluginName: "v2", Message: "careful"}}) {
+		t.Errorf("expected only the warnings of the V2 plugin, got: %v", response.Warnings)
+	}
+	if !reflect.DeepEqual(response.Annotations, map[string]map[string]string{"v2": {"reason": "checked"}}) {
+		t.Errorf("expected only the annotations of the V2 plugin, got: %v", response.Annotations)
+	}
+}
+
+func TestRunnerRunV2(t *testing.T) {
+	deployment := unstructured.Unstructured{Object: map[string]interface{}{}}
+	deployment.SetGroupVersionKind(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"})
+	service := unstructured.Unstructured{Object: map[string]interface{}{}}
+	service.SetGroupVersionKind(schema.GroupVersionKind{Version: "v1", Kind: "Service"})
+
+	v2Plugin := fakePlugin{
+		Func: func(request PluginRequest) (PluginResponse, error) {
+			if request.Version != V2 {
+				return PluginResponse{}, fmt.Errorf("expected version %v, got %v", V2, request.Version)
 			}
-			// Two Bytes tells us that it is an empty list
-			if len(c.IgnoredPatchesString) != 0 || len(response.IgnoredPatches) > 2 {
-				ignoredPluginOperations := []PluginOperation{}
-				err := json.Unmarshal(response.IgnoredPatches, &ignoredPluginOperations)
-				if err != nil {
-					t.Error(err)
+			return PluginResponse{
+				Version:     string(V2),
+				IsWhiteOut:  true,
+				Warnings:    []string{"dropping deployment"},
+				Annotations: map[string]string{"reason": "replaced"},
+			}, nil
+		},
+		metadata: &PluginMetadata{
+			Name:            "v2",
+			RequestVersion:  []Version{V1, V2},
+			ResponseVersion: []Version{V1, V2},
+			GroupKinds:      []schema.GroupKind{{Group: "apps", Kind: "Deployment"}},
+		},
+	}
+	unknownVersion := fakePlugin{
+		Func: func(request PluginRequest) (PluginResponse, error) {
+			return PluginResponse{}, nil
+		},
+		metadata: &PluginMetadata{
+			Name:            "unknown",
+			RequestVersion:  []Version{"v3"},
+			ResponseVersion: []Version{"v3"},
+		},
+	}
+	runner := Runner{Log: logrus.New()}
+
+	response, err := runner.Run(deployment, []Plugin{v2Plugin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.HaveWhiteOut {
+		t.Errorf("expected the deployment to be whited out")
+	}
+	if !reflect.DeepEqual(response.Warnings, []PluginWarning{{PluginName: "v2", Message: "dropping deployment"}}) {
+		t.Errorf("incorrect warnings: %v", response.Warnings)
+	}
+	if !reflect.DeepEqual(response.Annotations, map[string]map[string]string{"v2": {"reason": "replaced"}}) {
+		t.Errorf("incorrect annotations: %v", response.Annotations)
+	}
+
+	response, err = runner.Run(service, []Plugin{v2Plugin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.HaveWhiteOut {
+		t.Errorf("plugin was called for a GroupKind it does not handle")
+	}
+
+	_, err = runner.Run(service, []Plugin{unknownVersion})
+	runnerErr, ok := err.(*errors.RunnerError)
+	if !ok || len(runnerErr.Failures) != 1 || runnerErr.Failures[0].Type != errors.PluginInvalidIOError {
+		t.Errorf("expected a version error, got: %v", err)
+	}
+}
+
+func newResourcePlugin(name string, version Version, whiteOut bool, resources ...unstructured.Unstructured) fakePlugin {
+	return fakePlugin{
+		Func: func(request PluginRequest) (PluginResponse, error) {
+			return PluginResponse{IsWhiteOut: whiteOut, NewResources: resources}, nil
+		},
+		metadata: &PluginMetadata{
+			Name:            name,
+			RequestVersion:  []Version{version},
+			ResponseVersion: []Version{version},
+		},
+	}
+}
+
+func newResource(kind, name, label string) unstructured.Unstructured {
+	u := unstructured.Unstructured{Object: map[string]interface{}{}}
+	u.SetAPIVersion("networking.k8s.io/v1")
+	u.SetKind(kind)
+	u.SetNamespace("test")
+	u.SetName(name)
+	if label != "" {
+		u.SetLabels(map[string]string{"from": label})
+	}
+	return u
+}
+
+func TestRunnerRunNewResources(t *testing.T) {
+	ingress := newResource("Ingress", "route", "plugin1")
+	policy := newResource("NetworkPolicy", "route", "")
+	duplicate := newResource("Ingress", "route", "plugin2")
+	invalid := unstructured.Unstructured{Object: map[string]interface{}{"kind": "Ingress"}}
+
+	cases := []struct {
+		Name             string
+		Plugins          []Plugin
+		PluginPriorities map[string]int
+		NewResources     []unstructured.Unstructured
+		IsWhiteOut       bool
+		ShouldError      bool
+	}{
+		{
+			Name:         "KeptWithWhiteOut",
+			Plugins:      []Plugin{newResourcePlugin("plugin1", V2, true, ingress, policy)},
+			NewResources: []unstructured.Unstructured{ingress, policy},
+			IsWhiteOut:   true,
+		},
+		{
+			Name:         "IgnoredFromV1",
+			Plugins:      []Plugin{newResourcePlugin("plugin1", V1, false, ingress)},
+			NewResources: nil,
+		},
+		{
+			Name:         "DuplicateFirstWins",
+			Plugins:      []Plugin{newResourcePlugin("plugin1", V2, false, ingress), newResourcePlugin("plugin2", V2, false, duplicate, policy)},
+			NewResources: []unstructured.Unstructured{ingress, policy},
+		},
+		{
+			Name:             "DuplicateHigherPriorityWins",
+			Plugins:          []Plugin{newResourcePlugin("plugin1", V2, false, ingress), newResourcePlugin("plugin2", V2, false, duplicate)},
+			PluginPriorities: map[string]int{"plugin2": 0},
+			NewResources:     []unstructured.Unstructured{duplicate},
+		},
+		{
+			Name:        "InvalidResource",
+			Plugins:     []Plugin{newResourcePlugin("plugin1", V2, false, invalid)},
+			ShouldError: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			runner := Runner{Log: logrus.New(), PluginPriorities: c.PluginPriorities}
+			response, err := runner.Run(unstructured.Unstructured{}, c.Plugins)
+			if (err != nil) != c.ShouldError {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+			if response.HaveWhiteOut != c.IsWhiteOut {
+				t.Errorf("incorrect white out determination, actual: %v expected: %v", response.HaveWhiteOut, c.IsWhiteOut)
+			}
+			if !reflect.DeepEqual(response.NewResources, c.NewResources) {
+				t.Errorf("incorrect new resources, actual: %v expected: %v", response.NewResources, c.NewResources)
+			}
+		})
+	}
+}
+
+type contextKey struct{}
+
+// contextPlugin reports the value stored in the context it was run with.
+type contextPlugin struct {
+	fakePlugin
+}
+
+func (c contextPlugin) RunWithContext(ctx context.Context, request PluginRequest) (PluginResponse, error) {
+	value, _ := ctx.Value(contextKey{}).(string)
+	return PluginResponse{Annotations: map[string]string{"context": value}}, nil
+}
+
+func TestRunnerRunWithContextPlugin(t *testing.T) {
+	plugin := contextPlugin{fakePlugin{name: "context", metadata: &PluginMetadata{
+		Name:            "context",
+		RequestVersion:  []Version{V2},
+		ResponseVersion: []Version{V2},
+	}}}
+	ctx := context.WithValue(context.Background(), contextKey{}, "passed")
+	response, err := (&Runner{Log: logrus.New()}).RunWithContext(ctx, unstructured.Unstructured{}, []Plugin{plugin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := response.Annotations["context"]["context"]; got != "passed" {
+		t.Errorf("plugin was not run with the context, got annotations %v", response.Annotations)
+	}
+}
+
+func mergePatchPlugin(name string, version Version, resp PluginResponse) fakePlugin {
+	return fakePlugin{
+		Func: func(request PluginRequest) (PluginResponse, error) {
+			return resp, nil
+		},
+		metadata: &PluginMetadata{
+			Name:            name,
+			RequestVersion:  []Version{version},
+			ResponseVersion: []Version{version},
+		},
+	}
+}
+
+func TestRunnerRunMergePatches(t *testing.T) {
+	deployment := unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "web"},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "sidecar", "image": "sidecar:1"},
+						map[string]interface{}{"name": "app", "image": "app:1"},
+					},
+				},
+			},
+		},
+	}}
+
+	cases := []struct {
+		Name             string
+		Plugins          []Plugin
+		PluginPriorities map[string]int
+		ExpectedPlugins  []string
+		Expected         string
+		ShouldError      bool
+	}{
+		{
+			Name: "StrategicMergePatch",
+			Plugins: []Plugin{mergePatchPlugin("plugin1", V2, PluginResponse{
+				StrategicMergePatch: []byte(`{"spec": {"template": {"spec": {"containers": [{"name": "app", "image": "app:2"}]}}}}`),
+			})},
+			ExpectedPlugins: []string{"plugin1"},
+			Expected: `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web"},
+				"spec": {"template": {"spec": {"containers": [{"name": "sidecar", "image": "sidecar:1"}, {"name": "app", "image": "app:2"}]}}}}`,
+		},
+		{
+			Name: "AllPatchKinds",
+			Plugins: []Plugin{
+				mergePatchPlugin("plugin1", V2, PluginResponse{
+					MergePatch:          []byte(`{"metadata": {"labels": {"migrated": "true"}}}`),
+					StrategicMergePatch: []byte(`{"spec": {"template": {"spec": {"containers": [{"name": "sidecar", "image": "sidecar:2"}]}}}}`),
+				}),
+				patchPlugin("plugin2", `[{"op": "add", "path": "/spec/replicas", "value": 2}]`, 0),
+			},
+			ExpectedPlugins: []string{"plugin1", "plugin1"},
+			Expected: `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "labels": {"migrated": "true"}},
+				"spec": {"replicas": 2, "template": {"spec": {"containers": [{"name": "sidecar", "image": "sidecar:2"}, {"name": "app", "image": "app:1"}]}}}}`,
+		},
+		{
+			Name: "ContainerAddedNextToHigherPriorityPatch",
+			Plugins: []Plugin{
+				patchPlugin("plugin1", `[{"op": "replace", "path": "/spec/template/spec/containers/0/image", "value": "sidecar:2"}]`, 0),
+				mergePatchPlugin("plugin2", V2, PluginResponse{
+					StrategicMergePatch: []byte(`{"spec": {"template": {"spec": {"containers": [{"name": "proxy", "image": "proxy:1"}]}}}}`),
+				}),
+			},
+			PluginPriorities: map[string]int{"plugin1": 0, "plugin2": 1},
+			ExpectedPlugins:  []string{"plugin2"},
+			Expected: `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web"},
+				"spec": {"template": {"spec": {"containers": [{"name": "proxy", "image": "proxy:1"}, {"name": "sidecar", "image": "sidecar:2"}, {"name": "app", "image": "app:1"}]}}}}`,
+		},
+		{
+			Name: "HigherPriorityAppliedLast",
+			Plugins: []Plugin{
+				mergePatchPlugin("plugin1", V2, PluginResponse{MergePatch: []byte(`{"metadata": {"labels": {"tier": "a"}}}`)}),
+				mergePatchPlugin("plugin2", V2, PluginResponse{MergePatch: []byte(`{"metadata": {"labels": {"tier": "b"}}}`)}),
+				mergePatchPlugin("plugin3", V2, PluginResponse{MergePatch: []byte(`{"metadata": {"labels": {"tier": "c"}}}`)}),
+			},
+			PluginPriorities: map[string]int{"plugin2": 0},
+			ExpectedPlugins:  []string{"plugin3", "plugin1", "plugin2"},
+			Expected: `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "labels": {"tier": "b"}},
+				"spec": {"template": {"spec": {"containers": [{"name": "sidecar", "image": "sidecar:1"}, {"name": "app", "image": "app:1"}]}}}}`,
+		},
+		{
+			Name: "IgnoredFromV1",
+			Plugins: []Plugin{mergePatchPlugin("plugin1", V1, PluginResponse{
+				MergePatch: []byte(`{"metadata": {"labels": {"migrated": "true"}}}`),
+			})},
+			ExpectedPlugins: []string{},
+			Expected: `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web"},
+				"spec": {"template": {"spec": {"containers": [{"name": "sidecar", "image": "sidecar:1"}, {"name": "app", "image": "app:1"}]}}}}`,
+		},
+		{
+			Name: "InvalidMergePatch",
+			Plugins: []Plugin{mergePatchPlugin("plugin1", V2, PluginResponse{
+				MergePatch: []byte(`{"metadata": `),
+			})},
+			ShouldError: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			runner := Runner{Log: logrus.New(), PluginPriorities: c.PluginPriorities}
+			response, err := runner.Run(deployment, c.Plugins)
+			if (err != nil) != c.ShouldError {
+				t.Fatalf("unexpected error result: %v", err)
+			}
+			if c.ShouldError {
+				return
+			}
+			plugins := []string{}
+			for _, mergePatch := range response.MergePatches {
+				plugins = append(plugins, mergePatch.PluginName)
+			}
+			if !reflect.DeepEqual(plugins, c.ExpectedPlugins) {
+				t.Errorf("incorrect merge patch order, actual: %v expected: %v", plugins, c.ExpectedPlugins)
+			}
+
+			doc, err := apply.Applier{}.Apply(deployment, response.TransformFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, mergePatch := range response.MergePatches {
+				patched := unstructured.Unstructured{}
+				if err := patched.UnmarshalJSON(doc); err != nil {
+					t.Fatal(err)
 				}
-				expectedIgnoredPluginOperations := []PluginOperation{}
-				err = json.Unmarshal([]byte(c.IgnoredPatchesString), &expectedIgnoredPluginOperations)
+				doc, err = apply.Applier{}.ApplyPatch(patched, mergePatch.Patch, mergePatch.Type)
 				if err != nil {
-					t.Error(err)
-				}
-				if ok := EqualPluginOperationList(ignoredPluginOperations, expectedIgnoredPluginOperations); !ok || err != nil {
-					t.Errorf("incorrect plugin operations, actual: %v expected: %v", string(response.IgnoredPatches), c.IgnoredPatchesString)
+					t.Fatal(err)
 				}
 			}
+			var actual, expected interface{}
+			if err := json.Unmarshal(doc, &actual); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(c.Expected), &expected); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("incorrect object, actual: %s expected: %s", doc, c.Expected)
+			}
 		})
 	}
-
 }
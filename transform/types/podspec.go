@@ -0,0 +1,205 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// PodSpecLocation is a PodSpec embedded in an object.
+type PodSpecLocation struct {
+	// Path is the JSON Pointer of the PodSpec in the object, such as
+	// /spec/template/spec.
+	Path string
+	Spec v1.PodSpec
+}
+
+// ContainerLocation is a container, init container or ephemeral container of
+// a PodSpec.
+type ContainerLocation struct {
+	// Path is the JSON Pointer of the container in the object, such as
+	// /spec/template/spec/initContainers/0.
+	Path  string
+	Name  string
+	Image string
+	Env   []v1.EnvVar
+}
+
+// ImageReference is a field of an object holding an image pull spec.
+type ImageReference struct {
+	// Path is the JSON Pointer of the field, such as
+	// /spec/template/spec/containers/0/image.
+	Path  string
+	Image string
+}
+
+var (
+	templateSpec    = []string{"spec", "template", "spec"}
+	jobTemplateSpec = []string{"spec", "jobTemplate", "spec", "template", "spec"}
+)
+
+// podSpecPaths are the paths to the PodSpecs of the built-in kinds and of
+// the known CRDs embedding a PodSpec.
+var podSpecPaths = map[schema.GroupKind][][]string{
+	{Group: "", Kind: "Pod"}:                               {{"spec"}},
+	{Group: "", Kind: "PodTemplate"}:                       {{"template", "spec"}},
+	{Group: "", Kind: "ReplicationController"}:             {templateSpec},
+	{Group: "apps", Kind: "DaemonSet"}:                     {templateSpec},
+	{Group: "apps", Kind: "Deployment"}:                    {templateSpec},
+	{Group: "apps", Kind: "ReplicaSet"}:                    {templateSpec},
+	{Group: "apps", Kind: "StatefulSet"}:                   {templateSpec},
+	{Group: "batch", Kind: "Job"}:                          {templateSpec},
+	{Group: "batch", Kind: "CronJob"}:                      {jobTemplateSpec},
+	{Group: "extensions", Kind: "DaemonSet"}:               {templateSpec},
+	{Group: "extensions", Kind: "Deployment"}:              {templateSpec},
+	{Group: "extensions", Kind: "ReplicaSet"}:              {templateSpec},
+	{Group: "apps.openshift.io", Kind: "DeploymentConfig"}: {templateSpec},
+	{Group: "", Kind: "DeploymentConfig"}:                  {templateSpec},
+	{Group: "argoproj.io", Kind: "Rollout"}:                {templateSpec},
+	{Group: "serving.knative.dev", Kind: "Service"}:        {templateSpec},
+	{Group: "serving.knative.dev", Kind: "Configuration"}:  {templateSpec},
+	{Group: "serving.knative.dev", Kind: "Revision"}:       {{"spec"}},
+	{Group: "keda.sh", Kind: "ScaledJob"}:                  {{"spec", "jobTargetRef", "template", "spec"}},
+}
+
+// deploymentConfigGKs are the kinds of the OpenShift DeploymentConfigs, which
+// keep the image resolved by their image change triggers in the trigger.
+var deploymentConfigGKs = []schema.GroupKind{
+	{Group: "apps.openshift.io", Kind: "DeploymentConfig"},
+	{Group: "", Kind: "DeploymentConfig"},
+}
+
+// PodSpecs returns every PodSpec embedded in the object, in the order of
+// their paths. The paths of the built-in kinds and of known CRDs, such as
+// CronJobs (/spec/jobTemplate/spec/template/spec) or DeploymentConfigs, are
+// used for their kinds. For any other kind, the PodSpecs in the
+// spec.template.spec and spec.jobTemplate.spec.template.spec fields are
+// returned.
+func PodSpecs(u unstructured.Unstructured) ([]PodSpecLocation, error) {
+	content, err := jsonContent(u)
+	if err != nil {
+		return nil, err
+	}
+	paths, known := podSpecPaths[u.GroupVersionKind().GroupKind()]
+	if !known {
+		paths = [][]string{templateSpec, jobTemplateSpec}
+	}
+
+	locations := []PodSpecLocation{}
+	for _, path := range paths {
+		field, found, err := unstructured.NestedFieldNoCopy(content, path...)
+		if err != nil || !found {
+			// Unknown kinds may use these fields for something else
+			continue
+		}
+		if _, ok := field.(map[string]interface{}); !ok {
+			if known {
+				return nil, fmt.Errorf("%v is not a PodSpec", pointer(path))
+			}
+			continue
+		}
+		spec := v1.PodSpec{}
+		if err := convert(field, &spec); err != nil {
+			if known {
+				return nil, fmt.Errorf("%v is not a PodSpec: %v", pointer(path), err)
+			}
+			continue
+		}
+		locations = append(locations, PodSpecLocation{Path: pointer(path), Spec: spec})
+	}
+	return locations, nil
+}
+
+// Containers returns the containers, init containers and ephemeral
+// containers of the PodSpec.
+func (p PodSpecLocation) Containers() []ContainerLocation {
+	containers := []ContainerLocation{}
+	for i, c := range p.Spec.Containers {
+		containers = append(containers, ContainerLocation{Path: fmt.Sprintf("%v/containers/%v", p.Path, i), Name: c.Name, Image: c.Image, Env: c.Env})
+	}
+	for i, c := range p.Spec.InitContainers {
+		containers = append(containers, ContainerLocation{Path: fmt.Sprintf("%v/initContainers/%v", p.Path, i), Name: c.Name, Image: c.Image, Env: c.Env})
+	}
+	for i, c := range p.Spec.EphemeralContainers {
+		containers = append(containers, ContainerLocation{Path: fmt.Sprintf("%v/ephemeralContainers/%v", p.Path, i), Name: c.Name, Image: c.Image, Env: c.Env})
+	}
+	return containers
+}
+
+// ImageReferences returns every image of the object, the images of the
+// containers of its PodSpecs and, for DeploymentConfigs, the last image
+// resolved by each image change trigger. Empty images are skipped.
+func ImageReferences(u unstructured.Unstructured) ([]ImageReference, error) {
+	podSpecs, err := PodSpecs(u)
+	if err != nil {
+		return nil, err
+	}
+	references := []ImageReference{}
+	for _, podSpec := range podSpecs {
+		for _, c := range podSpec.Containers() {
+			if strings.TrimSpace(c.Image) != "" {
+				references = append(references, ImageReference{Path: c.Path + "/image", Image: c.Image})
+			}
+		}
+	}
+	if !isDeploymentConfig(u) {
+		return references, nil
+	}
+
+	content, err := jsonContent(u)
+	if err != nil {
+		return nil, err
+	}
+	triggers, _, err := unstructured.NestedSlice(content, "spec", "triggers")
+	if err != nil {
+		return nil, err
+	}
+	for i, trigger := range triggers {
+		t, ok := trigger.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		image, found, err := unstructured.NestedString(t, "imageChangeParams", "lastTriggeredImage")
+		if err != nil || !found || image == "" {
+			continue
+		}
+		references = append(references, ImageReference{Path: fmt.Sprintf("/spec/triggers/%v/imageChangeParams/lastTriggeredImage", i), Image: image})
+	}
+	return references, nil
+}
+
+func isDeploymentConfig(u unstructured.Unstructured) bool {
+	gk := u.GroupVersionKind().GroupKind()
+	for _, dc := range deploymentConfigGKs {
+		if gk == dc {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonContent returns the content of the object as decoded from JSON, objects
+// built in code may hold typed values in place of maps.
+func jsonContent(u unstructured.Unstructured) (map[string]interface{}, error) {
+	content := map[string]interface{}{}
+	if err := convert(u.Object, &content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+func convert(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func pointer(path []string) string {
+	return "/" + strings.Join(path, "/")
+}
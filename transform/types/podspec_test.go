@@ -0,0 +1,158 @@
+package types_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/konveyor/crane-lib/transform/types"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func podSpec(image string) map[string]interface{} {
+	return map[string]interface{}{
+		"containers": []interface{}{
+			map[string]interface{}{"name": "app", "image": image},
+		},
+	}
+}
+
+func TestPodSpecs(t *testing.T) {
+	cases := []struct {
+		Name        string
+		Object      unstructured.Unstructured
+		Paths       []string
+		ShouldError bool
+	}{
+		{
+			Name: "Pod",
+			Object: unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"spec":       podSpec("app"),
+			}},
+			Paths: []string{"/spec"},
+		},
+		{
+			Name: "CronJob",
+			Object: unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "batch/v1",
+				"kind":       "CronJob",
+				"spec": map[string]interface{}{
+					"jobTemplate": map[string]interface{}{
+						"spec": map[string]interface{}{
+							"template": map[string]interface{}{"spec": podSpec("app")},
+						},
+					},
+				},
+			}},
+			Paths: []string{"/spec/jobTemplate/spec/template/spec"},
+		},
+		{
+			Name: "ScaledJob",
+			Object: unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "keda.sh/v1alpha1",
+				"kind":       "ScaledJob",
+				"spec": map[string]interface{}{
+					"jobTargetRef": map[string]interface{}{
+						"template": map[string]interface{}{"spec": podSpec("app")},
+					},
+				},
+			}},
+			Paths: []string{"/spec/jobTargetRef/template/spec"},
+		},
+		{
+			Name: "UnknownKindWithTemplate",
+			Object: unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "example.com/v1",
+				"kind":       "Custom",
+				"spec": map[string]interface{}{
+					"template": map[string]interface{}{"spec": podSpec("app")},
+				},
+			}},
+			Paths: []string{"/spec/template/spec"},
+		},
+		{
+			Name: "UnknownKindWithOtherTemplate",
+			Object: unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "example.com/v1",
+				"kind":       "Custom",
+				"spec": map[string]interface{}{
+					"template": map[string]interface{}{"spec": "not a pod spec"},
+				},
+			}},
+			Paths: []string{},
+		},
+		{
+			Name: "InvalidPodSpec",
+			Object: unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"spec": map[string]interface{}{
+					"template": map[string]interface{}{"spec": map[string]interface{}{"containers": "app"}},
+				},
+			}},
+			ShouldError: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			podSpecs, err := types.PodSpecs(c.Object)
+			if (err != nil) != c.ShouldError {
+				t.Fatalf("got error %v, expected an error: %v", err, c.ShouldError)
+			}
+			if c.ShouldError {
+				return
+			}
+			paths := []string{}
+			for _, p := range podSpecs {
+				paths = append(paths, p.Path)
+				if len(p.Spec.Containers) != 1 || p.Spec.Containers[0].Image != "app" {
+					t.Errorf("unexpected PodSpec at %v: %+v", p.Path, p.Spec)
+				}
+			}
+			if !reflect.DeepEqual(paths, c.Paths) {
+				t.Errorf("got paths %v, expected %v", paths, c.Paths)
+			}
+		})
+	}
+}
+
+func TestImageReferences(t *testing.T) {
+	spec := podSpec("app")
+	spec["initContainers"] = []interface{}{
+		map[string]interface{}{"name": "init", "image": "init"},
+		map[string]interface{}{"name": "empty"},
+	}
+	spec["ephemeralContainers"] = []interface{}{
+		map[string]interface{}{"name": "debug", "image": "debug"},
+	}
+	dc := unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps.openshift.io/v1",
+		"kind":       "DeploymentConfig",
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{"spec": spec},
+			"triggers": []interface{}{
+				map[string]interface{}{"type": "ConfigChange"},
+				map[string]interface{}{
+					"type":              "ImageChange",
+					"imageChangeParams": map[string]interface{}{"lastTriggeredImage": "app@sha256:abc"},
+				},
+			},
+		},
+	}}
+
+	references, err := types.ImageReferences(dc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []types.ImageReference{
+		{Path: "/spec/template/spec/containers/0/image", Image: "app"},
+		{Path: "/spec/template/spec/initContainers/0/image", Image: "init"},
+		{Path: "/spec/template/spec/ephemeralContainers/0/image", Image: "debug"},
+		{Path: "/spec/triggers/1/imageChangeParams/lastTriggeredImage", Image: "app@sha256:abc"},
+	}
+	if !reflect.DeepEqual(references, expected) {
+		t.Errorf("got %+v, expected %+v", references, expected)
+	}
+}
@@ -0,0 +1,184 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ImageRuleSet is the content of an image rule file, in YAML or JSON.
+type ImageRuleSet struct {
+	Rules []ImageRule `json:"rules"`
+}
+
+// ImageRule rewrites the images matching it. The steps of a rule are applied
+// in the order of its fields: Replace, Tag, MirrorMapping, then DropDigest.
+type ImageRule struct {
+	// Match is a regular expression matched against the whole image, such as
+	// quay.io/(.*)/app:.*.
+	Match string `json:"match"`
+	// Replace is the new image, $1 or ${name} are expanded to the groups
+	// captured by Match.
+	Replace string `json:"replace,omitempty"`
+	// Tag is set as the tag of the image, dropping its digest.
+	Tag string `json:"tag,omitempty"`
+	// MirrorMapping is the path of a mapping file in the format written by
+	// oc adm catalog mirror, one source=mirror line per image. An image listed
+	// as a source is replaced by its mirror, a line mapping a tag to a digest
+	// resolves the tag.
+	MirrorMapping string `json:"mirrorMapping,omitempty"`
+	// DropDigest drops the digest of the image when the rule moved it to
+	// another repository without resolving a new digest, as mirroring may
+	// change the digest.
+	DropDigest bool `json:"dropDigest,omitempty"`
+}
+
+// ImageRewriter rewrites images with the first matching rule of a rule set.
+type ImageRewriter struct {
+	rules []compiledImageRule
+}
+
+type compiledImageRule struct {
+	ImageRule
+	match   *regexp.Regexp
+	mapping map[string]string
+}
+
+// LoadImageRules reads an image rule file and the mirror mappings it refers
+// to. Relative mapping paths are relative to the directory of the rule file.
+func LoadImageRules(file string) (*ImageRewriter, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read image rule file %v: %v", file, err)
+	}
+	ruleSet := ImageRuleSet{}
+	if err := yaml.UnmarshalStrict(data, &ruleSet); err != nil {
+		return nil, fmt.Errorf("invalid image rule file %v: %v", file, err)
+	}
+	for i, rule := range ruleSet.Rules {
+		if rule.MirrorMapping != "" && !filepath.IsAbs(rule.MirrorMapping) {
+			ruleSet.Rules[i].MirrorMapping = filepath.Join(filepath.Dir(file), rule.MirrorMapping)
+		}
+	}
+	rewriter, err := NewImageRewriter(ruleSet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image rule file %v: %v", file, err)
+	}
+	return rewriter, nil
+}
+
+// NewImageRewriter validates the rules and reads their mirror mappings.
+func NewImageRewriter(ruleSet ImageRuleSet) (*ImageRewriter, error) {
+	rewriter := &ImageRewriter{}
+	for i, rule := range ruleSet.Rules {
+		c := compiledImageRule{ImageRule: rule}
+		if rule.Match == "" {
+			return nil, fmt.Errorf("image rule %v: match is required", i)
+		}
+		match, err := regexp.Compile("^(?:" + rule.Match + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("image rule %v: invalid match %q: %v", i, rule.Match, err)
+		}
+		c.match = match
+		if strings.ContainsAny(rule.Tag, ":@/") {
+			return nil, fmt.Errorf("image rule %v: invalid tag %q", i, rule.Tag)
+		}
+		if rule.MirrorMapping != "" {
+			c.mapping, err = loadMirrorMapping(rule.MirrorMapping)
+			if err != nil {
+				return nil, fmt.Errorf("image rule %v: %v", i, err)
+			}
+		}
+		rewriter.rules = append(rewriter.rules, c)
+	}
+	return rewriter, nil
+}
+
+// loadMirrorMapping reads the source=mirror lines of a mapping file, empty
+// lines and lines starting with # are skipped.
+func loadMirrorMapping(file string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read mirror mapping %v: %v", file, err)
+	}
+	mapping := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		split := strings.SplitN(text, "=", 2)
+		if len(split) != 2 || split[0] == "" || split[1] == "" {
+			return nil, fmt.Errorf("invalid mirror mapping %v, line %v: expected source=mirror", file, line)
+		}
+		mapping[split[0]] = split[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read mirror mapping %v: %v", file, err)
+	}
+	return mapping, nil
+}
+
+// Rewrite returns the image rewritten by the first rule matching it, and
+// whether it changed. A nil ImageRewriter does not change any image.
+func (r *ImageRewriter) Rewrite(image string) (string, bool) {
+	if r == nil {
+		return image, false
+	}
+	for _, rule := range r.rules {
+		indexes := rule.match.FindStringSubmatchIndex(image)
+		if indexes == nil {
+			continue
+		}
+		rewritten := rule.rewrite(image, indexes)
+		return rewritten, rewritten != image
+	}
+	return image, false
+}
+
+func (c compiledImageRule) rewrite(image string, indexes []int) string {
+	rewritten := image
+	if c.Replace != "" {
+		rewritten = string(c.match.ExpandString(nil, c.Replace, image, indexes))
+	}
+	if c.Tag != "" {
+		repository, _, _ := SplitImage(rewritten)
+		rewritten = repository + ":" + c.Tag
+	}
+	if mirror, ok := c.mapping[rewritten]; ok {
+		rewritten = mirror
+	}
+	if c.DropDigest {
+		repository, tag, digest := SplitImage(rewritten)
+		sourceRepository, _, sourceDigest := SplitImage(image)
+		if digest != "" && digest == sourceDigest && repository != sourceRepository {
+			rewritten = repository
+			if tag != "" {
+				rewritten += ":" + tag
+			}
+		}
+	}
+	return rewritten
+}
+
+// SplitImage splits an image into its repository, tag and digest, such as
+// quay.io:443/org/app, v1 and sha256:abc for quay.io:443/org/app:v1@sha256:abc.
+// The tag and digest are empty when the image does not have them.
+func SplitImage(image string) (repository, tag, digest string) {
+	repository = image
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository, digest = repository[:i], repository[i+1:]
+	}
+	// A colon before the last slash is the port of the registry
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, tag = repository[:i], repository[i+1:]
+	}
+	return repository, tag, digest
+}
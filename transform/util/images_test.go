@@ -0,0 +1,146 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitImage(t *testing.T) {
+	cases := []struct {
+		Image      string
+		Repository string
+		Tag        string
+		Digest     string
+	}{
+		{Image: "app", Repository: "app"},
+		{Image: "quay.io/org/app:v1", Repository: "quay.io/org/app", Tag: "v1"},
+		{Image: "registry:5000/org/app", Repository: "registry:5000/org/app"},
+		{Image: "registry:5000/app:v1@sha256:abc", Repository: "registry:5000/app", Tag: "v1", Digest: "sha256:abc"},
+		{Image: "quay.io/app@sha256:abc", Repository: "quay.io/app", Digest: "sha256:abc"},
+	}
+	for _, c := range cases {
+		repository, tag, digest := SplitImage(c.Image)
+		if repository != c.Repository || tag != c.Tag || digest != c.Digest {
+			t.Errorf("SplitImage(%v) = %v, %v, %v, expected %v, %v, %v", c.Image, repository, tag, digest, c.Repository, c.Tag, c.Digest)
+		}
+	}
+}
+
+func TestLoadImageRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mapping := `# mirrored by oc adm catalog mirror
+registry.redhat.io/org/operator@sha256:111=mirror.example.com/org-operator:8a3f
+registry.redhat.io/org/bundle:v2=mirror.example.com/org-bundle@sha256:222
+`
+	rules := `rules:
+- match: registry.redhat.io/.*
+  mirrorMapping: mapping.txt
+- match: docker.io/library/(?P<name>[^:@]+)(.*)
+  replace: quay.io/mirror/${name}$2
+  dropDigest: true
+- match: quay.io/org/app(:.*)?
+  tag: v1.2.3
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "mapping.txt"), []byte(mapping), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "rules.yaml"), []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rewriter, err := LoadImageRules(filepath.Join(dir, "rules.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		Name    string
+		Image   string
+		Updated string
+		Changed bool
+	}{
+		{
+			Name:    "MirrorByDigest",
+			Image:   "registry.redhat.io/org/operator@sha256:111",
+			Updated: "mirror.example.com/org-operator:8a3f",
+			Changed: true,
+		},
+		{
+			Name:    "ResolveTagToDigest",
+			Image:   "registry.redhat.io/org/bundle:v2",
+			Updated: "mirror.example.com/org-bundle@sha256:222",
+			Changed: true,
+		},
+		{
+			Name:  "NotInMapping",
+			Image: "registry.redhat.io/org/other:v1",
+		},
+		{
+			Name:    "RegexCaptures",
+			Image:   "docker.io/library/nginx:1.21",
+			Updated: "quay.io/mirror/nginx:1.21",
+			Changed: true,
+		},
+		{
+			Name:    "DropDigest",
+			Image:   "docker.io/library/nginx:1.21@sha256:333",
+			Updated: "quay.io/mirror/nginx:1.21",
+			Changed: true,
+		},
+		{
+			Name:    "Retag",
+			Image:   "quay.io/org/app:latest@sha256:444",
+			Updated: "quay.io/org/app:v1.2.3",
+			Changed: true,
+		},
+		{
+			Name:    "PinTag",
+			Image:   "quay.io/org/app",
+			Updated: "quay.io/org/app:v1.2.3",
+			Changed: true,
+		},
+		{
+			Name:  "NoMatch",
+			Image: "quay.io/org/application:v1",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			updated, changed := rewriter.Rewrite(c.Image)
+			if changed != c.Changed {
+				t.Errorf("expected changed to be %v, got %v", c.Changed, changed)
+			}
+			if c.Changed && updated != c.Updated {
+				t.Errorf("expected %v, got %v", c.Updated, updated)
+			}
+			if !c.Changed && updated != c.Image {
+				t.Errorf("expected the image to be kept, got %v", updated)
+			}
+		})
+	}
+}
+
+func TestNewImageRewriterInvalid(t *testing.T) {
+	cases := []struct {
+		Name string
+		Rule ImageRule
+	}{
+		{Name: "MissingMatch", Rule: ImageRule{Tag: "v1"}},
+		{Name: "InvalidMatch", Rule: ImageRule{Match: "(quay.io"}},
+		{Name: "InvalidTag", Rule: ImageRule{Match: ".*", Tag: "v1@sha256:abc"}},
+		{Name: "MissingMapping", Rule: ImageRule{Match: ".*", MirrorMapping: "does-not-exist.txt"}},
+	}
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			if _, err := NewImageRewriter(ImageRuleSet{Rules: []ImageRule{c.Rule}}); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}